@@ -13,6 +13,7 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/tbxark/rsk/pkg/rsk/client"
+	"github.com/tbxark/rsk/pkg/rsk/common"
 	"github.com/tbxark/rsk/pkg/rsk/version"
 )
 
@@ -33,7 +34,10 @@ func main() {
 	logger.Info("RSK Client starting",
 		"server", cfg.ServerAddr,
 		"port", cfg.Port,
+		"extra_ports", cfg.ExtraPorts,
+		"best_effort_ports", cfg.BestEffortPorts,
 		"name", cfg.Name,
+		"labels", cfg.Labels,
 		"token_validated", true,
 		"allow_private_networks", cfg.AllowPrivateNetworks,
 		"blocked_networks", cfg.BlockedNetworks)
@@ -68,20 +72,90 @@ func parseFlags() (*client.Config, error) {
 		serverAddr           string
 		token                string
 		port                 int
+		extraPortsStr        string
+		bestEffortPorts      bool
 		name                 string
 		dialTimeout          time.Duration
 		allowPrivateNetworks bool
 		blockedNetworksStr   string
+		insecureSkipVerify   bool
+		proxy                string
+		connections          int
+		labelsStr            string
+		publicIP             string
+		dnsServersStr        string
+		dnsPrefer            string
+		dnsMaxTTL            time.Duration
+		dnsNegativeTTL       time.Duration
+		ipMode               string
+		portIPModeStr        string
+		sourceAddrsStr       string
+		portSourceAddrsStr   string
+		egressProxy          string
+		portEgressProxyStr   string
+		allowPortsStr        string
+		denyPortsStr         string
+		blockAbusePorts      bool
+		domainDefault        string
+		allowDomainsStr      string
+		denyDomainsStr       string
+		allowDomainFilesStr  string
+		denyDomainFilesStr   string
+		domainReload         time.Duration
+		auditLogFile         string
+		auditLogMaxSize      int64
+		auditLogMaxAge       time.Duration
+		auditLogBackups      int
+		bandwidthUpStr       string
+		bandwidthDownStr     string
+		quotaStr             string
+		quotaPeriod          string
+		quotaStateFile       string
 		showVersion          bool
 	)
 
-	pflag.StringVar(&serverAddr, "server", "", "Server address (required)")
+	pflag.StringVar(&serverAddr, "server", "", "Server address as host:port, ws(s)://host[:port]/path or quic://host:port (required)")
 	pflag.StringVar(&token, "token", "", "Authentication token (required)")
 	pflag.IntVar(&port, "port", 0, "Port to claim on the server (required)")
+	pflag.StringVar(&extraPortsStr, "extra-ports", "", "Further ports or ranges to claim in the same session, e.g. 20002,20005-20007 (comma-separated)")
+	pflag.BoolVar(&bestEffortPorts, "best-effort-ports", false, "Connect with the ports the server can bind and retry the others in the background, instead of failing")
 	pflag.StringVar(&name, "name", "", "Client name for identification (optional, defaults to hostname)")
 	pflag.DurationVar(&dialTimeout, "dial-timeout", 15*time.Second, "Timeout for dialing target addresses")
 	pflag.BoolVar(&allowPrivateNetworks, "allow-private-networks", false, "Allow connections to private IP ranges")
 	pflag.StringVar(&blockedNetworksStr, "blocked-networks", "", "Additional CIDR blocks to block (comma-separated)")
+	pflag.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Skip TLS certificate verification for wss:// and quic:// servers")
+	pflag.StringVar(&proxy, "proxy", "", "Proxy for the server connection (http://, https://, socks5:// URL or \"direct\"; defaults to HTTPS_PROXY/ALL_PROXY)")
+	pflag.IntVar(&connections, "connections", 1, "Number of parallel control connections forming one striped session (1-16)")
+	pflag.StringVar(&labelsStr, "labels", "", "Labels announced to the server, e.g. region=eu-west,isp=acme (comma-separated key=value)")
+	pflag.StringVar(&publicIP, "public-ip", "", "Egress public IP announced to the server (defaults to a public --source-addr)")
+	pflag.StringVar(&dnsServersStr, "dns", "", "DNS servers for resolving targets: IP[:port], udp://, tcp:// or https:// DoH URLs (comma-separated, defaults to the system resolver)")
+	pflag.StringVar(&dnsPrefer, "dns-prefer", "", "Address family listed first in resolved answers (ipv4 or ipv6); the family dialed first is set by --ip-mode")
+	pflag.DurationVar(&dnsMaxTTL, "dns-max-ttl", time.Hour, "Upper bound for cached DNS answers")
+	pflag.DurationVar(&dnsNegativeTTL, "dns-negative-ttl", 30*time.Second, "How long nonexistent names are cached when the server gives no TTL")
+	pflag.StringVar(&ipMode, "ip-mode", client.IPModeHappyEyeballs, "Address families to dial targets over: ipv4-only, ipv6-only, prefer-ipv4 or happy-eyeballs")
+	pflag.StringVar(&portIPModeStr, "port-ip-mode", "", "IP mode per claimed port, as port=mode (comma-separated), replacing --ip-mode for that port")
+	pflag.StringVar(&sourceAddrsStr, "source-addr", "", "Local IPs or interface names to dial targets from, rotated per connection (comma-separated)")
+	pflag.StringVar(&portSourceAddrsStr, "port-source-addr", "", "Source addresses per claimed port, as port=addr[|addr...] (comma-separated), replacing --source-addr for that port")
+	pflag.StringVar(&egressProxy, "egress-proxy", "", "Upstream proxy to reach targets through (http://, https:// or socks5:// URL, empty dials directly)")
+	pflag.StringVar(&portEgressProxyStr, "port-egress-proxy", "", "Egress proxy per claimed port, as port=url or port=direct (comma-separated), replacing --egress-proxy for that port")
+	pflag.StringVar(&allowPortsStr, "allow-ports", "", "Allowed destination ports or ranges, e.g. 80,443,8000-8999 (comma-separated, empty allows all)")
+	pflag.StringVar(&denyPortsStr, "deny-ports", "", "Denied destination ports or ranges (comma-separated)")
+	pflag.BoolVar(&blockAbusePorts, "block-abuse-ports", false, "Deny ports commonly abused through proxies (SSH, Telnet, SMTP, SMB, RDP, VNC, IRC)")
+	pflag.StringVar(&domainDefault, "domain-default", "allow", "Decision for targets no domain rule matches (allow or deny)")
+	pflag.StringVar(&allowDomainsStr, "allow-domains", "", "Allowed domain patterns, e.g. example.com,*.example.com,.example.org (comma-separated)")
+	pflag.StringVar(&denyDomainsStr, "deny-domains", "", "Denied domain patterns, winning over allowed ones (comma-separated)")
+	pflag.StringVar(&allowDomainFilesStr, "allow-domains-file", "", "Plain-text or hosts-style files with allowed domains (comma-separated)")
+	pflag.StringVar(&denyDomainFilesStr, "deny-domains-file", "", "Plain-text or hosts-style files with denied domains (comma-separated)")
+	pflag.DurationVar(&domainReload, "domain-reload-interval", 30*time.Second, "How often domain list files are checked for changes")
+	pflag.StringVar(&auditLogFile, "audit-log", "", "File to write the egress audit log to, one JSON line per destination (disabled if empty)")
+	pflag.Int64Var(&auditLogMaxSize, "audit-log-max-size", 100, "Rotate the audit log after this many megabytes (0 disables)")
+	pflag.DurationVar(&auditLogMaxAge, "audit-log-max-age", 24*time.Hour, "Rotate the audit log after this duration (0 disables)")
+	pflag.IntVar(&auditLogBackups, "audit-log-backups", 7, "Number of rotated audit log files to keep (0 keeps all)")
+	pflag.StringVar(&bandwidthUpStr, "bandwidth-up", "", "Upload limit across all streams in bytes per second, e.g. 512K or 10M (empty is unlimited)")
+	pflag.StringVar(&bandwidthDownStr, "bandwidth-down", "", "Download limit across all streams in bytes per second (empty is unlimited)")
+	pflag.StringVar(&quotaStr, "quota", "", "Data quota per period in bytes, both directions, e.g. 500G (empty disables)")
+	pflag.StringVar(&quotaPeriod, "quota-period", client.QuotaMonthly, "Rolling window the data quota covers (daily: 24 hours, monthly: 30 days)")
+	pflag.StringVar(&quotaStateFile, "quota-state", "", "File persisting quota usage across restarts (kept in memory if empty)")
 	pflag.BoolVarP(&showVersion, "version", "v", false, "Show version information")
 
 	pflag.Parse()
@@ -118,13 +192,92 @@ func parseFlags() (*client.Config, error) {
 		blockedNetworks = client.ParseCommaSeparated(blockedNetworksStr)
 	}
 
+	extraPorts, err := client.ParsePorts(extraPortsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := client.ParseLabels(labelsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	portIPMode, err := client.ParsePortIPMode(portIPModeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	portSourceAddrs, err := client.ParsePortSourceAddrs(portSourceAddrsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	portEgressProxy, err := client.ParsePortEgressProxy(portEgressProxyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	bandwidthUp, err := common.ParseByteSize(bandwidthUpStr)
+	if err != nil {
+		return nil, err
+	}
+	bandwidthDown, err := common.ParseByteSize(bandwidthDownStr)
+	if err != nil {
+		return nil, err
+	}
+	quotaBytes, err := common.ParseByteSize(quotaStr)
+	if err != nil {
+		return nil, err
+	}
+
 	return &client.Config{
 		ServerAddr:           serverAddr,
 		Token:                []byte(token),
 		Port:                 port,
+		ExtraPorts:           extraPorts,
+		BestEffortPorts:      bestEffortPorts,
 		Name:                 name,
 		DialTimeout:          dialTimeout,
 		AllowPrivateNetworks: allowPrivateNetworks,
 		BlockedNetworks:      blockedNetworks,
+		InsecureSkipVerify:   insecureSkipVerify,
+		Proxy:                proxy,
+		Connections:          connections,
+		Labels:               labels,
+		PublicIP:             publicIP,
+
+		DNSServers:     client.ParseCommaSeparated(dnsServersStr),
+		DNSPrefer:      dnsPrefer,
+		DNSMaxTTL:      dnsMaxTTL,
+		DNSNegativeTTL: dnsNegativeTTL,
+
+		IPMode:          ipMode,
+		PortIPMode:      portIPMode,
+		SourceAddrs:     client.ParseCommaSeparated(sourceAddrsStr),
+		PortSourceAddrs: portSourceAddrs,
+		EgressProxy:     egressProxy,
+		PortEgressProxy: portEgressProxy,
+
+		AllowPorts:      client.ParseCommaSeparated(allowPortsStr),
+		DenyPorts:       client.ParseCommaSeparated(denyPortsStr),
+		BlockAbusePorts: blockAbusePorts,
+
+		DomainDefault:        domainDefault,
+		AllowDomains:         client.ParseCommaSeparated(allowDomainsStr),
+		DenyDomains:          client.ParseCommaSeparated(denyDomainsStr),
+		AllowDomainFiles:     client.ParseCommaSeparated(allowDomainFilesStr),
+		DenyDomainFiles:      client.ParseCommaSeparated(denyDomainFilesStr),
+		DomainReloadInterval: domainReload,
+
+		AuditLogFile:       auditLogFile,
+		AuditLogMaxSize:    auditLogMaxSize * 1024 * 1024,
+		AuditLogMaxAge:     auditLogMaxAge,
+		AuditLogMaxBackups: auditLogBackups,
+
+		BandwidthUp:    bandwidthUp,
+		BandwidthDown:  bandwidthDown,
+		QuotaBytes:     quotaBytes,
+		QuotaPeriod:    quotaPeriod,
+		QuotaStateFile: quotaStateFile,
 	}, nil
 }
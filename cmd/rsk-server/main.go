@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/spf13/pflag"
+	"github.com/tbxark/rsk/pkg/rsk/client"
 	"github.com/tbxark/rsk/pkg/rsk/common"
 	"github.com/tbxark/rsk/pkg/rsk/server"
 	"github.com/tbxark/rsk/pkg/rsk/version"
@@ -41,6 +42,17 @@ func main() {
 		"max_auth_failures", cfg.MaxAuthFailures,
 		"auth_block_duration", cfg.AuthBlockDuration,
 		"max_connections_per_client", cfg.MaxConnsPerClient,
+		"ws_listen", cfg.WebSocketListenAddr,
+		"quic_listen", cfg.QUICListenAddr,
+		"tls", cfg.TLSEnabled(),
+		"access_log", cfg.AccessLogFile,
+		"bandwidth_limits", cfg.HasBandwidthLimits(),
+		"health_check_interval", cfg.HealthCheckInterval,
+		"breaker_threshold", cfg.BreakerThreshold,
+		"status_listen", cfg.StatusListenAddr,
+		"port_fallbacks", len(cfg.PortFallbacks),
+		"route_listen", cfg.RouteListenAddr,
+		"accounting", cfg.AccountingFile,
 		"token_validated", true)
 
 	srv := server.NewServer(cfg, logger)
@@ -52,7 +64,9 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	errChan := make(chan error, 1)
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		if err := srv.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
 			errChan <- err
 		}
@@ -62,6 +76,8 @@ func main() {
 	case sig := <-sigChan:
 		logger.Info("Received signal, shutting down", "signal", sig.String())
 		cancel()
+		// Let Start flush the access log and accounting before exiting
+		<-stopped
 	case err := <-errChan:
 		logger.Error("Server error", "error", err)
 		os.Exit(1)
@@ -80,6 +96,40 @@ func parseFlags() (*server.Config, error) {
 		maxAuthFailures   int
 		authBlockDuration time.Duration
 		maxConnsPerClient int
+		wsListenAddr      string
+		wsPath            string
+		quicListenAddr    string
+		tlsCertFile       string
+		tlsKeyFile        string
+		accessLogFile     string
+		accessLogMaxSize  int64
+		accessLogMaxAge   time.Duration
+		accessLogBackups  int
+		portUp            string
+		portDown          string
+		clientUp          string
+		clientDown        string
+		clientBandwidth   string
+		healthInterval    time.Duration
+		healthTimeout     time.Duration
+		healthTarget      string
+		healthDegraded    time.Duration
+		healthFailures    int
+		breakerThreshold  int
+		breakerCoolDown   time.Duration
+		statusListen      string
+		portFallback      string
+		directPrivate     bool
+		directBlocked     string
+		routeListen       string
+		routeRules        string
+		routeFallback     string
+		accountingFile    string
+		accountingEvery   time.Duration
+		accountingKeep    time.Duration
+		exportUsage       string
+		usageFrom         string
+		usageTo           string
 		showVersion       bool
 	)
 
@@ -91,6 +141,40 @@ func parseFlags() (*server.Config, error) {
 	pflag.IntVar(&maxAuthFailures, "max-auth-failures", 5, "Maximum authentication failures before blocking IP")
 	pflag.DurationVar(&authBlockDuration, "auth-block-duration", 5*time.Minute, "Duration to block IP after max auth failures")
 	pflag.IntVar(&maxConnsPerClient, "max-connections-per-client", 100, "Maximum SOCKS5 connections per client")
+	pflag.StringVar(&wsListenAddr, "ws-listen", "", "Address to serve the WebSocket transport on (disabled if empty)")
+	pflag.StringVar(&wsPath, "ws-path", "/rsk", "HTTP path for the WebSocket transport")
+	pflag.StringVar(&quicListenAddr, "quic-listen", "", "UDP address to serve the QUIC transport on (disabled if empty, requires TLS)")
+	pflag.StringVar(&tlsCertFile, "tls-cert", "", "TLS certificate file for the WebSocket and QUIC listeners")
+	pflag.StringVar(&tlsKeyFile, "tls-key", "", "TLS private key file for the WebSocket and QUIC listeners")
+	pflag.StringVar(&accessLogFile, "access-log", "", "File to write the SOCKS access log to, one JSON line per connection (disabled if empty)")
+	pflag.Int64Var(&accessLogMaxSize, "access-log-max-size", 100, "Rotate the access log after this many megabytes (0 disables)")
+	pflag.DurationVar(&accessLogMaxAge, "access-log-max-age", 24*time.Hour, "Rotate the access log after this duration (0 disables)")
+	pflag.IntVar(&accessLogBackups, "access-log-backups", 7, "Number of rotated access log files to keep (0 keeps all)")
+	pflag.StringVar(&portUp, "port-bandwidth-up", "", "Upload limit per port in bytes per second, e.g. 512K or 10M (empty is unlimited)")
+	pflag.StringVar(&portDown, "port-bandwidth-down", "", "Download limit per port in bytes per second (empty is unlimited)")
+	pflag.StringVar(&clientUp, "client-bandwidth-up", "", "Upload limit per client across its ports in bytes per second (empty is unlimited)")
+	pflag.StringVar(&clientDown, "client-bandwidth-down", "", "Download limit per client across its ports in bytes per second (empty is unlimited)")
+	pflag.StringVar(&clientBandwidth, "client-bandwidth", "", "Per-client limits replacing the client defaults, as name=up/down (comma-separated, e.g. bulk=1M/10M)")
+	pflag.DurationVar(&healthInterval, "health-check-interval", 0, "Time between health probes of each client (0 disables)")
+	pflag.DurationVar(&healthTimeout, "health-check-timeout", 20*time.Second, "Timeout of each health probe; keep it above the clients' dial timeout")
+	pflag.StringVar(&healthTarget, "health-check-target", "", "host:port each client is asked to connect to when probed (empty only pings)")
+	pflag.DurationVar(&healthDegraded, "health-degraded-rtt", 500*time.Millisecond, "Ping round trip above which a client is degraded (0 disables)")
+	pflag.IntVar(&healthFailures, "health-failure-threshold", 2, "Failed health probes in a row before a client is unhealthy")
+	pflag.IntVar(&breakerThreshold, "breaker-threshold", 0, "Exit failures in a row through a port before its circuit breaker opens (0 disables)")
+	pflag.DurationVar(&breakerCoolDown, "breaker-cooldown", 30*time.Second, "How long an open circuit breaker fails connections fast before a trial connection")
+	pflag.StringVar(&statusListen, "status-listen", "", "Address to serve the JSON status of bound ports on (disabled if empty)")
+	pflag.StringVar(&portFallback, "port-fallback", "", "Fallbacks for ports whose client is offline, as port=reject|direct|port:N (comma-separated)")
+	pflag.BoolVar(&directPrivate, "direct-allow-private-networks", false, "Let direct fallback connect to private networks")
+	pflag.StringVar(&directBlocked, "direct-blocked-networks", "", "Comma-separated CIDR blocks direct fallback never connects to")
+	pflag.StringVar(&routeListen, "route-listen", "", "Address of a SOCKS5 listener choosing the client by destination (disabled if empty)")
+	pflag.StringVar(&routeRules, "route-rules", "", "File with routing rules, one \"kind value action\" per line")
+	pflag.StringVar(&routeFallback, "route-fallback", server.RouteReject, "Client name or label:key=value selector for targets no routing rule matches, or reject")
+	pflag.StringVar(&accountingFile, "accounting-file", "", "File to persist per-client traffic accounting in (disabled if empty)")
+	pflag.DurationVar(&accountingEvery, "accounting-interval", time.Minute, "How often traffic accounting is saved")
+	pflag.DurationVar(&accountingKeep, "accounting-retention", 90*24*time.Hour, "Drop accounted hours older than this (0 keeps all)")
+	pflag.StringVar(&exportUsage, "export-usage", "", "Print the usage saved in the accounting file as json or csv and exit (a running server serves live usage at /usage on --status-listen)")
+	pflag.StringVar(&usageFrom, "usage-from", "", "Start of the exported window, RFC 3339 or YYYY-MM-DD (open if empty)")
+	pflag.StringVar(&usageTo, "usage-to", "", "End of the exported window, exclusive, RFC 3339 or YYYY-MM-DD (open if empty)")
 	pflag.BoolVarP(&showVersion, "version", "v", false, "Show version information")
 
 	pflag.Parse()
@@ -100,6 +184,13 @@ func parseFlags() (*server.Config, error) {
 		os.Exit(0)
 	}
 
+	if exportUsage != "" {
+		if err := exportUsageReport(accountingFile, exportUsage, usageFrom, usageTo); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+
 	// Auto-generate token if not provided
 	if token == "" {
 		generatedToken, err := common.GenerateToken(common.MinTokenLength)
@@ -118,6 +209,21 @@ func parseFlags() (*server.Config, error) {
 		return nil, err
 	}
 
+	var bandwidth [4]int64
+	for i, s := range []string{portUp, portDown, clientUp, clientDown} {
+		if bandwidth[i], err = common.ParseByteSize(s); err != nil {
+			return nil, err
+		}
+	}
+	overrides, err := server.ParseBandwidthOverrides(clientBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	fallbacks, err := server.ParsePortFallbacks(portFallback)
+	if err != nil {
+		return nil, err
+	}
+
 	return &server.Config{
 		ListenAddr:        listenAddr,
 		Token:             []byte(token),
@@ -128,5 +234,66 @@ func parseFlags() (*server.Config, error) {
 		MaxAuthFailures:   maxAuthFailures,
 		AuthBlockDuration: authBlockDuration,
 		MaxConnsPerClient: maxConnsPerClient,
+
+		WebSocketListenAddr: wsListenAddr,
+		WebSocketPath:       wsPath,
+		QUICListenAddr:      quicListenAddr,
+		TLSCertFile:         tlsCertFile,
+		TLSKeyFile:          tlsKeyFile,
+
+		AccessLogFile:       accessLogFile,
+		AccessLogMaxSize:    accessLogMaxSize * 1024 * 1024,
+		AccessLogMaxAge:     accessLogMaxAge,
+		AccessLogMaxBackups: accessLogBackups,
+
+		PortBandwidth:            server.BandwidthLimit{Up: bandwidth[0], Down: bandwidth[1]},
+		ClientBandwidth:          server.BandwidthLimit{Up: bandwidth[2], Down: bandwidth[3]},
+		ClientBandwidthOverrides: overrides,
+
+		HealthCheckInterval:    healthInterval,
+		HealthCheckTimeout:     healthTimeout,
+		HealthCheckTarget:      healthTarget,
+		HealthDegradedRTT:      healthDegraded,
+		HealthFailureThreshold: healthFailures,
+		StatusListenAddr:       statusListen,
+
+		BreakerThreshold: breakerThreshold,
+		BreakerCoolDown:  breakerCoolDown,
+
+		PortFallbacks:              fallbacks,
+		DirectAllowPrivateNetworks: directPrivate,
+		DirectBlockedNetworks:      client.ParseCommaSeparated(directBlocked),
+
+		RouteListenAddr: routeListen,
+		RouteRulesFile:  routeRules,
+		RouteFallback:   routeFallback,
+
+		AccountingFile:      accountingFile,
+		AccountingInterval:  accountingEvery,
+		AccountingRetention: accountingKeep,
 	}, nil
 }
+
+// exportUsageReport prints the usage saved in accountingFile within the
+// window [from, to) to stdout.
+func exportUsageReport(accountingFile, format, from, to string) error {
+	if accountingFile == "" {
+		return fmt.Errorf("--export-usage requires --accounting-file")
+	}
+	fromTime, err := server.ParseUsageTime(from)
+	if err != nil {
+		return err
+	}
+	toTime, err := server.ParseUsageTime(to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(accountingFile); err != nil {
+		return fmt.Errorf("failed to open accounting file: %w", err)
+	}
+	accounting, err := server.NewAccounting(accountingFile, 0)
+	if err != nil {
+		return err
+	}
+	return server.WriteUsageReport(os.Stdout, accounting.Report(fromTime, toTime), format)
+}
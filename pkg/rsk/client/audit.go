@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Filter decisions recorded in the audit log.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// AuditEntry is one line of the egress audit log, describing a single
+// CONNECT_REQ received from the server.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Target     string    `json:"target"`
+	ResolvedIP string    `json:"resolved_ip,omitempty"`
+	Decision   string    `json:"decision"`
+	Reason     string    `json:"reason,omitempty"` // Why the target was denied or could not be dialed
+	DialMs     int64     `json:"dial_ms"`
+	BytesUp    int64     `json:"bytes_up"`   // Stream to target
+	BytesDown  int64     `json:"bytes_down"` // Target to stream
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// AuditLog writes AuditEntry records as JSON lines.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog creates an audit log writing to w, typically a *common.RotatingFile.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// Record writes entry as a single JSON line.
+func (l *AuditLog) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(line)
+	return err
+}
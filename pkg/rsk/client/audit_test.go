@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// runAuditedStream sends a CONNECT_REQ for addr through handleStream and
+// returns the resulting audit entry. meter may be nil.
+func runAuditedStream(t *testing.T, addr string, filter *AddressFilter, meter *Meter) AuditEntry {
+	t.Helper()
+
+	var buf syncBuffer
+	audit := NewAuditLog(&buf)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dialer, err := NewDialer(DialerConfig{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+
+	local, remote := net.Pipe()
+	defer func() { _ = local.Close() }()
+
+	done := make(chan struct{})
+	go func() {
+		handleStream(remote, 0, filter, &Dialers{def: dialer}, meter, audit, logger)
+		close(done)
+	}()
+
+	if err := proto.WriteConnectReq(local, addr); err != nil {
+		t.Fatalf("WriteConnectReq() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleStream did not return")
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode audit entry %q: %v", buf.Bytes(), err)
+	}
+	return entry
+}
+
+func TestHandleStream_AuditsBlockedTarget(t *testing.T) {
+	filter, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+
+	entry := runAuditedStream(t, "127.0.0.1:22", filter, nil)
+
+	if entry.Decision != DecisionDeny {
+		t.Errorf("Decision = %q, want %q", entry.Decision, DecisionDeny)
+	}
+	if entry.Target != "127.0.0.1:22" {
+		t.Errorf("Target = %q, want %q", entry.Target, "127.0.0.1:22")
+	}
+	if entry.ResolvedIP != "127.0.0.1" {
+		t.Errorf("ResolvedIP = %q, want %q", entry.ResolvedIP, "127.0.0.1")
+	}
+	if entry.Reason == "" {
+		t.Error("Reason is empty for a denied target")
+	}
+	if entry.Time.IsZero() {
+		t.Error("Time is not set")
+	}
+}
+
+func TestHandleStream_AuditsDialFailure(t *testing.T) {
+	filter, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+
+	// TEST-NET-1 passes the filter but is not routable
+	entry := runAuditedStream(t, "192.0.2.1:9", filter, nil)
+
+	if entry.Decision != DecisionAllow {
+		t.Errorf("Decision = %q, want %q", entry.Decision, DecisionAllow)
+	}
+	if entry.Reason == "" {
+		t.Error("Reason is empty for a failed dial")
+	}
+	if entry.BytesUp != 0 || entry.BytesDown != 0 {
+		t.Errorf("bytes = %d/%d, want 0/0", entry.BytesUp, entry.BytesDown)
+	}
+}
@@ -2,15 +2,22 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
 	"github.com/hashicorp/yamux"
 	"github.com/tbxark/rsk/pkg/rsk/common"
 	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+	"golang.org/x/sync/errgroup"
 )
 
 // Client connects to RSK server and handles outbound connections.
@@ -18,9 +25,20 @@ type Client struct {
 	Config         *Config
 	ReconnectDelay time.Duration
 	Logger         *slog.Logger
+
+	legacyHello atomic.Bool // Set once the server turned down a version 2 HELLO
+	stats       streamStats // Streams served since start, reported on the control stream
+
+	control atomic.Pointer[proto.ControlConn] // Newest control stream, nil while there is none
+
+	portsMu sync.Mutex
+	ports   []int // Claimed ports once changed over the control stream (nil: Config.Port)
 }
 
-func handleStream(stream net.Conn, dialTimeout time.Duration, filter *AddressFilter, logger *slog.Logger) {
+// handleStream serves one CONNECT_REQ. With proto.CapConnectStatus in caps
+// the dial result is reported to the server in a CONNECT_RESP, and with
+// proto.CapConnectPort the target is dialed with the claimed port's dialer.
+func handleStream(stream net.Conn, caps proto.Capabilities, filter *AddressFilter, dialers *Dialers, meter *Meter, audit *AuditLog, logger *slog.Logger) {
 	defer func() {
 		_ = stream.Close()
 	}()
@@ -30,131 +48,306 @@ func handleStream(stream net.Conn, dialTimeout time.Duration, filter *AddressFil
 		return
 	}
 
-	addr, err := proto.ReadConnectReq(stream)
+	var port int
+	var addr string
+	var err error
+	if caps.Has(proto.CapConnectPort) {
+		port, addr, err = proto.ReadConnectReqPort(stream)
+	} else {
+		addr, err = proto.ReadConnectReq(stream)
+	}
 	if err != nil {
 		logger.Error("Failed to read CONNECT_REQ", "error", err)
 		return
 	}
 
-	logger.Debug("Received CONNECT_REQ", "addr", addr)
+	logger.Debug("Received CONNECT_REQ", "addr", addr, "port", port)
+	dialer := dialers.For(port)
+
+	respond := func(status uint8) bool {
+		if !caps.Has(proto.CapConnectStatus) {
+			return true
+		}
+		if err := proto.WriteConnectResp(stream, status); err != nil {
+			logger.Debug("Failed to send CONNECT_RESP", "addr", addr, "error", err)
+			return false
+		}
+		return true
+	}
+
+	entry := AuditEntry{Time: time.Now(), Target: addr, Decision: DecisionAllow}
+	if audit != nil {
+		defer func() {
+			entry.DurationMs = time.Since(entry.Time).Milliseconds()
+			if err := audit.Record(entry); err != nil {
+				logger.Error("Failed to write audit log", "error", err)
+			}
+		}()
+	}
+
+	if meter != nil {
+		if err := meter.Check(); err != nil {
+			logger.Warn("Refusing stream", "addr", addr, "error", err)
+			entry.Decision = DecisionDeny
+			entry.Reason = err.Error()
+			respond(proto.ConnectQuota)
+			return
+		}
+	}
 
-	// Validate address with filter
-	if err := filter.IsAllowed(addr); err != nil {
+	// Resolve and vet every address of the target; only vetted IPs are dialed
+	resolveCtx, cancel := context.WithTimeout(context.Background(), dialer.timeout)
+	decision, err := filter.Resolve(resolveCtx, addr)
+	cancel()
+	if decision != nil && len(decision.Allowed) > 0 {
+		entry.ResolvedIP = decision.Allowed[0].String()
+	} else if decision != nil && len(decision.Denied) > 0 {
+		entry.ResolvedIP = decision.Denied[0].String()
+	}
+	if err != nil {
 		logger.Warn("Target address blocked by filter",
 			"addr", addr,
 			"error", err)
+		entry.Decision = DecisionDeny
+		entry.Reason = err.Error()
+		respond(resolveStatus(decision, err))
 		return
 	}
+	if len(decision.Denied) > 0 {
+		logger.Warn("Skipping blocked addresses of target",
+			"addr", addr,
+			"denied", decision.Denied,
+			"reason", decision.Reason)
+	}
 
-	target, err := net.DialTimeout("tcp", addr, dialTimeout)
+	dialStart := time.Now()
+	target, ip, err := dialer.DialDecision(context.Background(), decision)
+	entry.DialMs = time.Since(dialStart).Milliseconds()
 	if err != nil {
 		logger.Warn("Failed to dial target", "addr", addr, "error", err)
+		entry.Reason = err.Error()
+		respond(dialer.dialStatus(err))
 		return
 	}
 	defer func() {
 		_ = target.Close()
 	}()
 
+	entry.ResolvedIP = ip.String()
+
 	if err := common.ClearDeadline(stream); err != nil {
 		logger.Error("Failed to clear stream deadline", "error", err)
 		return
 	}
 
-	logger.Debug("Connected to target", "addr", addr)
+	if !respond(proto.ConnectOK) {
+		return
+	}
 
+	logger.Debug("Connected to target", "addr", addr, "ip", ip)
+
+	var bytesUp, bytesDown atomic.Int64
 	done := make(chan error, 2)
 
+	var up, down io.Reader = stream, target
+	copyCtx, cancelCopy := context.WithCancel(context.Background())
+	defer cancelCopy()
+	if meter != nil {
+		up = meter.Upload(copyCtx, stream)
+		down = meter.Download(copyCtx, target)
+	}
+
 	go func() {
-		_, err := io.Copy(target, stream)
+		n, err := io.Copy(target, up)
+		bytesUp.Add(n)
 		done <- err
 	}()
 
 	go func() {
-		_, err := io.Copy(stream, target)
+		n, err := io.Copy(stream, down)
+		bytesDown.Add(n)
 		done <- err
 	}()
 
 	err = <-done
+	cancelCopy()
 
-	if err != nil && err != io.EOF {
+	if errors.Is(err, ErrQuotaExhausted) {
+		logger.Warn("Closing stream, data quota exhausted", "addr", addr)
+		entry.Reason = err.Error()
+	} else if err != nil && err != io.EOF {
 		logger.Debug("Connection closed with error", "addr", addr, "error", err)
 	} else {
 		logger.Debug("Connection closed", "addr", addr)
 	}
+
+	if audit != nil {
+		// Stop the other direction so its byte count is final
+		_ = target.Close()
+		_ = stream.Close()
+		<-done
+		entry.BytesUp = bytesUp.Load()
+		entry.BytesDown = bytesDown.Load()
+	}
 }
 
-func (c *Client) connect() (*yamux.Session, error) {
-	conn, err := net.Dial("tcp", c.Config.ServerAddr)
+// connect establishes a session with the server. A server predating version 2
+// rejects the first HELLO, so connect tries again in version 1 right away
+// instead of waiting for the next reconnect.
+func (c *Client) connect(ctx context.Context, stripe *proto.StripeInfo) (transport.Session, proto.HelloResp, error) {
+	legacy := c.legacyHello.Load()
+	session, resp, err := c.connectOnce(ctx, stripe)
+	if err != nil && !legacy && c.legacyHello.Load() {
+		return c.connectOnce(ctx, stripe)
+	}
+	return session, resp, err
+}
+
+func (c *Client) connectOnce(ctx context.Context, stripe *proto.StripeInfo) (transport.Session, proto.HelloResp, error) {
+	if transport.IsQUICURL(c.Config.ServerAddr) {
+		return c.connectQUIC(ctx, stripe)
+	}
+
+	conn, err := c.dialServer(ctx)
 	if err != nil {
-		return nil, err
+		return nil, proto.HelloResp{}, err
 	}
 
-	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+	resp, err := c.handshake(conn, stripe)
+	if err != nil {
+		_ = conn.Close()
+		return nil, resp, err
+	}
+
+	cfg := yamux.DefaultConfig()
+	cfg.EnableKeepAlive = true
+	cfg.KeepAliveInterval = 30 * time.Second
+	cfg.ConnectionWriteTimeout = 10 * time.Second
+
+	session, err := yamux.Client(conn, cfg)
+	if err != nil {
 		_ = conn.Close()
-		return nil, err
+		return nil, resp, err
+	}
+
+	c.Logger.Info("Successfully connected to server",
+		"server", c.Config.ServerAddr,
+		"port", c.Config.Port,
+		"accepted_ports", resp.AcceptedPorts,
+		"protocol_version", resp.Version,
+		"capabilities", resp.Capabilities)
+
+	return session, resp, nil
+}
+
+// connectQUIC establishes a QUIC session. The HELLO handshake is carried on the
+// first client-opened stream; CONNECT_REQs then arrive on server-opened streams.
+func (c *Client) connectQUIC(ctx context.Context, stripe *proto.StripeInfo) (transport.Session, proto.HelloResp, error) {
+	session, err := c.dialQUIC(ctx)
+	if err != nil {
+		return nil, proto.HelloResp{}, err
+	}
+
+	stream, err := session.Open()
+	if err != nil {
+		_ = session.Close()
+		return nil, proto.HelloResp{}, err
 	}
 
-	ports := []uint16{uint16(c.Config.Port)}
+	resp, err := c.handshake(stream, stripe)
+	if err != nil {
+		_ = session.Close()
+		return nil, resp, err
+	}
+	_ = stream.Close()
+
+	c.Logger.Info("Successfully connected to server",
+		"server", c.Config.ServerAddr,
+		"transport", transport.SchemeQUIC,
+		"port", c.Config.Port,
+		"accepted_ports", resp.AcceptedPorts,
+		"protocol_version", resp.Version,
+		"capabilities", resp.Capabilities)
+
+	return session, resp, nil
+}
+
+// handshake performs the HELLO / HELLO_RESP exchange on conn. A non-nil
+// stripe announces the connection as a member of a striped session.
+//
+// A version 2 HELLO is sent unless the server turned one down before with a
+// version 1 bad request response, in which case version 1 without labels and
+// metadata is used from then on.
+func (c *Client) handshake(conn net.Conn, stripe *proto.StripeInfo) (proto.HelloResp, error) {
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return proto.HelloResp{}, err
+	}
+
+	var ports []uint16
+	for _, port := range c.Ports() {
+		ports = append(ports, uint16(port))
+	}
 
 	hello := proto.Hello{
-		Magic:   [4]byte{'R', 'S', 'K', '1'},
-		Version: proto.Version,
-		Token:   c.Config.Token,
-		Ports:   ports,
-		Name:    c.Config.Name,
+		Magic:        proto.HelloMagic(false),
+		Version:      proto.Version2,
+		Token:        c.Config.Token,
+		Ports:        ports,
+		Name:         c.Config.Name,
+		Stripe:       stripe,
+		Info:         c.Config.clientInfo(),
+		Capabilities: proto.SupportedCapabilities,
+		BestEffort:   c.Config.BestEffortPorts,
+	}
+	if c.legacyHello.Load() {
+		// The plain version 1 layout is understood by every server
+		hello.Magic = proto.HelloMagic(stripe != nil)
+		hello.Version = proto.Version
+		hello.Info = nil
+		hello.Capabilities = 0
+		hello.BestEffort = false
 	}
 
 	if err := proto.WriteHello(conn, hello); err != nil {
-		_ = conn.Close()
-		return nil, err
+		return proto.HelloResp{}, err
 	}
 
 	if err := conn.SetDeadline(time.Time{}); err != nil {
-		_ = conn.Close()
-		return nil, err
+		return proto.HelloResp{}, err
 	}
 
 	if err := common.SetReadDeadline(conn, 5*time.Second); err != nil {
-		_ = conn.Close()
-		return nil, err
+		return proto.HelloResp{}, err
 	}
 
 	resp, err := proto.ReadHelloResp(conn)
 	if err != nil {
-		_ = conn.Close()
-		return nil, err
+		return resp, err
 	}
 
 	if err := common.ClearDeadline(conn); err != nil {
-		_ = conn.Close()
-		return nil, err
+		return resp, err
 	}
 
 	if resp.Status != proto.StatusOK {
-		_ = conn.Close()
-		return nil, &HandshakeError{
+		// Servers knowing version 2 answer a version 2 HELLO in kind, so a
+		// version 1 refusal of it comes from a server predating version 2
+		if hello.Version == proto.Version2 && resp.Version == proto.Version && resp.Status == proto.StatusBadRequest {
+			c.Logger.Warn("Server does not support version 2 HELLO, falling back to version 1 without labels and metadata",
+				"labels", c.Config.Labels)
+			c.legacyHello.Store(true)
+		}
+		return resp, &HandshakeError{
 			Status:  resp.Status,
 			Message: resp.Message,
 		}
 	}
 
-	cfg := yamux.DefaultConfig()
-	cfg.EnableKeepAlive = true
-	cfg.KeepAliveInterval = 30 * time.Second
-	cfg.ConnectionWriteTimeout = 10 * time.Second
-
-	session, err := yamux.Client(conn, cfg)
-	if err != nil {
-		_ = conn.Close()
-		return nil, err
+	if resp.Version == proto.Version2 && stripe != nil && !resp.Capabilities.Has(proto.CapStriping) {
+		return resp, fmt.Errorf("server does not support striped sessions")
 	}
 
-	c.Logger.Info("Successfully connected to server",
-		"server", c.Config.ServerAddr,
-		"port", c.Config.Port,
-		"accepted_ports", resp.AcceptedPorts)
-
-	return session, nil
+	return resp, nil
 }
 
 // HandshakeError represents a HELLO handshake error.
@@ -164,24 +357,27 @@ type HandshakeError struct {
 }
 
 func (e *HandshakeError) Error() string {
-	statusName := "UNKNOWN"
-	switch e.Status {
+	if e.Message != "" {
+		return statusName(e.Status) + ": " + e.Message
+	}
+	return statusName(e.Status)
+}
+
+// statusName returns the name of a HELLO_RESP status code.
+func statusName(status uint8) string {
+	switch status {
 	case proto.StatusAuthFail:
-		statusName = "AUTH_FAIL"
+		return "AUTH_FAIL"
 	case proto.StatusBadRequest:
-		statusName = "BAD_REQUEST"
+		return "BAD_REQUEST"
 	case proto.StatusPortForbidden:
-		statusName = "PORT_FORBIDDEN"
+		return "PORT_FORBIDDEN"
 	case proto.StatusPortInUse:
-		statusName = "PORT_IN_USE"
+		return "PORT_IN_USE"
 	case proto.StatusServerInternal:
-		statusName = "SERVER_INTERNAL"
-	}
-
-	if e.Message != "" {
-		return statusName + ": " + e.Message
+		return "SERVER_INTERNAL"
 	}
-	return statusName
+	return "UNKNOWN"
 }
 
 func (e *HandshakeError) IsAuthFail() bool {
@@ -192,14 +388,19 @@ func (e *HandshakeError) IsPortInUse() bool {
 	return e.Status == proto.StatusPortInUse
 }
 
-func (c *Client) handleStreams(session *yamux.Session, filter *AddressFilter) error {
+func (c *Client) handleStreams(session transport.Session, caps proto.Capabilities, filter *AddressFilter, dialers *Dialers, meter *Meter, audit *AuditLog) error {
 	for {
-		stream, err := session.AcceptStream()
+		stream, err := session.Accept()
 		if err != nil {
 			return err
 		}
 
-		go handleStream(stream, c.Config.DialTimeout, filter, c.Logger)
+		c.stats.active.Add(1)
+		c.stats.total.Add(1)
+		go func() {
+			defer c.stats.active.Add(-1)
+			handleStream(&countingConn{Conn: stream, stats: &c.stats}, caps, filter, dialers, meter, audit, c.Logger)
+		}()
 	}
 }
 
@@ -216,6 +417,162 @@ func (c *Client) Run(ctx context.Context) error {
 		"allow_private", c.Config.AllowPrivateNetworks,
 		"blocked_networks_count", len(c.Config.BlockedNetworks))
 
+	resolver, err := NewResolver(ResolverConfig{
+		Servers:     c.Config.DNSServers,
+		Prefer:      c.Config.DNSPrefer,
+		MaxTTL:      c.Config.DNSMaxTTL,
+		NegativeTTL: c.Config.DNSNegativeTTL,
+	})
+	if err != nil {
+		c.Logger.Error("Failed to create resolver", "error", err)
+		return err
+	}
+	filter.SetResolver(resolver)
+
+	c.Logger.Info("Resolver initialized",
+		"servers", c.Config.DNSServers,
+		"prefer", c.Config.DNSPrefer)
+
+	dialers, err := NewDialers(c.Config.dialerConfigs())
+	if err != nil {
+		c.Logger.Error("Failed to create dialer", "error", err)
+		return err
+	}
+
+	c.Logger.Info("Dialer initialized",
+		"ip_mode", dialers.def.mode,
+		"source_addrs", append(dialers.def.sources4, dialers.def.sources6...),
+		"egress_proxy", dialers.def.proxy)
+	for port, dl := range dialers.ports {
+		c.Logger.Info("Port dialer initialized",
+			"port", port,
+			"source_addrs", append(dl.sources4, dl.sources6...),
+			"egress_proxy", dl.proxy)
+	}
+
+	if c.Config.HasPortPolicy() {
+		policy, err := NewPortPolicy(c.Config.AllowPorts, c.Config.DenyPorts, c.Config.BlockAbusePorts)
+		if err != nil {
+			c.Logger.Error("Failed to create port policy", "error", err)
+			return err
+		}
+		filter.SetPortPolicy(policy)
+
+		c.Logger.Info("Port policy initialized",
+			"allow_ports", c.Config.AllowPorts,
+			"deny_ports", c.Config.DenyPorts,
+			"block_abuse_ports", c.Config.BlockAbusePorts)
+	}
+
+	var rules *DomainRules
+	if c.Config.HasDomainRules() {
+		rules, err = NewDomainRules(c.Config.DomainDefault,
+			c.Config.AllowDomains, c.Config.DenyDomains,
+			c.Config.AllowDomainFiles, c.Config.DenyDomainFiles)
+		if err != nil {
+			c.Logger.Error("Failed to load domain rules", "error", err)
+			return err
+		}
+		filter.SetDomainRules(rules)
+
+		interval := c.Config.DomainReloadInterval
+		if interval <= 0 {
+			interval = defaultDomainReloadInterval
+		}
+		go rules.Watch(ctx, interval, c.Logger)
+
+		c.Logger.Info("Domain rules initialized",
+			"default", c.Config.DomainDefault,
+			"allow_count", len(c.Config.AllowDomains),
+			"deny_count", len(c.Config.DenyDomains),
+			"list_files", len(c.Config.AllowDomainFiles)+len(c.Config.DenyDomainFiles))
+	}
+
+	var meter *Meter
+	var quota *Quota
+	if c.Config.HasMeter() {
+		if c.Config.QuotaBytes > 0 {
+			quota, err = NewQuota(c.Config.QuotaBytes, c.Config.QuotaPeriod, c.Config.QuotaStateFile)
+			if err != nil {
+				c.Logger.Error("Failed to load data quota", "error", err)
+				return err
+			}
+			// Usage is saved once more on shutdown, before Run returns
+			quotaCtx, stopQuota := context.WithCancel(context.Background())
+			quotaDone := make(chan struct{})
+			go func() {
+				quota.Run(quotaCtx, quotaSaveInterval, c.Logger)
+				close(quotaDone)
+			}()
+			defer func() {
+				stopQuota()
+				<-quotaDone
+			}()
+
+			used, limit := quota.Usage()
+			c.Logger.Info("Data quota initialized",
+				"period", c.Config.QuotaPeriod,
+				"used_bytes", used,
+				"limit_bytes", limit)
+		}
+		meter = NewMeter(c.Config.BandwidthUp, c.Config.BandwidthDown, quota)
+
+		c.Logger.Info("Bandwidth limits initialized",
+			"up", c.Config.BandwidthUp,
+			"down", c.Config.BandwidthDown)
+	}
+
+	var audit *AuditLog
+	if c.Config.AuditLogFile != "" {
+		auditFile, err := common.OpenRotatingFile(c.Config.AuditLogFile,
+			c.Config.AuditLogMaxSize, c.Config.AuditLogMaxAge, c.Config.AuditLogMaxBackups)
+		if err != nil {
+			c.Logger.Error("Failed to open audit log", "error", err)
+			return err
+		}
+		defer func() {
+			_ = auditFile.Close()
+		}()
+		audit = NewAuditLog(auditFile)
+		c.Logger.Info("Audit log enabled", "path", c.Config.AuditLogFile)
+	}
+
+	control := c.controlHandler(rules, quota)
+
+	if c.Config.Connections > 1 {
+		return c.runStriped(ctx, filter, dialers, meter, audit, control)
+	}
+
+	return c.runConnection(ctx, filter, dialers, meter, audit, control, nil, c.Logger)
+}
+
+// runStriped opens Config.Connections parallel control connections that the
+// server treats as one session. Each member reconnects independently; the
+// session ends when any member hits a permanent error or ctx is canceled.
+func (c *Client) runStriped(ctx context.Context, filter *AddressFilter, dialers *Dialers, meter *Meter, audit *AuditLog, control proto.ControlHandler) error {
+	stripe := &proto.StripeInfo{
+		SessionID: [16]byte(uuid.New()),
+		Members:   uint8(c.Config.Connections),
+	}
+
+	c.Logger.Info("Starting striped session",
+		"session_id", uuid.UUID(stripe.SessionID).String(),
+		"connections", c.Config.Connections)
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < c.Config.Connections; i++ {
+		logger := c.Logger.With("member", i)
+		g.Go(func() error {
+			return c.runConnection(ctx, filter, dialers, meter, audit, control, stripe, logger)
+		})
+	}
+
+	return g.Wait()
+}
+
+// runConnection keeps one control connection to the server alive, reconnecting
+// with exponential backoff until a permanent error occurs or ctx is canceled.
+func (c *Client) runConnection(ctx context.Context, filter *AddressFilter, dialers *Dialers, meter *Meter, audit *AuditLog, control proto.ControlHandler, stripe *proto.StripeInfo, logger *slog.Logger) error {
 	// Configure exponential backoff
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = c.ReconnectDelay
@@ -232,31 +589,31 @@ func (c *Client) Run(ctx context.Context) error {
 
 		select {
 		case <-ctx.Done():
-			c.Logger.Info("Client shutting down")
+			logger.Info("Client shutting down")
 			return backoff.Permanent(ctx.Err())
 		default:
 		}
 
-		c.Logger.Info("Connecting to server",
+		logger.Info("Connecting to server",
 			"server", c.Config.ServerAddr,
 			"attempt", attempt)
 
-		session, err := c.connect()
+		session, resp, err := c.connect(ctx, stripe)
 		if err != nil {
 			if hsErr, ok := err.(*HandshakeError); ok {
 				if hsErr.IsAuthFail() {
-					c.Logger.Error("Authentication failed, exiting", "error", err)
+					logger.Error("Authentication failed, exiting", "error", err)
 					return backoff.Permanent(err)
 				}
 
 				if hsErr.IsPortInUse() {
-					c.Logger.Error("Ports already in use, exiting", "error", err)
+					logger.Error("Ports already in use, exiting", "error", err)
 					return backoff.Permanent(err)
 				}
 
-				c.Logger.Warn("Handshake failed, will retry", "error", err)
+				logger.Warn("Handshake failed, will retry", "error", err)
 			} else {
-				c.Logger.Warn("Connection failed, will retry", "error", err)
+				logger.Warn("Connection failed, will retry", "error", err)
 			}
 			return err
 		}
@@ -265,7 +622,28 @@ func (c *Client) Run(ctx context.Context) error {
 		attempt = 0
 		b.Reset()
 
-		c.Logger.Info("Session established, handling streams")
+		if resp.Capabilities.Has(proto.CapControl) {
+			if err := c.openControl(session, control, logger); err != nil {
+				logger.Warn("Failed to open control stream", "error", err)
+			}
+		}
+
+		if len(resp.Rejected) > 0 {
+			rejected := make([]string, len(resp.Rejected))
+			for i, r := range resp.Rejected {
+				rejected[i] = fmt.Sprintf("%d (%s)", r.Port, statusName(r.Status))
+			}
+			logger.Warn("Server did not accept all ports",
+				"accepted", resp.AcceptedPorts,
+				"rejected", rejected)
+			if resp.Capabilities.Has(proto.CapControl) {
+				go c.retryRejectedPorts(session, resp.Rejected, logger)
+			} else {
+				logger.Warn("Server cannot add ports to a session, missing ports are claimed on reconnect")
+			}
+		}
+
+		logger.Info("Session established, handling streams")
 		stopCh := make(chan struct{})
 		go func() {
 			select {
@@ -275,10 +653,10 @@ func (c *Client) Run(ctx context.Context) error {
 			}
 		}()
 
-		err = c.handleStreams(session, filter)
+		err = c.handleStreams(session, resp.Capabilities, filter, dialers, meter, audit)
 		close(stopCh)
 
-		c.Logger.Warn("Session closed, will reconnect", "error", err)
+		logger.Warn("Session closed, will reconnect", "error", err)
 		_ = session.Close()
 
 		// Return error to trigger backoff
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+func newHandshakeTestClient() *Client {
+	return &Client{
+		Config: &Config{
+			Token:  []byte("test-token-16-bytes-minimum"),
+			Port:   20001,
+			Name:   "exit",
+			Labels: map[string]string{"region": "eu"},
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// serveHandshake answers one HELLO on conn with resp and returns the HELLO.
+// conn is left open for the client to close.
+func serveHandshake(t *testing.T, conn net.Conn, resp proto.HelloResp) <-chan proto.Hello {
+	t.Helper()
+	hellos := make(chan proto.Hello, 1)
+	go func() {
+		hello, err := proto.ReadHello(conn)
+		if err != nil {
+			t.Errorf("ReadHello() error = %v", err)
+			return
+		}
+		hellos <- hello
+		_ = proto.WriteHelloResp(conn, resp)
+	}()
+	return hellos
+}
+
+func TestHandshake_Version2(t *testing.T) {
+	c := newHandshakeTestClient()
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+
+	hellos := serveHandshake(t, serverConn, proto.HelloResp{
+		Version:       proto.Version2,
+		Status:        proto.StatusOK,
+		AcceptedPorts: []uint16{20001},
+		Capabilities:  proto.CapClientInfo,
+	})
+
+	resp, err := c.handshake(clientConn, nil)
+	if err != nil {
+		t.Fatalf("handshake() error = %v", err)
+	}
+	if resp.Capabilities != proto.CapClientInfo {
+		t.Errorf("Capabilities = %v, want %v", resp.Capabilities, proto.CapClientInfo)
+	}
+
+	hello := <-hellos
+	if hello.Version != proto.Version2 || hello.Capabilities != proto.SupportedCapabilities {
+		t.Errorf("HELLO version %d with capabilities %v, want version 2 with %v",
+			hello.Version, hello.Capabilities, proto.SupportedCapabilities)
+	}
+	if hello.Info == nil || hello.Info.Labels["region"] != "eu" {
+		t.Errorf("HELLO info = %+v, want labels", hello.Info)
+	}
+}
+
+func TestHandshake_StripingNotNegotiated(t *testing.T) {
+	c := newHandshakeTestClient()
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+
+	serveHandshake(t, serverConn, proto.HelloResp{
+		Version:      proto.Version2,
+		Status:       proto.StatusOK,
+		Capabilities: proto.CapClientInfo,
+	})
+
+	if _, err := c.handshake(clientConn, &proto.StripeInfo{Members: 2}); err == nil {
+		t.Error("handshake() error = nil, want error for striping without the capability")
+	}
+}
+
+func TestHandshake_LegacyServerFallback(t *testing.T) {
+	c := newHandshakeTestClient()
+
+	// A server predating version 2 rejects the version byte
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		_ = serverConn.SetDeadline(time.Now().Add(time.Second))
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(serverConn, header); err != nil {
+			return
+		}
+		// Take the rest as a socket buffer would, until the client hangs up
+		go func() { _, _ = io.Copy(io.Discard, serverConn) }()
+		_ = proto.WriteHelloResp(serverConn, proto.HelloResp{
+			Version: proto.Version,
+			Status:  proto.StatusBadRequest,
+			Message: "Invalid HELLO message",
+		})
+	}()
+
+	_, err := c.handshake(clientConn, nil)
+	if _, ok := err.(*HandshakeError); !ok {
+		t.Fatalf("handshake() error = %v, want *HandshakeError", err)
+	}
+	_ = clientConn.Close()
+	if !c.legacyHello.Load() {
+		t.Fatal("Expected the client to fall back to version 1")
+	}
+
+	// The next attempt uses plain version 1
+	clientConn, serverConn = net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+	hellos := serveHandshake(t, serverConn, proto.HelloResp{Version: proto.Version, Status: proto.StatusOK})
+
+	if _, err := c.handshake(clientConn, nil); err != nil {
+		t.Fatalf("handshake() error = %v", err)
+	}
+	hello := <-hellos
+	if hello.Version != proto.Version || string(hello.Magic[:]) != proto.MagicValue || hello.Info != nil {
+		t.Errorf("HELLO version %d magic %q, want plain version 1", hello.Version, hello.Magic)
+	}
+}
+
+func TestHandshake_Version2BadRequest(t *testing.T) {
+	// A server knowing version 2 answers a bad version 2 HELLO in version 2
+	c := newHandshakeTestClient()
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+	serveHandshake(t, serverConn, proto.HelloResp{
+		Version: proto.Version2,
+		Status:  proto.StatusBadRequest,
+		Message: "Invalid HELLO message",
+	})
+
+	if _, err := c.handshake(clientConn, nil); err == nil {
+		t.Fatal("handshake() succeeded, want error")
+	}
+	if c.legacyHello.Load() {
+		t.Error("Expected the client to keep version 2")
+	}
+}
+
+func TestConnect_LegacyServerRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	// The first connection gets the legacy refusal, the second is accepted
+	versions := make(chan uint8, 2)
+	go func() {
+		for _, status := range []uint8{proto.StatusBadRequest, proto.StatusOK} {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			header := make([]byte, 5)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			versions <- header[4]
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+			_ = proto.WriteHelloResp(conn, proto.HelloResp{Version: proto.Version, Status: status, AcceptedPorts: []uint16{20001}})
+		}
+	}()
+
+	c := newHandshakeTestClient()
+	c.Config.ServerAddr = listener.Addr().String()
+	c.Config.Proxy = ProxyDirect
+	session, _, err := c.connect(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	_ = session.Close()
+
+	if first, second := <-versions, <-versions; first != proto.Version2 || second != proto.Version {
+		t.Errorf("HELLO versions %d, %d, want %d, %d", first, second, proto.Version2, proto.Version)
+	}
+}
+
+func TestHandshake_BestEffort(t *testing.T) {
+	c := newHandshakeTestClient()
+	c.Config.ExtraPorts = []int{20002}
+	c.Config.BestEffortPorts = true
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+
+	rejected := []proto.PortRejection{{Port: 20002, Status: proto.StatusPortInUse}}
+	hellos := serveHandshake(t, serverConn, proto.HelloResp{
+		Version:       proto.Version2,
+		Status:        proto.StatusOK,
+		AcceptedPorts: []uint16{20001},
+		Rejected:      rejected,
+	})
+
+	resp, err := c.handshake(clientConn, nil)
+	if err != nil {
+		t.Fatalf("handshake() error = %v", err)
+	}
+	if len(resp.Rejected) != 1 || resp.Rejected[0] != rejected[0] {
+		t.Errorf("Rejected = %v, want %v", resp.Rejected, rejected)
+	}
+
+	hello := <-hellos
+	if !hello.BestEffort || len(hello.Ports) != 2 || hello.Ports[1] != 20002 {
+		t.Errorf("HELLO best effort %v with ports %v, want true with [20001 20002]", hello.BestEffort, hello.Ports)
+	}
+}
+
+func TestHandleStream_ConnectStatus(t *testing.T) {
+	filter, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+	policy, err := NewPortPolicy(nil, []string{"25"}, false)
+	if err != nil {
+		t.Fatalf("NewPortPolicy() error = %v", err)
+	}
+	filter.SetPortPolicy(policy)
+	dialer, err := NewDialer(DialerConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	dialer.dial = func(_ context.Context, _ *net.TCPAddr, address string) (net.Conn, error) {
+		switch address {
+		case "203.0.113.1:80":
+			conn, _ := net.Pipe()
+			return conn, nil
+		case "203.0.113.1:81":
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		default:
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ENETUNREACH}
+		}
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	quota, err := NewQuota(10, QuotaDaily, "")
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	quota.Add(10)
+
+	tests := []struct {
+		name  string
+		addr  string
+		meter *Meter
+		want  uint8
+	}{
+		{"connected", "203.0.113.1:80", nil, proto.ConnectOK},
+		{"denied", "203.0.113.1:25", nil, proto.ConnectDenied},
+		{"blocked address", "127.0.0.1:80", nil, proto.ConnectDenied},
+		{"refused", "203.0.113.1:81", nil, proto.ConnectRefused},
+		{"unreachable", "203.0.113.1:82", nil, proto.ConnectUnreachable},
+		{"quota exhausted", "203.0.113.1:80", NewMeter(0, 0, quota), proto.ConnectQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer func() { _ = local.Close() }()
+			go handleStream(remote, proto.CapConnectStatus, filter, &Dialers{def: dialer}, tt.meter, nil, logger)
+
+			if err := proto.WriteConnectReq(local, tt.addr); err != nil {
+				t.Fatalf("WriteConnectReq() error = %v", err)
+			}
+			_ = local.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var status uint8
+			var connErr *proto.ConnectError
+			if err := proto.ReadConnectResp(local); errors.As(err, &connErr) {
+				status = connErr.Status
+			} else if err != nil {
+				t.Fatalf("ReadConnectResp() error = %v", err)
+			}
+			if status != tt.want {
+				t.Errorf("status = %#02x, want %#02x", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStream_PortDialer(t *testing.T) {
+	filter, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Each dialer reports which one was used through the error it returns
+	newDialer := func(name string) *Dialer {
+		dialer, err := NewDialer(DialerConfig{Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("NewDialer() error = %v", err)
+		}
+		dialer.dial = func(context.Context, *net.TCPAddr, string) (net.Conn, error) {
+			return nil, errors.New(name)
+		}
+		return dialer
+	}
+	dialers := &Dialers{def: newDialer("default"), ports: map[int]*Dialer{20002: newDialer("port 20002")}}
+
+	tests := []struct {
+		port int
+		want string
+	}{
+		{20002, "port 20002"},
+		{20001, "default"},
+		{0, "default"},
+	}
+	for _, tt := range tests {
+		var buf syncBuffer
+		audit := NewAuditLog(&buf)
+		local, remote := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			handleStream(remote, proto.CapConnectPort, filter, dialers, nil, audit, logger)
+			close(done)
+		}()
+		if err := proto.WriteConnectReqPort(local, tt.port, "203.0.113.1:443"); err != nil {
+			t.Fatalf("WriteConnectReqPort() error = %v", err)
+		}
+		<-done
+		_ = local.Close()
+
+		var entry AuditEntry
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to decode audit entry %q: %v", buf.Bytes(), err)
+		}
+		if entry.Reason != tt.want {
+			t.Errorf("port %d dialed with %q, want %q", tt.port, entry.Reason, tt.want)
+		}
+	}
+}
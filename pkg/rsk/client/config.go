@@ -3,11 +3,15 @@ package client
 import (
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/tbxark/rsk/pkg/rsk/common"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
 )
 
 // Config holds client configuration.
@@ -15,12 +19,81 @@ type Config struct {
 	ServerAddr           string        `validate:"required"`
 	Token                []byte        `validate:"required,min=16"`
 	Port                 int           `validate:"required,min=1,max=65535"`
+	ExtraPorts           []int         `validate:"max=15,dive,min=1,max=65535"` // Further ports to claim in the same session
+	BestEffortPorts      bool          // Accept a session with only some of the ports, retrying the others in the background
 	Name                 string        `validate:"required"`
 	DialTimeout          time.Duration `validate:"required,min=1ms"`
 	AllowPrivateNetworks bool
 	BlockedNetworks      []string
+	InsecureSkipVerify   bool   // Skip TLS certificate verification for wss:// and quic:// servers
+	Proxy                string // Proxy for the control connection: URL, ProxyDirect, or empty for environment
+	Connections          int    `validate:"omitempty,min=1,max=16"` // Parallel control connections forming one striped session (default 1)
+
+	// Labels and metadata announced to the server, for its logs, routing and status.
+	Labels   map[string]string // Operator-defined labels such as region=eu-west
+	PublicIP string            `validate:"omitempty,ip"` // Egress public IP to report (defaults to a public source address)
+
+	// Target resolution; with no servers the system resolver is used, still cached.
+	DNSServers     []string      // Upstream DNS servers: IP[:port], udp://, tcp:// or https:// (DoH) URLs
+	DNSPrefer      string        `validate:"omitempty,oneof=ipv4 ipv6"` // Address family listed first in answers; IPMode decides the dialing order
+	DNSMaxTTL      time.Duration `validate:"min=0"`                     // Upper bound for cached answers (default 1h)
+	DNSNegativeTTL time.Duration `validate:"min=0"`                     // Cache lifetime for nonexistent names (default 30s)
+
+	// Target dialing; addresses of both families are raced unless restricted.
+	IPMode          string           `validate:"omitempty,oneof=ipv4-only ipv6-only prefer-ipv4 happy-eyeballs"` // ipv4-only, ipv6-only, prefer-ipv4 or happy-eyeballs (default)
+	PortIPMode      map[int]string   // IP mode for streams arriving on a claimed port, replacing IPMode
+	SourceAddrs     []string         // Local IPs or interface names to dial from, rotated per connection (checked at startup)
+	PortSourceAddrs map[int][]string // Source addresses for streams arriving on a claimed port, replacing SourceAddrs
+	EgressProxy     string           // Upstream proxy URL to reach targets through (empty dials directly)
+	PortEgressProxy map[int]string   // Egress proxy for streams arriving on a claimed port, replacing EgressProxy (ProxyDirect dials directly)
+
+	// Optional destination port policy.
+	AllowPorts      []string // Allowed ports or ranges such as "443" or "8000-8999" (empty allows all)
+	DenyPorts       []string // Denied ports or ranges, winning over allowed ones
+	BlockAbusePorts bool     // Also deny the AbusePorts preset
+
+	// Optional domain rules, checked before the target is resolved.
+	DomainDefault        string        `validate:"omitempty,oneof=allow deny"` // allow (default) or deny targets no rule matches
+	AllowDomains         []string      // Allowed domain patterns
+	DenyDomains          []string      // Denied domain patterns, winning over allow patterns
+	AllowDomainFiles     []string      // List files with allowed domain patterns
+	DenyDomainFiles      []string      // List files with denied domain patterns
+	DomainReloadInterval time.Duration `validate:"min=0"` // How often list files are checked for changes (default 30s)
+
+	// Optional rate limits in bytes per second (0 is unlimited) and data
+	// quota, enforced across all streams.
+	BandwidthUp    int64  `validate:"min=0"` // Consumer to target
+	BandwidthDown  int64  `validate:"min=0"` // Target to consumer
+	QuotaBytes     int64  `validate:"min=0"` // Bytes relayed in both directions per period (0 disables)
+	QuotaPeriod    string `validate:"required_with=QuotaBytes,omitempty,oneof=daily monthly"`
+	QuotaStateFile string // File persisting quota usage across restarts (empty keeps it in memory)
+
+	// Optional egress audit log, one JSON line per CONNECT_REQ.
+	AuditLogFile       string        // Path of the audit log (empty disables it)
+	AuditLogMaxSize    int64         `validate:"min=0"` // Rotate after this many bytes (0 disables size rotation)
+	AuditLogMaxAge     time.Duration `validate:"min=0"` // Rotate files older than this (0 disables time rotation)
+	AuditLogMaxBackups int           `validate:"min=0"` // Rotated files to keep (0 keeps all)
 }
 
+// HasPortPolicy reports whether any destination port restriction is configured.
+func (c *Config) HasPortPolicy() bool {
+	return c.BlockAbusePorts || len(c.AllowPorts) > 0 || len(c.DenyPorts) > 0
+}
+
+// HasMeter reports whether any rate limit or data quota is configured.
+func (c *Config) HasMeter() bool {
+	return c.BandwidthUp > 0 || c.BandwidthDown > 0 || c.QuotaBytes > 0
+}
+
+// HasDomainRules reports whether any domain rule or mode is configured.
+func (c *Config) HasDomainRules() bool {
+	return c.DomainDefault == DomainDefaultDeny || len(c.AllowDomains) > 0 || len(c.DenyDomains) > 0 ||
+		len(c.AllowDomainFiles) > 0 || len(c.DenyDomainFiles) > 0
+}
+
+// ProxyDirect disables proxying of the control connection, ignoring the environment.
+const ProxyDirect = "direct"
+
 var validate = validator.New()
 
 // Validate validates the configuration.
@@ -37,9 +110,139 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	for i, port := range c.ExtraPorts {
+		if port == c.Port || slices.Contains(c.ExtraPorts[:i], port) {
+			return fmt.Errorf("port %d is claimed twice", port)
+		}
+	}
+	if c.BestEffortPorts && c.Connections > 1 {
+		return fmt.Errorf("best-effort ports cannot be combined with striped connections")
+	}
+
+	if err := proto.ValidateLabels(c.Labels); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+
+	for _, server := range c.DNSServers {
+		if err := ValidateDNSServer(server); err != nil {
+			return err
+		}
+	}
+
+	if _, err := ParsePortRanges(c.AllowPorts); err != nil {
+		return err
+	}
+	if _, err := ParsePortRanges(c.DenyPorts); err != nil {
+		return err
+	}
+
+	for _, pattern := range append(append([]string(nil), c.AllowDomains...), c.DenyDomains...) {
+		if err := ValidateDomainPattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	for port, sources := range c.PortSourceAddrs {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d for source addresses", port)
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("no source addresses for port %d", port)
+		}
+	}
+	for port := range c.PortEgressProxy {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d for egress proxy", port)
+		}
+	}
+	for port, mode := range c.PortIPMode {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d for IP mode", port)
+		}
+		switch mode {
+		case IPModeIPv4Only, IPModeIPv6Only, IPModePreferIPv4, IPModeHappyEyeballs:
+		default:
+			return fmt.Errorf("invalid IP mode %q for port %d", mode, port)
+		}
+	}
+
+	def, ports := c.dialerConfigs()
+	if err := validateDialerConfig(def); err != nil {
+		return err
+	}
+	for port, cfg := range ports {
+		if err := validateDialerConfig(cfg); err != nil {
+			return fmt.Errorf("port %d: %w", port, err)
+		}
+	}
+
+	if err := ValidateServerAddr(c.ServerAddr); err != nil {
+		return err
+	}
+
+	if c.Proxy != "" && c.Proxy != ProxyDirect {
+		if transport.IsQUICURL(c.ServerAddr) {
+			return fmt.Errorf("proxy is not supported with the QUIC transport")
+		}
+		if _, err := transport.ParseProxyURL(c.Proxy); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// dialerConfigs returns the settings of the default dialer and of the
+// claimed ports whose IP mode, source addresses or egress proxy differ from
+// it.
+func (c *Config) dialerConfigs() (DialerConfig, map[int]DialerConfig) {
+	def := DialerConfig{
+		IPMode:      c.IPMode,
+		Timeout:     c.DialTimeout,
+		SourceAddrs: c.SourceAddrs,
+		Proxy:       c.EgressProxy,
+	}
+	ports := make(map[int]DialerConfig, len(c.PortIPMode)+len(c.PortSourceAddrs)+len(c.PortEgressProxy))
+	portConfig := func(port int) DialerConfig {
+		if cfg, ok := ports[port]; ok {
+			return cfg
+		}
+		return def
+	}
+	for port, mode := range c.PortIPMode {
+		cfg := portConfig(port)
+		cfg.IPMode = mode
+		ports[port] = cfg
+	}
+	for port, sources := range c.PortSourceAddrs {
+		cfg := portConfig(port)
+		cfg.SourceAddrs = sources
+		ports[port] = cfg
+	}
+	for port, proxy := range c.PortEgressProxy {
+		cfg := portConfig(port)
+		cfg.Proxy = proxy
+		if proxy == ProxyDirect {
+			cfg.Proxy = ""
+		}
+		ports[port] = cfg
+	}
+	return def, ports
+}
+
+// validateDialerConfig checks the egress proxy of cfg and that it is not
+// combined with source addresses.
+func validateDialerConfig(cfg DialerConfig) error {
+	if cfg.Proxy == "" {
+		return nil
+	}
+	if len(cfg.SourceAddrs) > 0 {
+		return fmt.Errorf("source addresses cannot be combined with an egress proxy")
+	}
+	_, err := transport.ParseProxyURL(cfg.Proxy)
+	return err
+}
+
 // ValidateCIDRs validates that all provided strings are valid CIDR blocks.
 func ValidateCIDRs(cidrs []string) error {
 	for _, cidr := range cidrs {
@@ -50,6 +253,22 @@ func ValidateCIDRs(cidrs []string) error {
 	return nil
 }
 
+// ValidateServerAddr checks that addr is host:port or a ws://, wss:// or quic:// URL.
+func ValidateServerAddr(addr string) error {
+	if transport.IsWebSocketURL(addr) {
+		_, err := transport.ParseWebSocketURL(addr)
+		return err
+	}
+	if transport.IsQUICURL(addr) {
+		_, err := transport.ParseQUICURL(addr)
+		return err
+	}
+	if strings.Contains(addr, "://") {
+		return fmt.Errorf("unsupported server address %q: expected host:port, ws://, wss:// or quic:// URL", addr)
+	}
+	return nil
+}
+
 // ParseCommaSeparated splits a comma-separated string into trimmed strings.
 func ParseCommaSeparated(s string) []string {
 	if s == "" {
@@ -64,3 +283,117 @@ func ParseCommaSeparated(s string) []string {
 	}
 	return result
 }
+
+// ParsePorts parses comma-separated ports and ranges such as "20002,20005-20007".
+func ParsePorts(s string) ([]int, error) {
+	ranges, err := ParsePortRanges(ParseCommaSeparated(s))
+	if err != nil {
+		return nil, err
+	}
+	var ports []int
+	for _, r := range ranges {
+		if r.Max-r.Min >= proto.MaxPortCount {
+			return nil, fmt.Errorf("port range %d-%d holds more than %d ports", r.Min, r.Max, proto.MaxPortCount)
+		}
+		for port := r.Min; port <= r.Max; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// ParsePortSourceAddrs parses per-port source addresses such as
+// "20001=203.0.113.10|203.0.113.11,20002=eth1": comma-separated port=sources
+// entries, each with one or more "|"-separated IPs or interface names.
+func ParsePortSourceAddrs(s string) (map[int][]string, error) {
+	result := make(map[int][]string)
+	for _, part := range ParseCommaSeparated(s) {
+		portStr, value, found := strings.Cut(part, "=")
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid port source %q: expected port=addr[|addr...]", part)
+		}
+		if _, dup := result[port]; dup {
+			return nil, fmt.Errorf("duplicate source addresses for port %d", port)
+		}
+		var sources []string
+		for _, source := range strings.Split(value, "|") {
+			if source = strings.TrimSpace(source); source != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("invalid port source %q: no addresses", part)
+		}
+		result[port] = sources
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// ParsePortEgressProxy parses per-port egress proxies such as
+// "20001=socks5://127.0.0.1:9050,20002=direct": comma-separated port=proxy
+// entries, where the proxy is a URL or ProxyDirect.
+func ParsePortEgressProxy(s string) (map[int]string, error) {
+	result := make(map[int]string)
+	for _, part := range ParseCommaSeparated(s) {
+		portStr, proxy, found := strings.Cut(part, "=")
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		proxy = strings.TrimSpace(proxy)
+		if !found || err != nil || proxy == "" {
+			return nil, fmt.Errorf("invalid port egress proxy %q: expected port=url or port=%s", part, ProxyDirect)
+		}
+		if _, dup := result[port]; dup {
+			return nil, fmt.Errorf("duplicate egress proxy for port %d", port)
+		}
+		result[port] = proxy
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// ParsePortIPMode parses per-port IP modes such as
+// "20001=ipv4-only,20002=happy-eyeballs": comma-separated port=mode entries.
+func ParsePortIPMode(s string) (map[int]string, error) {
+	result := make(map[int]string)
+	for _, part := range ParseCommaSeparated(s) {
+		portStr, mode, found := strings.Cut(part, "=")
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		mode = strings.TrimSpace(mode)
+		if !found || err != nil || mode == "" {
+			return nil, fmt.Errorf("invalid port IP mode %q: expected port=mode", part)
+		}
+		if _, dup := result[port]; dup {
+			return nil, fmt.Errorf("duplicate IP mode for port %d", port)
+		}
+		result[port] = mode
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// ParseLabels parses comma-separated key=value labels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, part := range ParseCommaSeparated(s) {
+		key, value, found := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", part)
+		}
+		if _, dup := labels[key]; dup {
+			return nil, fmt.Errorf("duplicate label %q", key)
+		}
+		labels[key] = value
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// ErrNoControlStream is returned for requests that need a control stream
+// while the client is not connected, or the server does not support one.
+var ErrNoControlStream = errors.New("no control stream to the server")
+
+// Ports the server rejected as in use are requested again this often, and
+// each request may take up to portRetryTimeout.
+var (
+	portRetryInterval = 30 * time.Second
+	portRetryTimeout  = 10 * time.Second
+)
+
+// streamStats counts the streams a client has served since it started.
+type streamStats struct {
+	active    atomic.Int64
+	total     atomic.Int64
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+}
+
+// countingConn counts the bytes of a stream: reads go up to the target,
+// writes come down from it.
+type countingConn struct {
+	net.Conn
+	stats *streamStats
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.bytesUp.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.bytesDown.Add(int64(n))
+	return n, err
+}
+
+// controlHandler answers the requests the server sends on the control stream.
+// rules and quota may be nil when not configured.
+func (c *Client) controlHandler(rules *DomainRules, quota *Quota) proto.ControlHandler {
+	return func(method string, _ []byte) (any, error) {
+		switch method {
+		case proto.MethodPing:
+			return nil, nil
+		case proto.MethodStats:
+			stats := proto.ClientStats{
+				ActiveStreams: c.stats.active.Load(),
+				TotalStreams:  c.stats.total.Load(),
+				BytesUp:       c.stats.bytesUp.Load(),
+				BytesDown:     c.stats.bytesDown.Load(),
+			}
+			if quota != nil {
+				stats.QuotaUsed, stats.QuotaLimit = quota.Usage()
+			}
+			return stats, nil
+		case proto.MethodReload:
+			if rules == nil {
+				return nil, errors.New("no domain rules configured")
+			}
+			changed, err := rules.Reload()
+			if err != nil {
+				c.Logger.Warn("Failed to reload domain rules on server request", "error", err)
+				return nil, err
+			}
+			c.Logger.Info("Reloaded domain rules on server request", "changed", changed)
+			return proto.ReloadResult{Changed: changed}, nil
+		}
+		return nil, proto.ErrUnknownControlMethod
+	}
+}
+
+// openControl opens the control stream on session and serves it in the
+// background until the session closes.
+func (c *Client) openControl(session transport.Session, handler proto.ControlHandler, logger *slog.Logger) error {
+	stream, err := session.Open()
+	if err != nil {
+		return err
+	}
+
+	conn := proto.NewControlConn(stream, handler)
+	c.control.Store(conn)
+	go func() {
+		err := conn.Serve()
+		c.control.CompareAndSwap(conn, nil)
+		logger.Debug("Control stream closed", "error", err)
+	}()
+
+	// Transports such as QUIC announce a stream only once data flows on it
+	if err := conn.Notify(proto.MethodPing, nil); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Ports returns the ports the client claims: Config.Port, Config.ExtraPorts
+// and those added since, less those removed.
+func (c *Client) Ports() []int {
+	c.portsMu.Lock()
+	defer c.portsMu.Unlock()
+	return c.portsLocked()
+}
+
+func (c *Client) portsLocked() []int {
+	if c.ports == nil {
+		return append([]int{c.Config.Port}, c.Config.ExtraPorts...)
+	}
+	return slices.Clone(c.ports)
+}
+
+// AddPort asks the server to bind one more port for the session, without
+// reconnecting. The port is claimed again on every reconnect.
+func (c *Client) AddPort(ctx context.Context, port int) error {
+	return c.changePorts(ctx, proto.MethodAddPorts, port)
+}
+
+// RemovePort asks the server to release a port of the session. Connections
+// already open on it continue; the last port cannot be removed.
+func (c *Client) RemovePort(ctx context.Context, port int) error {
+	return c.changePorts(ctx, proto.MethodRemovePorts, port)
+}
+
+func (c *Client) changePorts(ctx context.Context, method string, port int) error {
+	conn := c.control.Load()
+	if conn == nil {
+		return ErrNoControlStream
+	}
+
+	var result proto.PortsResult
+	if err := conn.Call(ctx, method, proto.PortsRequest{Ports: []int{port}}, &result); err != nil {
+		return err
+	}
+
+	// Claimed ports the server has not bound yet are kept for retries
+	c.portsMu.Lock()
+	ports := c.portsLocked()
+	if method == proto.MethodAddPorts && !slices.Contains(ports, port) {
+		ports = append(ports, port)
+	} else if method == proto.MethodRemovePorts {
+		ports = slices.DeleteFunc(ports, func(p int) bool { return p == port })
+	}
+	c.ports = ports
+	c.portsMu.Unlock()
+
+	c.Logger.Info("Ports changed", "method", method, "port", port, "ports", result.Ports)
+	return nil
+}
+
+// retryRejectedPorts requests the ports the server rejected as in use again
+// until it binds them, they are removed, or session closes. Ports outside
+// the server's range are not retried.
+func (c *Client) retryRejectedPorts(session transport.Session, rejected []proto.PortRejection, logger *slog.Logger) {
+	var missing []int
+	for _, r := range rejected {
+		if r.Status != proto.StatusPortInUse {
+			logger.Error("Port refused by the server, not retrying", "port", r.Port, "status", statusName(r.Status))
+			continue
+		}
+		missing = append(missing, int(r.Port))
+	}
+
+	ticker := time.NewTicker(portRetryInterval)
+	defer ticker.Stop()
+
+	for len(missing) > 0 {
+		select {
+		case <-session.CloseChan():
+			return
+		case <-ticker.C:
+		}
+
+		var remaining []int
+		for _, port := range missing {
+			if !slices.Contains(c.Ports(), port) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), portRetryTimeout)
+			err := c.AddPort(ctx, port)
+			cancel()
+			if err != nil {
+				logger.Debug("Port still unavailable", "port", port, "error", err)
+				remaining = append(remaining, port)
+				continue
+			}
+			logger.Info("Claimed previously rejected port", "port", port)
+		}
+		missing = remaining
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+func TestCountingConn(t *testing.T) {
+	var stats streamStats
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+	conn := &countingConn{Conn: local, stats: &stats}
+
+	go func() {
+		_, _ = remote.Write([]byte("hello"))
+		_, _ = remote.Read(make([]byte, 16))
+	}()
+	if _, err := conn.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if up, down := stats.bytesUp.Load(), stats.bytesDown.Load(); up != 5 || down != 3 {
+		t.Errorf("bytes up/down = %d/%d, want 5/3", up, down)
+	}
+}
+
+func TestControlStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	writeFile(t, path, "blocked.example.com\n")
+	rules, err := NewDomainRules(DomainDefaultAllow, nil, nil, nil, []string{path})
+	if err != nil {
+		t.Fatalf("NewDomainRules() error = %v", err)
+	}
+
+	c := newHandshakeTestClient()
+	c.stats.active.Store(2)
+	c.stats.total.Store(7)
+	c.stats.bytesDown.Store(1024)
+
+	clientConn, serverConn := net.Pipe()
+	clientSession, err := yamux.Client(clientConn, nil)
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	defer func() { _ = clientSession.Close() }()
+	serverSession, err := yamux.Server(serverConn, nil)
+	if err != nil {
+		t.Fatalf("yamux.Server() error = %v", err)
+	}
+	defer func() { _ = serverSession.Close() }()
+
+	if err := c.openControl(clientSession, c.controlHandler(rules, nil), c.Logger); err != nil {
+		t.Fatalf("openControl() error = %v", err)
+	}
+	stream, err := serverSession.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	control := proto.NewControlConn(stream, func(method string, _ []byte) (any, error) { return nil, nil })
+	go func() { _ = control.Serve() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stats proto.ClientStats
+	if err := control.Call(ctx, proto.MethodStats, nil, &stats); err != nil {
+		t.Fatalf("Call(stats) error = %v", err)
+	}
+	want := proto.ClientStats{ActiveStreams: 2, TotalStreams: 7, BytesDown: 1024}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	var reload proto.ReloadResult
+	if err := control.Call(ctx, proto.MethodReload, nil, &reload); err != nil {
+		t.Fatalf("Call(reload) error = %v", err)
+	}
+	if reload.Changed {
+		t.Error("reload reported a change for an unchanged file")
+	}
+
+	// Without domain rules there is nothing to reload
+	var ctrlErr *proto.ControlError
+	handler := c.controlHandler(nil, nil)
+	if _, err := handler(proto.MethodReload, nil); err == nil {
+		t.Error("reload without domain rules expected error")
+	}
+	if err := control.Call(ctx, "unknown", nil, nil); !errors.As(err, &ctrlErr) {
+		t.Errorf("Call(unknown) error = %v, want ControlError", err)
+	}
+}
+
+func TestClientPortChanges(t *testing.T) {
+	c := newHandshakeTestClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.AddPort(ctx, 20002); !errors.Is(err, ErrNoControlStream) {
+		t.Fatalf("AddPort() without control stream error = %v, want %v", err, ErrNoControlStream)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+	held := []int{20001}
+	server := proto.NewControlConn(serverConn, func(method string, payload []byte) (any, error) {
+		var req proto.PortsRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, nil // ping notification
+		}
+		switch method {
+		case proto.MethodAddPorts:
+			held = append(held, req.Ports...)
+		case proto.MethodRemovePorts:
+			held = slices.DeleteFunc(held, func(p int) bool { return slices.Contains(req.Ports, p) })
+		}
+		return proto.PortsResult{Ports: held}, nil
+	})
+	go func() { _ = server.Serve() }()
+	control := proto.NewControlConn(clientConn, nil)
+	go func() { _ = control.Serve() }()
+	c.control.Store(control)
+
+	if err := c.AddPort(ctx, 20002); err != nil {
+		t.Fatalf("AddPort() error = %v", err)
+	}
+	if err := c.RemovePort(ctx, 20001); err != nil {
+		t.Fatalf("RemovePort() error = %v", err)
+	}
+	if got := c.Ports(); !slices.Equal(got, []int{20002}) {
+		t.Errorf("Ports() = %v, want [20002]", got)
+	}
+
+	// Reconnects claim the changed ports
+	helloConn, helloServer := net.Pipe()
+	defer func() { _ = helloConn.Close() }()
+	hellos := serveHandshake(t, helloServer, proto.HelloResp{Version: proto.Version2, Status: proto.StatusOK})
+	if _, err := c.handshake(helloConn, nil); err != nil {
+		t.Fatalf("handshake() error = %v", err)
+	}
+	if hello := <-hellos; !slices.Equal(hello.Ports, []uint16{20002}) {
+		t.Errorf("HELLO ports = %v, want [20002]", hello.Ports)
+	}
+}
+
+func TestRetryRejectedPorts(t *testing.T) {
+	interval := portRetryInterval
+	portRetryInterval = 10 * time.Millisecond
+	defer func() { portRetryInterval = interval }()
+
+	c := newHandshakeTestClient()
+	c.Config.ExtraPorts = []int{20002, 20003}
+
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+	added := make(chan int, 4)
+	var attempts atomic.Int32
+	server := proto.NewControlConn(serverConn, func(method string, payload []byte) (any, error) {
+		if method != proto.MethodAddPorts {
+			return nil, nil
+		}
+		// The port is busy for the first two attempts
+		if attempts.Add(1) <= 2 {
+			return nil, errors.New("port 20002 already in use")
+		}
+		var req proto.PortsRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		added <- req.Ports[0]
+		return proto.PortsResult{Ports: []int{20001, 20002}}, nil
+	})
+	go func() { _ = server.Serve() }()
+	control := proto.NewControlConn(clientConn, nil)
+	go func() { _ = control.Serve() }()
+	c.control.Store(control)
+
+	clientSession, serverSession := net.Pipe()
+	defer func() { _ = serverSession.Close() }()
+	session, err := yamux.Client(clientSession, nil)
+	if err != nil {
+		t.Fatalf("yamux.Client() error = %v", err)
+	}
+	defer func() { _ = session.Close() }()
+
+	done := make(chan struct{})
+	go func() {
+		c.retryRejectedPorts(session, []proto.PortRejection{
+			{Port: 20002, Status: proto.StatusPortInUse},
+			{Port: 20003, Status: proto.StatusPortForbidden},
+		}, c.Logger)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryRejectedPorts() did not finish")
+	}
+	if got := <-added; got != 20002 {
+		t.Errorf("Added port %d, want 20002", got)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("add_ports attempts = %d, want 3 (the forbidden port is not retried)", n)
+	}
+	if got := c.Ports(); !slices.Equal(got, []int{20001, 20002, 20003}) {
+		t.Errorf("Ports() = %v, want [20001 20002 20003]", got)
+	}
+}
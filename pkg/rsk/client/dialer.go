@@ -0,0 +1,341 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// IP modes for dialing targets.
+const (
+	IPModeIPv4Only      = "ipv4-only"      // Dial IPv4 addresses only
+	IPModeIPv6Only      = "ipv6-only"      // Dial IPv6 addresses only
+	IPModePreferIPv4    = "prefer-ipv4"    // Race both families, starting with IPv4
+	IPModeHappyEyeballs = "happy-eyeballs" // Race both families, starting with IPv6 (RFC 8305)
+)
+
+// defaultAttemptDelay is the head start each connection attempt gets before
+// the next one is started (RFC 8305, section 5).
+const defaultAttemptDelay = 250 * time.Millisecond
+
+// DialerConfig configures a Dialer.
+type DialerConfig struct {
+	IPMode      string        // One of the IPMode constants (default IPModeHappyEyeballs)
+	Timeout     time.Duration // Bounds each DialDecision call as a whole
+	SourceAddrs []string      // Local IPs or interface names to dial from, rotated per connection
+	Proxy       string        // Upstream http://, https:// or socks5:// proxy to reach targets through (empty dials directly)
+}
+
+// Dialer connects to the vetted addresses of a Decision.
+type Dialer struct {
+	mode         string
+	timeout      time.Duration
+	attemptDelay time.Duration
+	sources4     []net.IP // Source addresses per family; empty means any
+	sources6     []net.IP
+	next         atomic.Uint64 // Rotates through the source addresses
+	proxy        string        // Egress proxy URL with the password redacted, for logging
+
+	// dial opens a single connection from local, if not nil; replaced in tests.
+	dial func(ctx context.Context, local *net.TCPAddr, address string) (net.Conn, error)
+}
+
+// NewDialer creates a dialer. Source addresses are checked against the
+// addresses assigned to the host's interfaces.
+func NewDialer(cfg DialerConfig) (*Dialer, error) {
+	mode := cfg.IPMode
+	switch mode {
+	case "":
+		mode = IPModeHappyEyeballs
+	case IPModeIPv4Only, IPModeIPv6Only, IPModePreferIPv4, IPModeHappyEyeballs:
+	default:
+		return nil, fmt.Errorf("invalid IP mode %q: expected %s, %s, %s or %s",
+			mode, IPModeIPv4Only, IPModeIPv6Only, IPModePreferIPv4, IPModeHappyEyeballs)
+	}
+
+	sources, err := ResolveSourceAddrs(cfg.SourceAddrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) > 0 && cfg.Proxy != "" {
+		return nil, fmt.Errorf("source addresses cannot be combined with an egress proxy")
+	}
+
+	dl := &Dialer{
+		mode:         mode,
+		timeout:      cfg.Timeout,
+		attemptDelay: defaultAttemptDelay,
+		dial:         dialTCP,
+	}
+	if cfg.Proxy != "" {
+		proxyURL, err := transport.ParseProxyURL(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxyDial, err := transport.ProxyDialer(proxyURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		dl.proxy = proxyURL.Redacted()
+		// The proxy is asked for the vetted IP, never the host name, so the
+		// filter's decision holds for the final destination
+		dl.dial = func(ctx context.Context, _ *net.TCPAddr, address string) (net.Conn, error) {
+			return proxyDial(ctx, "tcp", address)
+		}
+	}
+	for _, ip := range sources {
+		if ip.To4() != nil {
+			dl.sources4 = append(dl.sources4, ip)
+		} else {
+			dl.sources6 = append(dl.sources6, ip)
+		}
+	}
+	return dl, nil
+}
+
+// Dialers holds the dialer for each claimed port with its own dialing
+// settings and the default dialer for all other streams.
+type Dialers struct {
+	def   *Dialer
+	ports map[int]*Dialer
+}
+
+// NewDialers creates the default dialer from def and one dialer per entry
+// of ports.
+func NewDialers(def DialerConfig, ports map[int]DialerConfig) (*Dialers, error) {
+	d := &Dialers{ports: make(map[int]*Dialer, len(ports))}
+	var err error
+	if d.def, err = NewDialer(def); err != nil {
+		return nil, err
+	}
+	for port, cfg := range ports {
+		if d.ports[port], err = NewDialer(cfg); err != nil {
+			return nil, fmt.Errorf("port %d: %w", port, err)
+		}
+	}
+	return d, nil
+}
+
+// For returns the dialer for streams that arrived on port (0 if unknown).
+func (d *Dialers) For(port int) *Dialer {
+	if dl, ok := d.ports[port]; ok {
+		return dl
+	}
+	return d.def
+}
+
+// ResolveSourceAddrs expands source entries into local IP addresses. An
+// entry is either an IP address, which must be assigned to one of the
+// host's interfaces, or an interface name, which stands for its global
+// unicast addresses.
+func ResolveSourceAddrs(entries []string) ([]net.IP, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	hostAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host addresses: %w", err)
+	}
+
+	var ips []net.IP
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			if !containsAddr(hostAddrs, ip) {
+				return nil, fmt.Errorf("source address %s is not assigned to this host", ip)
+			}
+			ips = append(ips, ip)
+			continue
+		}
+
+		iface, err := net.InterfaceByName(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %q: not an IP address or interface: %w", entry, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of interface %s: %w", entry, err)
+		}
+		found := false
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && (ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsLoopback()) {
+				ips = append(ips, ipNet.IP)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("interface %s has no usable addresses", entry)
+		}
+	}
+	return ips, nil
+}
+
+func containsAddr(addrs []net.Addr, ip net.IP) bool {
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func dialTCP(ctx context.Context, local *net.TCPAddr, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	if local != nil {
+		dialer.LocalAddr = local
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// DialDecision connects to one of the addresses in d.Allowed and returns the
+// connection and the IP address it was made to. The host name is never
+// resolved again, so the dialed address is always one the filter vetted.
+//
+// Addresses of both families are interleaved and attempts are staggered by
+// the attempt delay, so an unreachable family costs a fraction of a second
+// instead of the whole timeout. The first connection to succeed wins and the
+// others are closed.
+func (dl *Dialer) DialDecision(ctx context.Context, d *Decision) (net.Conn, net.IP, error) {
+	if len(d.Allowed) == 0 {
+		return nil, nil, fmt.Errorf("no permitted addresses to dial")
+	}
+
+	ips := dl.order(d.Allowed)
+	if len(ips) == 0 {
+		return nil, nil, fmt.Errorf("no permitted addresses of a usable family to dial (mode %s)", dl.mode)
+	}
+	seq := dl.next.Add(1) - 1
+
+	ctx, cancel := context.WithTimeout(ctx, dl.timeout)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		ip   net.IP
+		err  error
+	}
+	// Buffered so attempts that finish after we return never block
+	results := make(chan result, len(ips))
+	started, pending := 0, 0
+	start := func() {
+		ip := ips[started]
+		started++
+		pending++
+		local := dl.source(ip, seq)
+		go func() {
+			conn, err := dl.dial(ctx, local, net.JoinHostPort(ip.String(), d.Port))
+			results <- result{conn: conn, ip: ip, err: err}
+		}()
+	}
+	// closeLosers closes connections of attempts still in flight once a winner is chosen.
+	closeLosers := func(n int) {
+		for i := 0; i < n; i++ {
+			if r := <-results; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}
+	}
+
+	start()
+	timer := time.NewTimer(dl.attemptDelay)
+	defer timer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(pending)
+				return r.conn, r.ip, nil
+			}
+			lastErr = r.err
+			// A failed attempt lets the next one start right away
+			if started < len(ips) && ctx.Err() == nil {
+				start()
+				timer.Reset(dl.attemptDelay)
+			}
+		case <-timer.C:
+			if started < len(ips) {
+				start()
+				timer.Reset(dl.attemptDelay)
+			}
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// dialStatus maps a failed DialDecision to the CONNECT_RESP status reported
+// to the server. A refusal proves the target was reached; behind a proxy it
+// only means the proxy itself is down, which is the exit's failure.
+func (dl *Dialer) dialStatus(err error) uint8 {
+	if dl.proxy == "" && errors.Is(err, syscall.ECONNREFUSED) {
+		return proto.ConnectRefused
+	}
+	return proto.ConnectUnreachable
+}
+
+// source returns the local address for dialing ip, or nil to let the system
+// choose. Connections rotate through the source addresses of ip's family.
+func (dl *Dialer) source(ip net.IP, seq uint64) *net.TCPAddr {
+	sources := dl.sources6
+	if ip.To4() != nil {
+		sources = dl.sources4
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	return &net.TCPAddr{IP: sources[seq%uint64(len(sources))]}
+}
+
+// order returns the addresses to try in order for the dialer's mode. Both
+// racing modes interleave the families, keeping the order within each.
+// With source addresses configured, families without one are dropped.
+func (dl *Dialer) order(ips []net.IP) []net.IP {
+	hasSources := len(dl.sources4) > 0 || len(dl.sources6) > 0
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if !hasSources || len(dl.sources4) > 0 {
+				v4 = append(v4, ip)
+			}
+		} else if !hasSources || len(dl.sources6) > 0 {
+			v6 = append(v6, ip)
+		}
+	}
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil
+	}
+
+	switch dl.mode {
+	case IPModeIPv4Only:
+		return v4
+	case IPModeIPv6Only:
+		return v6
+	case IPModePreferIPv4:
+		return interleave(v4, v6)
+	default:
+		return interleave(v6, v4)
+	}
+}
+
+// interleave alternates between first and second, starting with first.
+func interleave(first, second []net.IP) []net.IP {
+	out := make([]net.IP, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,426 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDialer(t *testing.T, mode string) *Dialer {
+	t.Helper()
+	dialer, err := NewDialer(DialerConfig{IPMode: mode, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	return dialer
+}
+
+func TestDialDecision_DialsVettedIP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// The host name does not resolve; only the vetted IP may be used
+	d := &Decision{
+		Host:    "unresolvable.invalid",
+		Port:    port,
+		Allowed: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	dialer := newTestDialer(t, "")
+	conn, ip, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("DialDecision() ip = %v, want 127.0.0.1", ip)
+	}
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Errorf("DialDecision() connected to %v, want %v", conn.RemoteAddr(), listener.Addr())
+	}
+}
+
+func TestDialDecision_NoAllowedAddresses(t *testing.T) {
+	d := &Decision{Host: "blocked.example", Port: "80", Denied: []net.IP{net.ParseIP("127.0.0.1")}}
+	if _, _, err := newTestDialer(t, "").DialDecision(context.Background(), d); err == nil {
+		t.Error("DialDecision() expected error without allowed addresses")
+	}
+}
+
+func TestNewDialer_InvalidMode(t *testing.T) {
+	if _, err := NewDialer(DialerConfig{IPMode: "ipv5-only"}); err == nil {
+		t.Error("NewDialer() expected error for invalid mode")
+	}
+}
+
+func TestDialer_Order(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("2001:db8::3"),
+		net.ParseIP("192.0.2.1"),
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{mode: IPModeHappyEyeballs, want: []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "2001:db8::3"}},
+		{mode: IPModePreferIPv4, want: []string{"192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{mode: IPModeIPv4Only, want: []string{"192.0.2.1"}},
+		{mode: IPModeIPv6Only, want: []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			assertIPs(t, "order()", newTestDialer(t, tt.mode).order(ips), tt.want)
+		})
+	}
+
+	// Happy eyeballs starts with IPv6 even when the resolver put IPv4 first
+	v4First := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")}
+	assertIPs(t, "order()", newTestDialer(t, IPModeHappyEyeballs).order(v4First),
+		[]string{"2001:db8::1", "192.0.2.1", "192.0.2.2"})
+}
+
+// fakeDial simulates targets: listed addresses connect after a delay, others
+// hang until the context ends. It records every connection it hands out.
+type fakeDial struct {
+	delays map[string]time.Duration // Keyed by host; a negative delay fails immediately
+
+	mu      sync.Mutex
+	conns   []net.Conn
+	order   []string
+	sources []string // Local address of each attempt, empty if unset
+}
+
+func (f *fakeDial) dial(ctx context.Context, local *net.TCPAddr, address string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(address)
+	f.mu.Lock()
+	f.order = append(f.order, host)
+	if local != nil {
+		f.sources = append(f.sources, local.IP.String())
+	} else {
+		f.sources = append(f.sources, "")
+	}
+	f.mu.Unlock()
+
+	delay, ok := f.delays[host]
+	if ok && delay < 0 {
+		return nil, errors.New("connection refused")
+	}
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	conn, peer := net.Pipe()
+	go func() { _ = peer.Close() }()
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+	return conn, nil
+}
+
+func (f *fakeDial) attempts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.order...)
+}
+
+func TestDialer_FallsBackFromUnreachableFamily(t *testing.T) {
+	fake := &fakeDial{delays: map[string]time.Duration{"192.0.2.1": 0}}
+	dialer := newTestDialer(t, IPModeHappyEyeballs)
+	dialer.attemptDelay = 20 * time.Millisecond
+	dialer.dial = fake.dial
+
+	// IPv6 is broken and hangs; IPv4 must win well before the timeout
+	d := &Decision{Port: "443", Allowed: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+
+	start := time.Now()
+	conn, ip, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("DialDecision() ip = %v, want 192.0.2.1", ip)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("DialDecision() took %v, want well under the 1s timeout", elapsed)
+	}
+}
+
+func TestDialer_FailureStartsNextAttempt(t *testing.T) {
+	fake := &fakeDial{delays: map[string]time.Duration{"2001:db8::1": -1, "192.0.2.1": 0}}
+	dialer := newTestDialer(t, IPModeHappyEyeballs)
+	dialer.attemptDelay = time.Hour // Only a failure may start the next attempt
+	dialer.dial = fake.dial
+
+	d := &Decision{Port: "443", Allowed: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	conn, ip, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("DialDecision() ip = %v, want 192.0.2.1", ip)
+	}
+}
+
+func TestDialer_ClosesLosingConnections(t *testing.T) {
+	fake := &fakeDial{delays: map[string]time.Duration{
+		"2001:db8::1": 50 * time.Millisecond,
+		"192.0.2.1":   0,
+	}}
+	dialer := newTestDialer(t, IPModeHappyEyeballs)
+	dialer.attemptDelay = 10 * time.Millisecond
+	dialer.dial = fake.dial
+
+	d := &Decision{Port: "443", Allowed: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	conn, ip, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("DialDecision() ip = %v, want 192.0.2.1", ip)
+	}
+
+	// The slower IPv6 attempt is canceled with the dial context
+	time.Sleep(100 * time.Millisecond)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.conns) != 1 {
+		t.Errorf("established %d connections, want only the winner", len(fake.conns))
+	}
+}
+
+func TestDialer_FamilyRestriction(t *testing.T) {
+	fake := &fakeDial{delays: map[string]time.Duration{"2001:db8::1": 0, "192.0.2.1": 0}}
+	dialer := newTestDialer(t, IPModeIPv4Only)
+	dialer.dial = fake.dial
+
+	d := &Decision{Port: "443", Allowed: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	conn, _, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	_ = conn.Close()
+	if got := fake.attempts(); len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Errorf("attempts = %v, want only 192.0.2.1", got)
+	}
+
+	d.Allowed = []net.IP{net.ParseIP("2001:db8::1")}
+	if _, _, err := dialer.DialDecision(context.Background(), d); err == nil {
+		t.Error("DialDecision() expected error without IPv4 addresses")
+	}
+}
+
+func TestResolveSourceAddrs(t *testing.T) {
+	if _, err := ResolveSourceAddrs([]string{"127.0.0.1"}); err != nil {
+		t.Errorf("ResolveSourceAddrs(127.0.0.1) error = %v", err)
+	}
+	if _, err := ResolveSourceAddrs([]string{"192.0.2.123"}); err == nil {
+		t.Error("ResolveSourceAddrs() expected error for an address not assigned to the host")
+	}
+	if _, err := ResolveSourceAddrs([]string{"no-such-interface0"}); err == nil {
+		t.Error("ResolveSourceAddrs() expected error for an unknown interface")
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("Interfaces() error = %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ips, err := ResolveSourceAddrs([]string{iface.Name})
+		if err != nil {
+			t.Fatalf("ResolveSourceAddrs(%s) error = %v", iface.Name, err)
+		}
+		if len(ips) == 0 {
+			t.Errorf("ResolveSourceAddrs(%s) returned no addresses", iface.Name)
+		}
+		break
+	}
+}
+
+func TestDialer_SourceAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer, err := NewDialer(DialerConfig{Timeout: time.Second, SourceAddrs: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+
+	d := &Decision{Port: port, Allowed: []net.IP{net.ParseIP("127.0.0.1")}}
+	conn, _, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if local := conn.LocalAddr().(*net.TCPAddr); !local.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("LocalAddr() = %v, want 127.0.0.1", local)
+	}
+}
+
+func TestDialers_For(t *testing.T) {
+	dialers, err := NewDialers(DialerConfig{Timeout: time.Second},
+		map[int]DialerConfig{20002: {Timeout: time.Second, SourceAddrs: []string{"127.0.0.1"}}})
+	if err != nil {
+		t.Fatalf("NewDialers() error = %v", err)
+	}
+
+	if got := dialers.For(20002); len(got.sources4) != 1 || !got.sources4[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("For(20002) sources = %v, want the port's source address", got.sources4)
+	}
+	for _, port := range []int{0, 20001} {
+		if got := dialers.For(port); got != dialers.def {
+			t.Errorf("For(%d) did not return the default dialer", port)
+		}
+	}
+
+	_, err = NewDialers(DialerConfig{}, map[int]DialerConfig{20002: {SourceAddrs: []string{"192.0.2.123"}}})
+	if err == nil {
+		t.Error("NewDialers() expected error for a source address not on this host")
+	}
+}
+
+func TestDialer_RotatesSourceAddrs(t *testing.T) {
+	fake := &fakeDial{delays: map[string]time.Duration{"192.0.2.1": 0}}
+	dialer := newTestDialer(t, IPModeHappyEyeballs)
+	dialer.dial = fake.dial
+	dialer.sources4 = []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("198.51.100.2")}
+
+	// Without an IPv6 source the IPv6 address is never tried
+	d := &Decision{Port: "443", Allowed: []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1")}}
+	for i := 0; i < 3; i++ {
+		conn, _, err := dialer.DialDecision(context.Background(), d)
+		if err != nil {
+			t.Fatalf("DialDecision() error = %v", err)
+		}
+		_ = conn.Close()
+	}
+
+	want := []string{"198.51.100.1", "198.51.100.2", "198.51.100.1"}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.sources) != len(want) {
+		t.Fatalf("sources = %v, want %v", fake.sources, want)
+	}
+	for i := range want {
+		if fake.sources[i] != want[i] || fake.order[i] != "192.0.2.1" {
+			t.Errorf("attempt %d from %s to %s, want %s to 192.0.2.1", i, fake.sources[i], fake.order[i], want[i])
+		}
+	}
+
+	d.Allowed = []net.IP{net.ParseIP("2001:db8::1")}
+	if _, _, err := dialer.DialDecision(context.Background(), d); err == nil {
+		t.Error("DialDecision() expected error without an IPv6 source address")
+	}
+}
+
+// startConnectProxy runs an HTTP CONNECT proxy on loopback that sends every
+// requested target to targets.
+func startConnectProxy(t *testing.T, targets chan<- string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				targets <- req.Host
+
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer func() { _ = upstream.Close() }()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestDialer_EgressProxy(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = target.Close() }()
+	go func() {
+		conn, err := target.Accept()
+		if err == nil {
+			_, _ = io.WriteString(conn, "hello")
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+
+	targets := make(chan string, 1)
+	proxyAddr := startConnectProxy(t, targets)
+
+	dialer, err := NewDialer(DialerConfig{Timeout: time.Second, Proxy: "http://" + proxyAddr})
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+
+	// The proxy must be asked for the vetted IP, not the host name
+	d := &Decision{Host: "unresolvable.invalid", Port: port, Allowed: []net.IP{net.ParseIP("127.0.0.1")}}
+	conn, _, err := dialer.DialDecision(context.Background(), d)
+	if err != nil {
+		t.Fatalf("DialDecision() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if got := <-targets; got != target.Addr().String() {
+		t.Errorf("proxy asked for %q, want %q", got, target.Addr().String())
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("read %q, %v; want \"hello\"", data, err)
+	}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Domain rule modes.
+const (
+	DomainDefaultAllow = "allow" // Targets are allowed unless a deny rule matches
+	DomainDefaultDeny  = "deny"  // Targets are denied unless an allow rule matches
+)
+
+// defaultDomainReloadInterval is how often list files are checked for changes.
+const defaultDomainReloadInterval = 30 * time.Second
+
+// DomainRules decides whether a target host name may be connected to before
+// it is resolved. Patterns are matched case-insensitively:
+//
+//	example.com     exactly example.com
+//	*.example.com   any subdomain of example.com, but not example.com itself
+//	.example.com    example.com and any of its subdomains
+//
+// A matching deny rule always wins over a matching allow rule. Rules can be
+// given inline and loaded from list files, which are reloaded when they change.
+type DomainRules struct {
+	defaultDeny bool
+	allow       []string
+	deny        []string
+	allowFiles  []string
+	denyFiles   []string
+
+	set atomic.Pointer[domainSet]
+
+	mu     sync.Mutex           // Serializes reloads
+	stamps map[string]fileStamp // Last seen state of each list file
+}
+
+type domainSet struct {
+	allow *domainMatcher
+	deny  *domainMatcher
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewDomainRules creates domain rules with the given default mode, inline
+// patterns and list files. The list files are read immediately.
+func NewDomainRules(defaultMode string, allow, deny, allowFiles, denyFiles []string) (*DomainRules, error) {
+	switch defaultMode {
+	case "", DomainDefaultAllow, DomainDefaultDeny:
+	default:
+		return nil, fmt.Errorf("invalid domain default %q: expected %s or %s", defaultMode, DomainDefaultAllow, DomainDefaultDeny)
+	}
+
+	r := &DomainRules{
+		defaultDeny: defaultMode == DomainDefaultDeny,
+		allow:       allow,
+		deny:        deny,
+		allowFiles:  allowFiles,
+		denyFiles:   denyFiles,
+		stamps:      make(map[string]fileStamp),
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Check returns an error if connections to host are not permitted.
+// IP literals are only subject to the default mode, since they carry no name.
+func (r *DomainRules) Check(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if r.defaultDeny {
+			return fmt.Errorf("IP address targets are not allowed in default-deny domain mode")
+		}
+		return nil
+	}
+
+	set := r.set.Load()
+	name := normalizeDomain(host)
+
+	if set.deny.match(name) {
+		return fmt.Errorf("domain %q is denied", host)
+	}
+	if set.allow.match(name) {
+		return nil
+	}
+	if r.defaultDeny {
+		return fmt.Errorf("domain %q is not in the allow list", host)
+	}
+	return nil
+}
+
+// Reload re-reads all list files and replaces the active rules. It reports
+// whether any file changed since the last load. On error the previous rules
+// stay in effect.
+func (r *DomainRules) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := r.set.Load() == nil
+	stamps := make(map[string]fileStamp, len(r.allowFiles)+len(r.denyFiles))
+	for _, path := range append(append([]string(nil), r.allowFiles...), r.denyFiles...) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to stat domain list %s: %w", path, err)
+		}
+		stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+		if old, ok := r.stamps[path]; !ok || old != stamp {
+			changed = true
+		}
+		stamps[path] = stamp
+	}
+	if !changed {
+		return false, nil
+	}
+
+	allow, err := buildDomainMatcher(r.allow, r.allowFiles)
+	if err != nil {
+		return false, err
+	}
+	deny, err := buildDomainMatcher(r.deny, r.denyFiles)
+	if err != nil {
+		return false, err
+	}
+
+	r.set.Store(&domainSet{allow: allow, deny: deny})
+	r.stamps = stamps
+	return true, nil
+}
+
+// Watch polls the list files every interval and reloads them when they
+// change, until ctx is canceled.
+func (r *DomainRules) Watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if len(r.allowFiles) == 0 && len(r.denyFiles) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				logger.Warn("Failed to reload domain lists, keeping previous rules", "error", err)
+				continue
+			}
+			if changed {
+				logger.Info("Domain lists reloaded")
+			}
+		}
+	}
+}
+
+// ValidateDomainPattern checks that pattern is a domain, a *.domain wildcard
+// or a .domain suffix.
+func ValidateDomainPattern(pattern string) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(pattern, "*"), ".")
+	if name == "" || strings.ContainsAny(name, "*/: \t") || (strings.HasPrefix(pattern, "*") && !strings.HasPrefix(pattern, "*.")) {
+		return fmt.Errorf("invalid domain pattern %q", pattern)
+	}
+	return nil
+}
+
+// domainMatcher holds compiled domain patterns.
+type domainMatcher struct {
+	exact      map[string]struct{} // Names matched exactly
+	subdomains map[string]struct{} // Names whose subdomains match
+}
+
+func buildDomainMatcher(patterns, files []string) (*domainMatcher, error) {
+	m := &domainMatcher{
+		exact:      make(map[string]struct{}),
+		subdomains: make(map[string]struct{}),
+	}
+	for _, p := range patterns {
+		if err := m.add(p); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range files {
+		entries, err := readDomainList(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range entries {
+			if err := m.add(p); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *domainMatcher) add(pattern string) error {
+	if err := ValidateDomainPattern(pattern); err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		m.subdomains[normalizeDomain(pattern[2:])] = struct{}{}
+	case strings.HasPrefix(pattern, "."):
+		name := normalizeDomain(pattern[1:])
+		m.exact[name] = struct{}{}
+		m.subdomains[name] = struct{}{}
+	default:
+		m.exact[normalizeDomain(pattern)] = struct{}{}
+	}
+	return nil
+}
+
+// match reports whether name, already normalized, matches any pattern.
+func (m *domainMatcher) match(name string) bool {
+	if _, ok := m.exact[name]; ok {
+		return true
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			if _, ok := m.subdomains[name[i+1:]]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readDomainList reads a plain-text list (one pattern per line) or a hosts
+// file ("0.0.0.0 ads.example.com tracker.example.com"). Blank lines and
+// text after # are ignored.
+func readDomainList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open domain list: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Hosts-file entries start with an address followed by names
+		if net.ParseIP(fields[0]) != nil {
+			for _, name := range fields[1:] {
+				if name == "localhost" || name == "localhost.localdomain" {
+					continue
+				}
+				patterns = append(patterns, name)
+			}
+			continue
+		}
+		patterns = append(patterns, fields...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain list %s: %w", path, err)
+	}
+	return patterns, nil
+}
+
+func normalizeDomain(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
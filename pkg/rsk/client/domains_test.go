@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDomainRules_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		allow       []string
+		deny        []string
+		host        string
+		wantErr     bool
+		errContains string
+	}{
+		{name: "no rules allow by default", host: "example.com"},
+		{name: "exact deny", deny: []string{"bank.tld"}, host: "bank.tld", wantErr: true, errContains: "denied"},
+		{name: "exact deny does not cover subdomain", deny: []string{"bank.tld"}, host: "www.bank.tld"},
+		{name: "wildcard deny subdomain", deny: []string{"*.bank.tld"}, host: "login.eu.bank.tld", wantErr: true},
+		{name: "wildcard deny excludes apex", deny: []string{"*.bank.tld"}, host: "bank.tld"},
+		{name: "suffix deny apex", deny: []string{".bank.tld"}, host: "bank.tld", wantErr: true},
+		{name: "suffix deny subdomain", deny: []string{".bank.tld"}, host: "www.bank.tld", wantErr: true},
+		{name: "suffix does not match partial label", deny: []string{".bank.tld"}, host: "mybank.tld"},
+		{name: "case and trailing dot", deny: []string{"Bank.TLD"}, host: "bank.tld.", wantErr: true},
+		{name: "default deny blocks unlisted", mode: DomainDefaultDeny, allow: []string{"*.example.com"}, host: "other.com", wantErr: true, errContains: "not in the allow list"},
+		{name: "default deny allows listed", mode: DomainDefaultDeny, allow: []string{"*.example.com"}, host: "api.example.com"},
+		{name: "deny wins over allow", mode: DomainDefaultDeny, allow: []string{".example.com"}, deny: []string{"secret.example.com"}, host: "secret.example.com", wantErr: true},
+		{name: "default deny blocks IP literal", mode: DomainDefaultDeny, allow: []string{".example.com"}, host: "93.184.215.14", wantErr: true},
+		{name: "default allow passes IP literal", deny: []string{".example.com"}, host: "93.184.215.14"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := NewDomainRules(tt.mode, tt.allow, tt.deny, nil, nil)
+			if err != nil {
+				t.Fatalf("NewDomainRules() error = %v", err)
+			}
+			err = rules.Check(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+			}
+			if tt.errContains != "" && !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Check(%q) error = %q, want containing %q", tt.host, err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestValidateDomainPattern(t *testing.T) {
+	valid := []string{"example.com", "*.example.com", ".example.com", "localhost"}
+	invalid := []string{"", "*", "*.", ".", "*example.com", "a.*.example.com", "example.com:443", "http://example.com"}
+
+	for _, p := range valid {
+		if err := ValidateDomainPattern(p); err != nil {
+			t.Errorf("ValidateDomainPattern(%q) error = %v", p, err)
+		}
+	}
+	for _, p := range invalid {
+		if err := ValidateDomainPattern(p); err == nil {
+			t.Errorf("ValidateDomainPattern(%q) expected error", p)
+		}
+	}
+}
+
+func TestDomainRules_ListFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostsFile := filepath.Join(dir, "hosts")
+	plainFile := filepath.Join(dir, "deny.txt")
+
+	writeFile(t, hostsFile, "# blocklist\n127.0.0.1 localhost\n0.0.0.0 ads.example.com tracker.example.net # inline\n\n")
+	writeFile(t, plainFile, "*.bank.tld\n.casino.tld\n")
+
+	rules, err := NewDomainRules(DomainDefaultAllow, nil, nil, nil, []string{hostsFile, plainFile})
+	if err != nil {
+		t.Fatalf("NewDomainRules() error = %v", err)
+	}
+
+	for _, host := range []string{"ads.example.com", "tracker.example.net", "www.bank.tld", "casino.tld"} {
+		if err := rules.Check(host); err == nil {
+			t.Errorf("Check(%q) expected error", host)
+		}
+	}
+	for _, host := range []string{"localhost", "example.com", "bank.tld"} {
+		if err := rules.Check(host); err != nil {
+			t.Errorf("Check(%q) unexpected error = %v", host, err)
+		}
+	}
+}
+
+func TestDomainRules_ReloadOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	writeFile(t, path, "old.example.com\n")
+
+	rules, err := NewDomainRules(DomainDefaultAllow, nil, nil, nil, []string{path})
+	if err != nil {
+		t.Fatalf("NewDomainRules() error = %v", err)
+	}
+
+	changed, err := rules.Reload()
+	if err != nil || changed {
+		t.Fatalf("Reload() = %v, %v; want false, nil for an unchanged file", changed, err)
+	}
+
+	writeFile(t, path, "new.example.com\nanother.example.com\n")
+	// Make sure the modification time differs on coarse-grained filesystems
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchDone := make(chan struct{})
+	go func() {
+		rules.Watch(ctx, 10*time.Millisecond, testLogger())
+		close(watchDone)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for rules.Check("new.example.com") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("list file change was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := rules.Check("old.example.com"); err != nil {
+		t.Errorf("Check(old.example.com) error = %v after reload", err)
+	}
+
+	// A broken file keeps the previous rules
+	cancel()
+	<-watchDone
+	writeFile(t, path, "bad*pattern\n")
+	if _, err := rules.Reload(); err == nil {
+		t.Error("Reload() expected error for an invalid pattern")
+	}
+	if err := rules.Check("new.example.com"); err == nil {
+		t.Error("previous rules were dropped after a failed reload")
+	}
+}
+
+func TestAddressFilter_DomainRulesBeforeResolution(t *testing.T) {
+	af, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+	rules, err := NewDomainRules(DomainDefaultAllow, nil, []string{".bank.tld"}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewDomainRules() error = %v", err)
+	}
+	af.SetDomainRules(rules)
+
+	resolved := false
+	af.lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		resolved = true
+		return staticLookup("93.184.215.14")(ctx, host)
+	}
+
+	if err := af.IsAllowed("www.bank.tld:443"); err == nil {
+		t.Error("IsAllowed() expected error for denied domain")
+	}
+	if resolved {
+		t.Error("denied domain was resolved")
+	}
+
+	if err := af.IsAllowed("example.com:443"); err != nil {
+		t.Errorf("IsAllowed() unexpected error = %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -1,14 +1,26 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
 )
 
+// errNoAddresses is returned for host names that resolve to no addresses.
+var errNoAddresses = errors.New("hostname resolved to no addresses")
+
 // AddressFilter validates and filters target addresses to prevent network abuse.
 type AddressFilter struct {
 	allowPrivate bool
 	blockedNets  []*net.IPNet
+	domains      *DomainRules // Optional domain rules, checked before resolution
+	ports        *PortPolicy  // Optional destination port policy
+
+	lookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error) // Resolver used instead of the system resolver
 }
 
 // NewAddressFilter creates a new address filter with the specified configuration.
@@ -31,30 +43,129 @@ func NewAddressFilter(allowPrivate bool, blockedCIDRs []string) (*AddressFilter,
 	return af, nil
 }
 
+// SetResolver makes the filter resolve host names through r. The addresses
+// it returns are the ones checked and dialed.
+func (af *AddressFilter) SetResolver(r *Resolver) {
+	af.lookupIPAddr = r.LookupIPAddr
+}
+
+// SetPortPolicy enables checking destination ports against policy.
+func (af *AddressFilter) SetPortPolicy(policy *PortPolicy) {
+	af.ports = policy
+}
+
+// SetDomainRules enables domain rules, which are checked before the target
+// host is resolved.
+func (af *AddressFilter) SetDomainRules(rules *DomainRules) {
+	af.domains = rules
+}
+
 // IsAllowed checks if the given address is allowed to be connected to.
 // Returns an error if the address is blocked, nil if allowed.
 func (af *AddressFilter) IsAllowed(addr string) error {
+	_, err := af.Resolve(context.Background(), addr)
+	return err
+}
+
+// Decision is the outcome of checking a target address. Only the addresses
+// in Allowed may be dialed; Dialer.DialDecision never resolves the host again.
+type Decision struct {
+	Host    string   // Host as requested
+	Port    string   // Port as requested
+	Allowed []net.IP // Permitted addresses, in resolver order
+	Denied  []net.IP // Resolved addresses rejected by the filter
+	Reason  error    // Why the first denied address was rejected
+}
+
+// Resolve resolves addr and checks every resulting IP address. It returns an
+// error if the address is invalid, cannot be resolved, or none of its
+// addresses is permitted. Permitted addresses are returned even when other
+// addresses of the same host were denied, so the caller can dial only those.
+func (af *AddressFilter) Resolve(ctx context.Context, addr string) (*Decision, error) {
 	// Parse the address to extract host and port
-	host, _, err := net.SplitHostPort(addr)
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return fmt.Errorf("invalid address format: %w", err)
+		return nil, fmt.Errorf("invalid address format: %w", err)
 	}
 
-	// Parse the IP address
-	ip := net.ParseIP(host)
-	if ip == nil {
-		// If not an IP, try to resolve it
-		ips, err := net.LookupIP(host)
+	d := &Decision{Host: host, Port: port}
+
+	if af.ports != nil {
+		portNum, err := strconv.Atoi(port)
 		if err != nil {
-			return fmt.Errorf("failed to resolve hostname: %w", err)
+			return d, fmt.Errorf("invalid port %q: %w", port, err)
 		}
-		if len(ips) == 0 {
-			return fmt.Errorf("hostname resolved to no addresses")
+		if err := af.ports.Check(portNum); err != nil {
+			d.Reason = err
+			return d, err
 		}
-		// Use the first resolved IP
-		ip = ips[0]
 	}
 
+	if af.domains != nil {
+		if err := af.domains.Check(host); err != nil {
+			d.Reason = err
+			return d, err
+		}
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		// If not an IP, resolve it and vet every address
+		ipAddrs, err := af.lookup(ctx, host)
+		if err != nil {
+			return d, fmt.Errorf("failed to resolve hostname: %w", err)
+		}
+		if len(ipAddrs) == 0 {
+			return d, errNoAddresses
+		}
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if err := af.checkIP(ip); err != nil {
+			d.Denied = append(d.Denied, ip)
+			if d.Reason == nil {
+				d.Reason = err
+			}
+			continue
+		}
+		d.Allowed = append(d.Allowed, ip)
+	}
+
+	if len(d.Allowed) == 0 {
+		return d, d.Reason
+	}
+	return d, nil
+}
+
+// resolveStatus maps a failed Resolve to the CONNECT_RESP status reported
+// to the server. Only failures of the exit's own resolver count against it.
+func resolveStatus(d *Decision, err error) uint8 {
+	switch {
+	case d == nil || d.Reason != nil:
+		// Malformed target or denied by policy
+		return proto.ConnectDenied
+	case isNotFound(err) || errors.Is(err, errNoAddresses):
+		return proto.ConnectNoSuchHost
+	default:
+		return proto.ConnectUnreachable
+	}
+}
+
+// lookup resolves host, using the resolver set for tests if any.
+func (af *AddressFilter) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if af.lookupIPAddr != nil {
+		return af.lookupIPAddr(ctx, host)
+	}
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+// checkIP returns an error if connections to ip are not permitted.
+func (af *AddressFilter) checkIP(ip net.IP) error {
 	// Check loopback addresses
 	if af.isLoopback(ip) {
 		return fmt.Errorf("loopback addresses are not allowed")
@@ -75,6 +186,11 @@ func (af *AddressFilter) IsAllowed(addr string) error {
 		return fmt.Errorf("address is in a blocked network")
 	}
 
+	// Unspecified addresses connect to the local host
+	if ip.IsUnspecified() {
+		return fmt.Errorf("unspecified addresses are not allowed")
+	}
+
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"net"
 	"testing"
 )
@@ -456,3 +457,96 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+// staticLookup returns a resolver that answers every query with ips.
+func staticLookup(ips ...string) func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		addrs := make([]net.IPAddr, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return addrs, nil
+	}
+}
+
+func TestAddressFilter_ResolveChecksEveryAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		ips         []string
+		wantErr     bool
+		wantAllowed []string
+		wantDenied  []string
+	}{
+		{
+			name:        "public address only",
+			ips:         []string{"93.184.215.14"},
+			wantAllowed: []string{"93.184.215.14"},
+		},
+		{
+			name:        "loopback hidden behind public address",
+			ips:         []string{"93.184.215.14", "127.0.0.1"},
+			wantAllowed: []string{"93.184.215.14"},
+			wantDenied:  []string{"127.0.0.1"},
+		},
+		{
+			name:        "private address first",
+			ips:         []string{"10.0.0.5", "93.184.215.14"},
+			wantAllowed: []string{"93.184.215.14"},
+			wantDenied:  []string{"10.0.0.5"},
+		},
+		{
+			name:       "only blocked addresses",
+			ips:        []string{"127.0.0.1", "10.0.0.5", "::1"},
+			wantErr:    true,
+			wantDenied: []string{"127.0.0.1", "10.0.0.5", "::1"},
+		},
+		{
+			name:       "unspecified address",
+			ips:        []string{"0.0.0.0"},
+			wantErr:    true,
+			wantDenied: []string{"0.0.0.0"},
+		},
+		{
+			name:       "IPv4-mapped loopback",
+			ips:        []string{"::ffff:127.0.0.1"},
+			wantErr:    true,
+			wantDenied: []string{"127.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			af, err := NewAddressFilter(false, nil)
+			if err != nil {
+				t.Fatalf("NewAddressFilter() error = %v", err)
+			}
+			af.lookupIPAddr = staticLookup(tt.ips...)
+
+			d, err := af.Resolve(context.Background(), "rebind.example:443")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d.Host != "rebind.example" || d.Port != "443" {
+				t.Errorf("Resolve() host/port = %s/%s", d.Host, d.Port)
+			}
+			assertIPs(t, "Allowed", d.Allowed, tt.wantAllowed)
+			assertIPs(t, "Denied", d.Denied, tt.wantDenied)
+			if len(tt.wantDenied) > 0 && d.Reason == nil {
+				t.Error("Resolve() Reason is nil with denied addresses")
+			}
+		})
+	}
+}
+
+func assertIPs(t *testing.T, field string, got []net.IP, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s = %v, want %v", field, got, want)
+		return
+	}
+	for i := range want {
+		if !got[i].Equal(net.ParseIP(want[i])) {
+			t.Errorf("%s[%d] = %v, want %s", field, i, got[i], want[i])
+		}
+	}
+}
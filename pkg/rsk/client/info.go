@@ -0,0 +1,46 @@
+package client
+
+import (
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/version"
+)
+
+// clientInfo collects the labels and metadata announced to the server in
+// the HELLO. The public IP is the configured one, or else the first source
+// address that is a public IP.
+func (c *Config) clientInfo() *proto.ClientInfo {
+	hostname, _ := os.Hostname()
+	info := &proto.ClientInfo{
+		Version:  truncateInfo(version.GetVersion()),
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Hostname: truncateInfo(hostname),
+		PublicIP: c.PublicIP,
+		Labels:   c.Labels,
+	}
+	if info.PublicIP == "" {
+		for _, addr := range c.SourceAddrs {
+			if ip := net.ParseIP(addr); ip != nil && isPublicIP(ip) {
+				info.PublicIP = ip.String()
+				break
+			}
+		}
+	}
+	return info
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// truncateInfo cuts automatically collected values to the protocol limit.
+func truncateInfo(s string) string {
+	if len(s) > proto.MaxInfoFieldLen {
+		return s[:proto.MaxInfoFieldLen]
+	}
+	return s
+}
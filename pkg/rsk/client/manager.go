@@ -24,6 +24,7 @@ type ManagerOptions struct {
 type ManagerStatus struct {
 	Running      bool      // Whether the client is running
 	Port         int       // The port being used
+	Ports        []int     // All claimed ports, including those added with AddPort
 	Message      string    // Status message
 	StartTime    time.Time // When the client was started
 	RestartCount int       // Number of times restarted
@@ -41,6 +42,7 @@ type Manager struct {
 	cancel       context.CancelFunc
 	running      bool
 	port         int
+	client       *Client // Kept across restarts, so ports added at runtime stay claimed
 	status       string
 	logger       *slog.Logger
 	startTime    time.Time
@@ -126,6 +128,11 @@ func (m *Manager) Start(ctx context.Context, opts ManagerOptions) (int, error) {
 	m.restartCancel = restartCancel
 	m.running = true
 	m.port = port
+	m.client = &Client{
+		Config:         clientCfg,
+		ReconnectDelay: 2 * time.Second,
+		Logger:         m.logger,
+	}
 	m.startTime = time.Now()
 	m.status = fmt.Sprintf("Started on port %d", port)
 	m.mu.Unlock()
@@ -145,22 +152,16 @@ func (m *Manager) Start(ctx context.Context, opts ManagerOptions) (int, error) {
 
 	// Start client with optional auto-restart
 	if opts.AutoRestart {
-		go m.runWithAutoRestart(clientCfg)
+		go m.runWithAutoRestart(m.client)
 	} else {
-		go m.runOnce(clientCfg)
+		go m.runOnce(m.client)
 	}
 
 	return port, nil
 }
 
 // runOnce runs the client once without auto-restart
-func (m *Manager) runOnce(cfg *Config) {
-	rskClient := &Client{
-		Config:         cfg,
-		ReconnectDelay: 2 * time.Second,
-		Logger:         m.logger,
-	}
-
+func (m *Manager) runOnce(rskClient *Client) {
 	err := rskClient.Run(m.ctx)
 	if err != nil && !errors.Is(err, context.Canceled) {
 		m.logger.Error("Client stopped with error", "error", err)
@@ -176,7 +177,7 @@ func (m *Manager) runOnce(cfg *Config) {
 }
 
 // runWithAutoRestart runs the client with automatic restart on failure
-func (m *Manager) runWithAutoRestart(cfg *Config) {
+func (m *Manager) runWithAutoRestart(rskClient *Client) {
 	attempt := 0
 
 	for {
@@ -219,12 +220,6 @@ func (m *Manager) runWithAutoRestart(cfg *Config) {
 		}
 
 		// Run client
-		rskClient := &Client{
-			Config:         cfg,
-			ReconnectDelay: 2 * time.Second,
-			Logger:         m.logger,
-		}
-
 		err := rskClient.Run(ctx)
 
 		// Check if it was a graceful shutdown
@@ -331,6 +326,50 @@ func (m *Manager) StopAndWait(timeout time.Duration) error {
 	}
 }
 
+// AddPort asks the server to bind one more port for the running client,
+// without reconnecting. It fails while the client is not connected.
+func (m *Manager) AddPort(ctx context.Context, port int) error {
+	client, err := m.runningClient()
+	if err != nil {
+		return err
+	}
+	return client.AddPort(ctx, port)
+}
+
+// RemovePort asks the server to release a port of the running client.
+// Connections already open on the port continue.
+func (m *Manager) RemovePort(ctx context.Context, port int) error {
+	client, err := m.runningClient()
+	if err != nil {
+		return err
+	}
+	return client.RemovePort(ctx, port)
+}
+
+func (m *Manager) runningClient() (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.running || m.client == nil {
+		return nil, errors.New("client is not running")
+	}
+	return m.client, nil
+}
+
+// GetPorts returns all ports claimed by the client, including those added
+// with AddPort. Returns nil if the client was never started.
+func (m *Manager) GetPorts() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.portsLocked()
+}
+
+func (m *Manager) portsLocked() []int {
+	if m.client == nil {
+		return nil
+	}
+	return m.client.Ports()
+}
+
 // GetStatus returns the current status of the RSK client.
 func (m *Manager) GetStatus() ManagerStatus {
 	m.mu.Lock()
@@ -338,6 +377,7 @@ func (m *Manager) GetStatus() ManagerStatus {
 	return ManagerStatus{
 		Running:      m.running,
 		Port:         m.port,
+		Ports:        m.portsLocked(),
 		Message:      m.status,
 		StartTime:    m.startTime,
 		RestartCount: m.restartCount,
@@ -112,6 +112,19 @@ func TestManagerGetters(t *testing.T) {
 	if err := manager.GetLastError(); err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
+
+	// Test GetPorts
+	if ports := manager.GetPorts(); ports != nil {
+		t.Errorf("Expected no ports, got %v", ports)
+	}
+
+	// Ports cannot change while not running
+	if err := manager.AddPort(context.Background(), 20002); err == nil {
+		t.Error("Expected AddPort to fail while not running")
+	}
+	if err := manager.RemovePort(context.Background(), 20001); err == nil {
+		t.Error("Expected RemovePort to fail while not running")
+	}
 }
 
 func TestManagerValidation(t *testing.T) {
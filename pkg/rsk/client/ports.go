@@ -0,0 +1,107 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AbusePorts lists destination ports commonly abused through open proxies:
+// remote shells (SSH, Telnet, RDP, VNC), mail submission (spam), Windows
+// file sharing and RPC, and IRC botnets. Operators opt into blocking them.
+var AbusePorts = []string{
+	"22",        // SSH
+	"23",        // Telnet
+	"25",        // SMTP
+	"135-139",   // MS RPC, NetBIOS
+	"445",       // SMB
+	"465",       // SMTPS
+	"587",       // SMTP submission
+	"2525",      // Alternate SMTP
+	"3389",      // RDP
+	"5900",      // VNC
+	"6660-6669", // IRC
+}
+
+// PortRange is an inclusive range of TCP ports.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// Contains reports whether port lies within the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// ParsePortRanges parses entries such as "443" or "8000-8999".
+func ParsePortRanges(entries []string) ([]PortRange, error) {
+	ranges := make([]PortRange, 0, len(entries))
+	for _, entry := range entries {
+		lo, hi, found := strings.Cut(strings.TrimSpace(entry), "-")
+		if !found {
+			hi = lo
+		}
+
+		portMin, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", entry, err)
+		}
+		portMax, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", entry, err)
+		}
+		if portMin < 1 || portMax > 65535 || portMin > portMax {
+			return nil, fmt.Errorf("invalid port range %q: ports must be 1-65535 and min <= max", entry)
+		}
+
+		ranges = append(ranges, PortRange{Min: portMin, Max: portMax})
+	}
+	return ranges, nil
+}
+
+// PortPolicy decides which destination ports may be connected to. A denied
+// port is always rejected; if any allowed ports are configured, every other
+// port is rejected as well.
+type PortPolicy struct {
+	allow []PortRange
+	deny  []PortRange
+}
+
+// NewPortPolicy creates a port policy from allowed and denied port entries.
+// With blockAbuse set, AbusePorts are added to the denied ports.
+func NewPortPolicy(allow, deny []string, blockAbuse bool) (*PortPolicy, error) {
+	allowRanges, err := ParsePortRanges(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockAbuse {
+		deny = append(append([]string(nil), deny...), AbusePorts...)
+	}
+	denyRanges, err := ParsePortRanges(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortPolicy{allow: allowRanges, deny: denyRanges}, nil
+}
+
+// Check returns an error if connections to port are not permitted.
+func (p *PortPolicy) Check(port int) error {
+	for _, r := range p.deny {
+		if r.Contains(port) {
+			return fmt.Errorf("destination port %d is denied", port)
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.Contains(port) {
+			return nil
+		}
+	}
+	return fmt.Errorf("destination port %d is not in the allowed ports", port)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []PortRange
+		wantErr bool
+	}{
+		{name: "single port", entries: []string{"443"}, want: []PortRange{{443, 443}}},
+		{name: "range", entries: []string{"8000-8999"}, want: []PortRange{{8000, 8999}}},
+		{name: "mixed with spaces", entries: []string{" 80 ", "6660 - 6669"}, want: []PortRange{{80, 80}, {6660, 6669}}},
+		{name: "not a number", entries: []string{"http"}, wantErr: true},
+		{name: "zero", entries: []string{"0"}, wantErr: true},
+		{name: "too large", entries: []string{"65536"}, wantErr: true},
+		{name: "reversed", entries: []string{"100-10"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePortRanges(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParsePortRanges() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ParsePortRanges()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPortPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      []string
+		deny       []string
+		blockAbuse bool
+		port       int
+		wantErr    bool
+	}{
+		{name: "empty policy allows all", port: 25},
+		{name: "denied port", deny: []string{"25"}, port: 25, wantErr: true},
+		{name: "denied range", deny: []string{"6660-6669"}, port: 6667, wantErr: true},
+		{name: "allow list admits listed", allow: []string{"80", "443"}, port: 443},
+		{name: "allow list rejects others", allow: []string{"80", "443"}, port: 22, wantErr: true},
+		{name: "deny wins over allow", allow: []string{"1-65535"}, deny: []string{"22"}, port: 22, wantErr: true},
+		{name: "abuse preset blocks SMTP", blockAbuse: true, port: 25, wantErr: true},
+		{name: "abuse preset blocks SMB", blockAbuse: true, port: 445, wantErr: true},
+		{name: "abuse preset leaves HTTPS", blockAbuse: true, port: 443},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPortPolicy(tt.allow, tt.deny, tt.blockAbuse)
+			if err != nil {
+				t.Fatalf("NewPortPolicy() error = %v", err)
+			}
+			if err := policy.Check(tt.port); (err != nil) != tt.wantErr {
+				t.Errorf("Check(%d) error = %v, wantErr %v", tt.port, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddressFilter_PortPolicy(t *testing.T) {
+	af, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+	policy, err := NewPortPolicy(nil, nil, true)
+	if err != nil {
+		t.Fatalf("NewPortPolicy() error = %v", err)
+	}
+	af.SetPortPolicy(policy)
+
+	err = af.IsAllowed("8.8.8.8:25")
+	if err == nil || !strings.Contains(err.Error(), "port 25") {
+		t.Errorf("IsAllowed(8.8.8.8:25) error = %v, want port denial", err)
+	}
+	if err := af.IsAllowed("8.8.8.8:443"); err != nil {
+		t.Errorf("IsAllowed(8.8.8.8:443) unexpected error = %v", err)
+	}
+}
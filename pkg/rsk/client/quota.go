@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/common"
+	"golang.org/x/time/rate"
+)
+
+// Quota periods. The quota covers a window of that length ending now, so
+// bytes stop counting once they are that old instead of at a calendar reset.
+const (
+	QuotaDaily   = "daily"   // The last 24 hours, in hourly steps
+	QuotaMonthly = "monthly" // The last 30 days, in daily steps
+)
+
+// quotaSaveInterval is how often quota usage is written to the state file.
+const quotaSaveInterval = 10 * time.Second
+
+// ErrQuotaExhausted is returned once the data quota of the window is used up.
+var ErrQuotaExhausted = errors.New("data quota exhausted")
+
+// Quota tracks the bytes relayed in a rolling window against a limit. Usage
+// is kept in buckets, each counting until a whole window after it ended, and
+// persisted to a state file so restarts do not reset it.
+type Quota struct {
+	limit  int64
+	window time.Duration
+	bucket time.Duration // Granularity at which bytes leave the window
+	path   string
+
+	mu      sync.Mutex
+	buckets []quotaBucket // Oldest first
+	used    int64         // Sum of the buckets
+	dirty   bool
+
+	now func() time.Time // Replaced in tests
+}
+
+// quotaBucket holds the bytes relayed from Start for one bucket length.
+type quotaBucket struct {
+	Start time.Time `json:"start"`
+	Bytes int64     `json:"bytes"`
+}
+
+// quotaState is the JSON content of the state file.
+type quotaState struct {
+	Buckets []quotaBucket `json:"buckets"`
+}
+
+// NewQuota creates a quota of limit bytes per rolling period, loading
+// earlier usage from statePath if it exists. An empty statePath keeps usage
+// in memory only.
+func NewQuota(limit int64, period, statePath string) (*Quota, error) {
+	q := &Quota{limit: limit, path: statePath, now: time.Now}
+	switch period {
+	case QuotaDaily:
+		q.window, q.bucket = 24*time.Hour, time.Hour
+	case QuotaMonthly:
+		q.window, q.bucket = 30*24*time.Hour, 24*time.Hour
+	default:
+		return nil, fmt.Errorf("invalid quota period %q: expected %s or %s", period, QuotaDaily, QuotaMonthly)
+	}
+
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read quota state: %w", err)
+		default:
+			var state quotaState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("failed to parse quota state %s: %w", statePath, err)
+			}
+			q.buckets = state.Buckets
+			for _, b := range q.buckets {
+				q.used += b.Bytes
+			}
+			q.expireLocked()
+		}
+	}
+	return q, nil
+}
+
+// expireLocked drops the buckets that have left the window.
+func (q *Quota) expireLocked() {
+	cutoff := q.now().Add(-q.window)
+	n := 0
+	for n < len(q.buckets) && !q.buckets[n].Start.Add(q.bucket).After(cutoff) {
+		q.used -= q.buckets[n].Bytes
+		n++
+	}
+	if n > 0 {
+		q.buckets = q.buckets[n:]
+		q.dirty = true
+	}
+}
+
+// Check returns ErrQuotaExhausted if no data is left in the window.
+func (q *Quota) Check() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expireLocked()
+	if q.used >= q.limit {
+		return ErrQuotaExhausted
+	}
+	return nil
+}
+
+// Add charges n relayed bytes to the window.
+func (q *Quota) Add(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expireLocked()
+	start := q.now().Truncate(q.bucket)
+	if last := len(q.buckets) - 1; last >= 0 && q.buckets[last].Start.Equal(start) {
+		q.buckets[last].Bytes += n
+	} else {
+		q.buckets = append(q.buckets, quotaBucket{Start: start, Bytes: n})
+	}
+	q.used += n
+	q.dirty = true
+}
+
+// Usage returns the bytes used in the window and the limit.
+func (q *Quota) Usage() (used, limit int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expireLocked()
+	return q.used, q.limit
+}
+
+// Save writes the usage to the state file if it changed since the last save.
+func (q *Quota) Save() error {
+	q.mu.Lock()
+	if q.path == "" || !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(quotaState{Buckets: q.buckets})
+	q.dirty = false
+	q.mu.Unlock()
+	if err == nil {
+		err = common.WriteFileAtomic(q.path, data)
+	}
+	if err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+		return fmt.Errorf("failed to write quota state: %w", err)
+	}
+	return nil
+}
+
+// Run saves the usage every interval and once more when ctx is canceled.
+func (q *Quota) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := q.Save(); err != nil {
+				logger.Error("Failed to save quota state", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := q.Save(); err != nil {
+				logger.Warn("Failed to save quota state", "error", err)
+			}
+		}
+	}
+}
+
+// Meter enforces the client-wide rate limits and data quota on relayed
+// streams. Every field is optional.
+type Meter struct {
+	up    *rate.Limiter // Consumer to target
+	down  *rate.Limiter // Target to consumer
+	quota *Quota
+}
+
+// NewMeter creates a meter limiting each direction to the given bytes per
+// second (0 is unlimited) and charging all traffic to quota, if not nil.
+func NewMeter(upBytesPerSec, downBytesPerSec int64, quota *Quota) *Meter {
+	return &Meter{
+		up:    newRateLimiter(upBytesPerSec),
+		down:  newRateLimiter(downBytesPerSec),
+		quota: quota,
+	}
+}
+
+// newRateLimiter allows bytesPerSec with a burst of one second of traffic,
+// or returns nil for an unlimited direction.
+func newRateLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+// Check returns ErrQuotaExhausted if new streams must be refused.
+func (m *Meter) Check() error {
+	if m.quota == nil {
+		return nil
+	}
+	return m.quota.Check()
+}
+
+// Upload and Download wrap r, reading consumer and target data respectively.
+// Reads block while the rate limit is exceeded and fail with
+// ErrQuotaExhausted once the quota is used up, until ctx is canceled.
+func (m *Meter) Upload(ctx context.Context, r io.Reader) io.Reader {
+	return &meteredReader{ctx: ctx, r: r, limiter: m.up, quota: m.quota}
+}
+
+func (m *Meter) Download(ctx context.Context, r io.Reader) io.Reader {
+	return &meteredReader{ctx: ctx, r: r, limiter: m.down, quota: m.quota}
+}
+
+type meteredReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+	quota   *Quota
+}
+
+func (mr *meteredReader) Read(b []byte) (int, error) {
+	if mr.quota != nil {
+		if err := mr.quota.Check(); err != nil {
+			return 0, err
+		}
+	}
+	if mr.limiter != nil && len(b) > mr.limiter.Burst() {
+		b = b[:mr.limiter.Burst()]
+	}
+
+	n, err := mr.r.Read(b)
+	if n > 0 {
+		if mr.quota != nil {
+			mr.quota.Add(int64(n))
+		}
+		if mr.limiter != nil {
+			if waitErr := mr.limiter.WaitN(mr.ctx, n); waitErr != nil && err == nil {
+				err = waitErr
+			}
+		}
+	}
+	return n, err
+}
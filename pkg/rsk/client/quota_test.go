@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota_RollingWindow(t *testing.T) {
+	tests := []struct {
+		period string
+		step   time.Duration // Bucket length
+		window time.Duration
+	}{
+		{period: QuotaDaily, step: time.Hour, window: 24 * time.Hour},
+		{period: QuotaMonthly, step: 24 * time.Hour, window: 30 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			q, err := NewQuota(1000, tt.period, "")
+			if err != nil {
+				t.Fatalf("NewQuota() error = %v", err)
+			}
+			now := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+			q.now = func() time.Time { return now }
+
+			q.Add(600)
+			now = now.Add(tt.step)
+			q.Add(400)
+			if err := q.Check(); !errors.Is(err, ErrQuotaExhausted) {
+				t.Fatalf("Check() error = %v, want ErrQuotaExhausted", err)
+			}
+
+			// There is no calendar reset; bytes count for a whole window
+			// after the end of their bucket
+			now = now.Add(tt.window - time.Second)
+			if err := q.Check(); !errors.Is(err, ErrQuotaExhausted) {
+				t.Errorf("Check() error = %v before the first bytes aged out, want ErrQuotaExhausted", err)
+			}
+
+			// Only the oldest bytes leave the window
+			now = now.Add(time.Second)
+			if err := q.Check(); err != nil {
+				t.Errorf("Check() error = %v after the first bytes aged out, want nil", err)
+			}
+			if used, _ := q.Usage(); used != 400 {
+				t.Errorf("Usage() used = %d, want 400", used)
+			}
+
+			now = now.Add(tt.step)
+			if used, _ := q.Usage(); used != 0 {
+				t.Errorf("Usage() used = %d once all bytes aged out, want 0", used)
+			}
+		})
+	}
+}
+
+func TestQuota_PersistsUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	q, err := NewQuota(1000, QuotaMonthly, path)
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	q.Add(600)
+	if err := q.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	restored, err := NewQuota(1000, QuotaMonthly, path)
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	if used, _ := restored.Usage(); used != 600 {
+		t.Errorf("restored usage = %d, want 600", used)
+	}
+
+	// Usage saved longer ago than the window does not carry over
+	old := []byte(`{"buckets":[{"start":"2001-01-01T00:00:00Z","bytes":999}]}`)
+	if err := os.WriteFile(path, old, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	fresh, err := NewQuota(1000, QuotaMonthly, path)
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	if used, _ := fresh.Usage(); used != 0 {
+		t.Errorf("usage from outside the window = %d, want 0", used)
+	}
+}
+
+func TestQuota_InvalidState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := NewQuota(1000, QuotaDaily, path); err == nil {
+		t.Error("NewQuota() expected error for a corrupt state file")
+	}
+	if _, err := NewQuota(1000, "weekly", ""); err == nil {
+		t.Error("NewQuota() expected error for an invalid period")
+	}
+}
+
+func TestMeter_StopsAtQuota(t *testing.T) {
+	q, err := NewQuota(100*1024, QuotaDaily, "")
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	meter := NewMeter(0, 0, q)
+
+	n, err := io.Copy(io.Discard, meter.Download(context.Background(), bytes.NewReader(make([]byte, 1<<20))))
+	if !errors.Is(err, ErrQuotaExhausted) {
+		t.Fatalf("Copy() error = %v, want ErrQuotaExhausted", err)
+	}
+	// Reads stop at the first check after the quota is used up
+	if n < 100*1024 || n > 100*1024+32*1024 {
+		t.Errorf("copied %d bytes, want about the 100 KiB quota", n)
+	}
+	if err := meter.Check(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("Check() error = %v, want ErrQuotaExhausted", err)
+	}
+}
+
+func TestMeter_RateLimit(t *testing.T) {
+	meter := NewMeter(64*1024, 0, nil)
+
+	// The first 64 KiB pass as burst, the next 32 KiB take half a second
+	start := time.Now()
+	n, err := io.Copy(io.Discard, meter.Upload(context.Background(), bytes.NewReader(make([]byte, 96*1024))))
+	if err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if n != 96*1024 {
+		t.Errorf("copied %d bytes, want %d", n, 96*1024)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("copy took %v, want at least 400ms", elapsed)
+	}
+
+	// Unlimited directions pass straight through
+	start = time.Now()
+	if _, err := io.Copy(io.Discard, meter.Download(context.Background(), bytes.NewReader(make([]byte, 1<<20)))); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("unlimited copy took %v", elapsed)
+	}
+}
+
+func TestHandleStream_RefusesWhenQuotaExhausted(t *testing.T) {
+	filter, err := NewAddressFilter(false, nil)
+	if err != nil {
+		t.Fatalf("NewAddressFilter() error = %v", err)
+	}
+	q, err := NewQuota(10, QuotaDaily, "")
+	if err != nil {
+		t.Fatalf("NewQuota() error = %v", err)
+	}
+	q.Add(10)
+
+	entry := runAuditedStream(t, "192.0.2.1:443", filter, NewMeter(0, 0, q))
+	if entry.Decision != DecisionDeny || entry.Reason != ErrQuotaExhausted.Error() {
+		t.Errorf("audit entry = %+v, want deny with %q", entry, ErrQuotaExhausted)
+	}
+}
@@ -0,0 +1,581 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+// IP family preferences for resolved addresses.
+const (
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+)
+
+const (
+	defaultNegativeTTL  = 30 * time.Second
+	defaultMaxTTL       = time.Hour
+	systemResolverTTL   = 30 * time.Second // Cache lifetime for answers without TTLs
+	maxResolverCache    = 10000
+	maxDNSMessageSize   = 65535
+	dnsQueryTimeout     = 5 * time.Second
+	dohContentType      = "application/dns-message"
+	defaultDNSPort      = "53"
+	resolverSchemeUDP   = "udp"
+	resolverSchemeTCP   = "tcp"
+	resolverSchemeHTTPS = "https"
+)
+
+// errNoSuchHost is returned for names the upstream reports as nonexistent
+// or without addresses.
+var errNoSuchHost = errors.New("no such host")
+
+// ResolverConfig configures a Resolver.
+type ResolverConfig struct {
+	// Upstream servers, tried in order: "1.1.1.1", "udp://1.1.1.1:53",
+	// "tcp://[2606:4700::1111]:53" or "https://dns.google/dns-query".
+	// Empty uses the system resolver.
+	Servers     []string
+	Prefer      string        // PreferIPv4, PreferIPv6 or empty to keep the upstream order
+	MaxTTL      time.Duration // Upper bound for cached answers (default 1h)
+	NegativeTTL time.Duration // Cache lifetime for failed lookups without an SOA TTL (default 30s)
+}
+
+// dnsServer is one parsed upstream.
+type dnsServer struct {
+	scheme string // resolverSchemeUDP, resolverSchemeTCP or resolverSchemeHTTPS
+	addr   string // host:port for UDP/TCP, URL for DoH
+}
+
+// Resolver resolves host names through configurable upstream servers and
+// caches the answers, honoring their TTLs. Names that do not exist are cached
+// as well; transient failures are not.
+type Resolver struct {
+	servers     []dnsServer
+	prefer      string
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+
+	httpClient *http.Client
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	system     func(ctx context.Context, host string) ([]net.IPAddr, error)
+	now        func() time.Time
+
+	group singleflight.Group
+	mu    sync.Mutex
+	cache map[string]resolverEntry
+}
+
+type resolverEntry struct {
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// NewResolver creates a resolver from cfg.
+func NewResolver(cfg ResolverConfig) (*Resolver, error) {
+	switch cfg.Prefer {
+	case "", PreferIPv4, PreferIPv6:
+	default:
+		return nil, fmt.Errorf("invalid IP preference %q: expected %s or %s", cfg.Prefer, PreferIPv4, PreferIPv6)
+	}
+
+	servers := make([]dnsServer, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		server, err := parseDNSServer(s)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	r := &Resolver{
+		servers:     servers,
+		prefer:      cfg.Prefer,
+		maxTTL:      cfg.MaxTTL,
+		negativeTTL: cfg.NegativeTTL,
+		httpClient:  &http.Client{Timeout: dnsQueryTimeout},
+		system:      net.DefaultResolver.LookupIPAddr,
+		now:         time.Now,
+		cache:       make(map[string]resolverEntry),
+	}
+	if r.maxTTL <= 0 {
+		r.maxTTL = defaultMaxTTL
+	}
+	if r.negativeTTL <= 0 {
+		r.negativeTTL = defaultNegativeTTL
+	}
+	var d net.Dialer
+	r.dial = d.DialContext
+	return r, nil
+}
+
+// ValidateDNSServer checks the syntax of an upstream server entry.
+func ValidateDNSServer(s string) error {
+	_, err := parseDNSServer(s)
+	return err
+}
+
+func parseDNSServer(s string) (dnsServer, error) {
+	if !strings.Contains(s, "://") {
+		s = resolverSchemeUDP + "://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return dnsServer{}, fmt.Errorf("invalid DNS server %q: %w", s, err)
+	}
+	if u.Hostname() == "" {
+		return dnsServer{}, fmt.Errorf("invalid DNS server %q: missing host", s)
+	}
+
+	switch u.Scheme {
+	case resolverSchemeUDP, resolverSchemeTCP:
+		port := u.Port()
+		if port == "" {
+			port = defaultDNSPort
+		}
+		return dnsServer{scheme: u.Scheme, addr: net.JoinHostPort(u.Hostname(), port)}, nil
+	case resolverSchemeHTTPS:
+		return dnsServer{scheme: u.Scheme, addr: u.String()}, nil
+	}
+	return dnsServer{}, fmt.Errorf("invalid DNS server %q: scheme must be udp, tcp or https", s)
+}
+
+// LookupIPAddr resolves host to its IP addresses, ordered by the configured
+// family preference. Answers are served from the cache while still valid;
+// concurrent lookups of the same host share one upstream query.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	key := normalizeDomain(host)
+	if entry, ok := r.cached(key); ok {
+		return entry.addrs, entry.err
+	}
+
+	ch := r.group.DoChan(key, func() (any, error) {
+		// The lookup is shared, so it must not end when its first caller
+		// gives up; each upstream gets one query timeout
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dnsQueryTimeout*time.Duration(max(len(r.servers), 1)))
+		defer cancel()
+
+		addrs, ttl, err := r.resolve(ctx, key)
+		if err != nil {
+			// Only remember names that do not exist, not transient failures
+			if !isNotFound(err) {
+				return resolverEntry{err: err}, nil
+			}
+			if ttl <= 0 {
+				ttl = r.negativeTTL
+			}
+		}
+		if ttl > r.maxTTL {
+			ttl = r.maxTTL
+		}
+		entry := resolverEntry{addrs: r.order(addrs), err: err, expires: r.now().Add(ttl)}
+		if ttl > 0 {
+			r.store(key, entry)
+		}
+		return entry, nil
+	})
+
+	select {
+	case res := <-ch:
+		entry := res.Val.(resolverEntry)
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return entry.addrs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isNotFound reports whether err is an authoritative "no such host" answer.
+func isNotFound(err error) bool {
+	if errors.Is(err, errNoSuchHost) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func (r *Resolver) cached(key string) (resolverEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok {
+		return resolverEntry{}, false
+	}
+	if !r.now().Before(entry.expires) {
+		delete(r.cache, key)
+		return resolverEntry{}, false
+	}
+	return entry, true
+}
+
+func (r *Resolver) store(key string, entry resolverEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache) >= maxResolverCache {
+		now := r.now()
+		for k, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		// Still full: drop arbitrary entries to make room
+		for k := range r.cache {
+			if len(r.cache) < maxResolverCache {
+				break
+			}
+			delete(r.cache, k)
+		}
+	}
+	r.cache[key] = entry
+}
+
+// order sorts addrs by the preferred family, keeping the upstream order within a family.
+func (r *Resolver) order(addrs []net.IPAddr) []net.IPAddr {
+	if r.prefer == "" || len(addrs) < 2 {
+		return addrs
+	}
+
+	wantV4 := r.prefer == PreferIPv4
+	ordered := make([]net.IPAddr, 0, len(addrs))
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) == wantV4 {
+			ordered = append(ordered, a)
+		}
+	}
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) != wantV4 {
+			ordered = append(ordered, a)
+		}
+	}
+	return ordered
+}
+
+// resolve queries the upstreams and returns the addresses with the TTL to cache them for.
+func (r *Resolver) resolve(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	if len(r.servers) == 0 {
+		addrs, err := r.system(ctx, host)
+		return addrs, systemResolverTTL, err
+	}
+
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid host name %q: %w", host, err)
+	}
+
+	var lastErr error
+	for _, server := range r.servers {
+		addrs, ttl, err := r.queryServer(ctx, server, name)
+		if err == nil || errors.Is(err, errNoSuchHost) {
+			return addrs, ttl, err
+		}
+		lastErr = fmt.Errorf("DNS server %s: %w", server.addr, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// queryServer asks one upstream for the A and AAAA records of name.
+func (r *Resolver) queryServer(ctx context.Context, server dnsServer, name dnsmessage.Name) ([]net.IPAddr, time.Duration, error) {
+	type result struct {
+		addrs []net.IPAddr
+		ttl   time.Duration
+		err   error
+	}
+
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]result, len(types))
+	var wg sync.WaitGroup
+	for i, qtype := range types {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, ttl, err := r.query(ctx, server, name, qtype)
+			results[i] = result{addrs, ttl, err}
+		}()
+	}
+	wg.Wait()
+
+	var addrs []net.IPAddr
+	var ttl time.Duration
+	seen := false // Whether ttl holds the TTL of an answer yet
+	var failure error
+	for _, res := range results {
+		if res.err != nil {
+			if !errors.Is(res.err, errNoSuchHost) {
+				failure = res.err
+			}
+			continue
+		}
+		addrs = append(addrs, res.addrs...)
+		if !seen || res.ttl < ttl {
+			ttl = res.ttl
+			seen = true
+		}
+	}
+
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	if failure != nil {
+		return nil, 0, failure
+	}
+	// Both queries answered without addresses: a negative answer
+	negTTL := results[0].ttl
+	if results[1].ttl > 0 && (negTTL == 0 || results[1].ttl < negTTL) {
+		negTTL = results[1].ttl
+	}
+	return nil, negTTL, fmt.Errorf("lookup %s: %w", strings.TrimSuffix(name.String(), "."), errNoSuchHost)
+}
+
+// query sends a single question and parses the answer. For negative answers
+// it returns errNoSuchHost with the SOA-derived negative TTL, if any.
+func (r *Resolver) query(ctx context.Context, server dnsServer, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, time.Duration, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	question := dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	// DoH recommends ID 0 for cacheability
+	if server.scheme == resolverSchemeHTTPS {
+		msg.Header.ID = 0
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+
+	var raw []byte
+	switch server.scheme {
+	case resolverSchemeUDP:
+		raw, err = r.exchangeUDP(ctx, server.addr, packed)
+		if err == nil && isTruncated(raw) {
+			raw, err = r.exchangeTCP(ctx, server.addr, packed)
+		}
+	case resolverSchemeTCP:
+		raw, err = r.exchangeTCP(ctx, server.addr, packed)
+	case resolverSchemeHTTPS:
+		raw, err = r.exchangeHTTPS(ctx, server.addr, packed)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parseDNSResponse(raw, msg.Header.ID, question)
+}
+
+func isTruncated(raw []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(raw)
+	return err == nil && h.Truncated
+}
+
+func (r *Resolver) exchangeUDP(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	conn, err := r.dial(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	// Stray or spoofed datagrams with another ID are skipped until the
+	// answer arrives or the deadline passes
+	buf := make([]byte, maxDNSMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && bytes.Equal(buf[:2], query[:2]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (r *Resolver) exchangeTCP(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	conn, err := r.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, length)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// exchangeHTTPS performs a DNS-over-HTTPS (RFC 8484) POST request.
+func (r *Resolver) exchangeHTTPS(ctx context.Context, endpoint string, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+}
+
+// parseDNSResponse extracts A/AAAA answers for question from raw. The TTL is
+// the smallest of the answer records, or the SOA negative TTL for empty answers.
+func parseDNSResponse(raw []byte, id uint16, question dnsmessage.Question) ([]net.IPAddr, time.Duration, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if h.ID != id || !h.Response {
+		return nil, 0, fmt.Errorf("mismatched DNS response")
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if q.Type != question.Type || !strings.EqualFold(q.Name.String(), question.Name.String()) {
+		return nil, 0, fmt.Errorf("DNS response for a different question")
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, fmt.Errorf("invalid DNS response: %w", err)
+	}
+
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, 0, fmt.Errorf("DNS server returned %s", h.RCode)
+	}
+
+	var addrs []net.IPAddr
+	var ttl uint32
+	seen := false // Whether ttl holds the TTL of an answer yet
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid DNS response: %w", err)
+		}
+
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			rr, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, net.IPAddr{IP: net.IP(rr.A[:])})
+		case dnsmessage.TypeAAAA:
+			rr, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, net.IPAddr{IP: net.IP(rr.AAAA[:])})
+		default:
+			// CNAMEs and others: the recursive server already followed them
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if !seen || rh.TTL < ttl {
+			ttl = rh.TTL
+			seen = true
+		}
+	}
+
+	if len(addrs) > 0 {
+		return addrs, time.Duration(ttl) * time.Second, nil
+	}
+
+	// Negative answer: cache for min(SOA TTL, SOA MINIMUM) per RFC 2308
+	var negTTL time.Duration
+	if err := p.SkipAllAnswers(); err == nil {
+		for {
+			rh, err := p.AuthorityHeader()
+			if err != nil {
+				break
+			}
+			if rh.Type != dnsmessage.TypeSOA {
+				if err := p.SkipAuthority(); err != nil {
+					break
+				}
+				continue
+			}
+			soa, err := p.SOAResource()
+			if err != nil {
+				break
+			}
+			negTTL = time.Duration(min(rh.TTL, soa.MinTTL)) * time.Second
+			break
+		}
+	}
+	return nil, negTTL, errNoSuchHost
+}
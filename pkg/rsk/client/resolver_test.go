@@ -0,0 +1,457 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsAnswer describes how the fake DNS server answers one question.
+type dnsAnswer struct {
+	rcode     dnsmessage.RCode
+	ips       []string
+	ttl       uint32
+	ttlAAAA   *uint32 // TTL of AAAA records if different from ttl
+	soaMinTTL uint32  // Adds an SOA authority record for negative answers if set
+	truncated bool    // Set TC over UDP, forcing a TCP retry
+}
+
+// fakeDNS answers queries from a fixed table and counts them.
+type fakeDNS struct {
+	answers  map[string]dnsAnswer // Keyed by name; missing names get NXDOMAIN
+	queries  atomic.Int32
+	strayUDP bool // Precede each UDP answer with one carrying another ID
+}
+
+func (f *fakeDNS) respond(t *testing.T, query []byte, overUDP bool) []byte {
+	t.Helper()
+	f.queries.Add(1)
+
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		t.Errorf("bad query: %v", err)
+		return nil
+	}
+	q := req.Questions[0]
+
+	answer, ok := f.answers[q.Name.String()]
+	if !ok {
+		answer = dnsAnswer{rcode: dnsmessage.RCodeNameError, soaMinTTL: 60}
+	}
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, RCode: answer.rcode},
+		Questions: req.Questions,
+	}
+	if overUDP && answer.truncated {
+		resp.Truncated = true
+		b, _ := resp.Pack()
+		return b
+	}
+
+	for _, s := range answer.ips {
+		ip := net.ParseIP(s)
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: answer.ttl}
+		if answer.ttlAAAA != nil && q.Type == dnsmessage.TypeAAAA {
+			hdr.TTL = *answer.ttlAAAA
+		}
+		if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		} else if ip.To4() == nil && q.Type == dnsmessage.TypeAAAA {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}})
+		}
+	}
+	if len(resp.Answers) == 0 && answer.soaMinTTL > 0 {
+		zone := dnsmessage.MustNewName("example.")
+		resp.Authorities = append(resp.Authorities, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: zone, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body: &dnsmessage.SOAResource{
+				NS: zone, MBox: zone, Serial: 1, Refresh: 1, Retry: 1, Expire: 1, MinTTL: answer.soaMinTTL,
+			},
+		})
+	}
+
+	b, err := resp.Pack()
+	if err != nil {
+		t.Errorf("failed to pack response: %v", err)
+	}
+	return b
+}
+
+// serveUDP and serveTCP start the fake server on loopback and return its address.
+func (f *fakeDNS) serveUDP(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := f.respond(t, buf[:n], true)
+			if f.strayUDP && len(resp) >= 2 {
+				stray := append([]byte(nil), resp...)
+				stray[0] ^= 0xff
+				_, _ = pc.WriteTo(stray, addr)
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func (f *fakeDNS) serveTCP(t *testing.T, addr string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				query := make([]byte, length)
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := f.respond(t, query, false)
+				_ = binary.Write(conn, binary.BigEndian, uint16(len(resp)))
+				_, _ = conn.Write(resp)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newTestResolver(t *testing.T, cfg ResolverConfig) *Resolver {
+	t.Helper()
+	r, err := NewResolver(cfg)
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	return r
+}
+
+func ipStrings(addrs []net.IPAddr) []string {
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.IP.String()
+	}
+	return out
+}
+
+func TestResolver_UDPAndCache(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"www.example.": {ips: []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"}, ttl: 60},
+	}}
+	addr := dns.serveUDP(t)
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{"udp://" + addr}})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	addrs, err := r.LookupIPAddr(context.Background(), "WWW.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if got := ipStrings(addrs); len(got) != 2 || got[0] != "93.184.215.14" {
+		t.Fatalf("LookupIPAddr() = %v", got)
+	}
+	if q := dns.queries.Load(); q != 2 {
+		t.Fatalf("queries = %d, want 2 (A and AAAA)", q)
+	}
+
+	// Served from the cache within the TTL
+	if _, err := r.LookupIPAddr(context.Background(), "www.example"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if q := dns.queries.Load(); q != 2 {
+		t.Errorf("queries = %d after cached lookup, want 2", q)
+	}
+
+	// Expired after the TTL
+	now = now.Add(61 * time.Second)
+	if _, err := r.LookupIPAddr(context.Background(), "www.example"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if q := dns.queries.Load(); q != 4 {
+		t.Errorf("queries = %d after expiry, want 4", q)
+	}
+}
+
+func TestResolver_ZeroTTL(t *testing.T) {
+	// A do-not-cache A answer keeps the merged answer out of the cache,
+	// whatever the TTL of the AAAA answer and its order within a response
+	ttl := uint32(300)
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"www.example.": {ips: []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"}, ttl: 0, ttlAAAA: &ttl},
+	}}
+	addr := dns.serveUDP(t)
+	r := newTestResolver(t, ResolverConfig{Servers: []string{"udp://" + addr}})
+
+	for range 2 {
+		if _, err := r.LookupIPAddr(context.Background(), "www.example"); err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+		}
+	}
+	if q := dns.queries.Load(); q != 4 {
+		t.Errorf("queries = %d, want 4 (nothing cached)", q)
+	}
+
+	// Within one response the lowest TTL wins too
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1, Response: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("www.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	for _, ttl := range []uint32{0, 300} {
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("www.example."), Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		})
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	_, got, err := parseDNSResponse(b, 1, msg.Questions[0])
+	if err != nil || got != 0 {
+		t.Errorf("parseDNSResponse() TTL = %v, %v, want 0", got, err)
+	}
+}
+
+func TestResolver_UDPSkipsMismatchedID(t *testing.T) {
+	dns := &fakeDNS{strayUDP: true, answers: map[string]dnsAnswer{
+		"www.example.": {ips: []string{"93.184.215.14"}, ttl: 60},
+	}}
+	addr := dns.serveUDP(t)
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{addr}})
+	addrs, err := r.LookupIPAddr(context.Background(), "www.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if got := ipStrings(addrs); len(got) != 1 || got[0] != "93.184.215.14" {
+		t.Errorf("LookupIPAddr() = %v", got)
+	}
+}
+
+func TestResolver_SharedLookupOutlivesCaller(t *testing.T) {
+	r := newTestResolver(t, ResolverConfig{})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r.system = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		close(started)
+		select {
+		case <-release:
+			return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.LookupIPAddr(ctx, "www.example")
+		first <- err
+	}()
+	<-started
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := r.LookupIPAddr(context.Background(), "www.example")
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled LookupIPAddr() error = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting LookupIPAddr() error = %v, want nil", err)
+	}
+}
+
+func TestResolver_NegativeCache(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{}}
+	addr := dns.serveUDP(t)
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{addr}})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	if _, err := r.LookupIPAddr(context.Background(), "missing.example"); err == nil {
+		t.Fatal("LookupIPAddr() expected error for NXDOMAIN")
+	}
+	if _, err := r.LookupIPAddr(context.Background(), "missing.example"); err == nil {
+		t.Fatal("LookupIPAddr() expected cached error")
+	}
+	if q := dns.queries.Load(); q != 2 {
+		t.Errorf("queries = %d, want 2 with the negative answer cached", q)
+	}
+
+	// The SOA minimum (60s) bounds the negative TTL
+	now = now.Add(61 * time.Second)
+	_, _ = r.LookupIPAddr(context.Background(), "missing.example")
+	if q := dns.queries.Load(); q != 4 {
+		t.Errorf("queries = %d after negative TTL, want 4", q)
+	}
+}
+
+func TestResolver_TruncatedFallsBackToTCP(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"big.example.": {ips: []string{"93.184.215.14"}, ttl: 60, truncated: true},
+	}}
+	addr := dns.serveUDP(t)
+	dns.serveTCP(t, addr)
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{addr}})
+	addrs, err := r.LookupIPAddr(context.Background(), "big.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if got := ipStrings(addrs); len(got) != 1 || got[0] != "93.184.215.14" {
+		t.Errorf("LookupIPAddr() = %v", got)
+	}
+}
+
+func TestResolver_TCP(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"tcp.example.": {ips: []string{"2001:db8::1"}, ttl: 60},
+	}}
+	addr := dns.serveTCP(t, "127.0.0.1:0")
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{"tcp://" + addr}})
+	addrs, err := r.LookupIPAddr(context.Background(), "tcp.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if got := ipStrings(addrs); len(got) != 1 || got[0] != "2001:db8::1" {
+		t.Errorf("LookupIPAddr() = %v", got)
+	}
+}
+
+func TestResolver_DoH(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"doh.example.": {ips: []string{"93.184.215.14"}, ttl: 60},
+	}}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(dns.respond(t, query, false))
+	}))
+	defer srv.Close()
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{srv.URL + "/dns-query"}})
+	r.httpClient = srv.Client()
+
+	addrs, err := r.LookupIPAddr(context.Background(), "doh.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	if got := ipStrings(addrs); len(got) != 1 || got[0] != "93.184.215.14" {
+		t.Errorf("LookupIPAddr() = %v", got)
+	}
+}
+
+func TestResolver_FailoverAndNoCacheOnFailure(t *testing.T) {
+	dns := &fakeDNS{answers: map[string]dnsAnswer{
+		"www.example.": {ips: []string{"93.184.215.14"}, ttl: 60},
+	}}
+	good := dns.serveUDP(t)
+
+	// Nothing listens on the first server's TCP port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	dead := l.Addr().String()
+	_ = l.Close()
+
+	r := newTestResolver(t, ResolverConfig{Servers: []string{"tcp://" + dead, good}})
+	if _, err := r.LookupIPAddr(context.Background(), "www.example"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v, want failover to the second server", err)
+	}
+
+	// Transient failures are not cached
+	r = newTestResolver(t, ResolverConfig{Servers: []string{"tcp://" + dead}})
+	if _, err := r.LookupIPAddr(context.Background(), "www.example"); err == nil {
+		t.Fatal("LookupIPAddr() expected error")
+	}
+	if len(r.cache) != 0 {
+		t.Errorf("cache has %d entries after a transient failure, want 0", len(r.cache))
+	}
+}
+
+func TestResolver_Prefer(t *testing.T) {
+	r := newTestResolver(t, ResolverConfig{Prefer: PreferIPv6})
+	r.system = staticLookup("93.184.215.14", "2001:db8::1", "192.0.2.1", "2001:db8::2")
+
+	addrs, err := r.LookupIPAddr(context.Background(), "dual.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+	want := []string{"2001:db8::1", "2001:db8::2", "93.184.215.14", "192.0.2.1"}
+	got := ipStrings(addrs)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("LookupIPAddr() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseDNSServer(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    dnsServer
+		wantErr bool
+	}{
+		{in: "1.1.1.1", want: dnsServer{scheme: "udp", addr: "1.1.1.1:53"}},
+		{in: "1.1.1.1:5353", want: dnsServer{scheme: "udp", addr: "1.1.1.1:5353"}},
+		{in: "tcp://[2606:4700::1111]", want: dnsServer{scheme: "tcp", addr: "[2606:4700::1111]:53"}},
+		{in: "https://dns.google/dns-query", want: dnsServer{scheme: "https", addr: "https://dns.google/dns-query"}},
+		{in: "tls://1.1.1.1", wantErr: true},
+		{in: "udp://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDNSServer(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDNSServer(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDNSServer(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
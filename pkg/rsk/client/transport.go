@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// serverDialTimeout bounds establishing the control connection, including
+// any TLS and WebSocket upgrade, before the HELLO handshake starts.
+const serverDialTimeout = 15 * time.Second
+
+// dialServer opens the control connection to the server for stream-based
+// transports. ServerAddr is either a plain host:port for raw TCP or a
+// ws:// / wss:// URL for the WebSocket transport.
+func (c *Client) dialServer(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, serverDialTimeout)
+	defer cancel()
+
+	target := c.Config.ServerAddr
+	if transport.IsWebSocketURL(target) {
+		u, err := transport.ParseWebSocketURL(target)
+		if err != nil {
+			return nil, err
+		}
+		target = transport.WebSocketHostPort(u)
+	}
+
+	dial, err := c.serverDialer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if transport.IsWebSocketURL(c.Config.ServerAddr) {
+		return transport.DialWebSocket(ctx, c.Config.ServerAddr, c.tlsConfig(), dial)
+	}
+
+	return dial(ctx, "tcp", target)
+}
+
+// serverDialer returns the dial function for reaching target (host:port),
+// tunneling through the configured or environment proxy when one applies.
+func (c *Client) serverDialer(target string) (transport.DialFunc, error) {
+	var d net.Dialer
+
+	proxyURL, err := c.controlProxy(target)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return d.DialContext, nil
+	}
+
+	c.Logger.Debug("Dialing server through proxy",
+		"server", target,
+		"proxy", proxyURL.Redacted())
+
+	return transport.ProxyDialer(proxyURL, d.DialContext)
+}
+
+// controlProxy resolves the proxy for the control connection: an explicit
+// Config.Proxy wins, ProxyDirect disables proxying, and otherwise
+// HTTPS_PROXY / ALL_PROXY from the environment apply.
+func (c *Client) controlProxy(target string) (*url.URL, error) {
+	switch c.Config.Proxy {
+	case "":
+		return transport.ProxyFromEnvironment(target)
+	case ProxyDirect:
+		return nil, nil
+	default:
+		return transport.ParseProxyURL(c.Config.Proxy)
+	}
+}
+
+// dialQUIC opens a QUIC connection to a quic://host:port server address.
+func (c *Client) dialQUIC(ctx context.Context) (*transport.QUICSession, error) {
+	addr, err := transport.ParseQUICURL(c.Config.ServerAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, serverDialTimeout)
+	defer cancel()
+
+	return transport.DialQUIC(ctx, addr, c.tlsConfig())
+}
+
+// tlsConfig returns the TLS configuration used for encrypted transports.
+func (c *Client) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.Config.InsecureSkipVerify,
+	}
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listBackups(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	return matches
+}
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 0, 0)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	_, err = f.Write([]byte("12345678\n"))
+	require.NoError(t, err)
+	assert.Empty(t, listBackups(t, path))
+
+	// The second record does not fit and starts a new file
+	_, err = f.Write([]byte("abcdefgh\n"))
+	require.NoError(t, err)
+
+	backups := listBackups(t, path)
+	require.Len(t, backups, 1)
+
+	rotated, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "12345678\n", string(rotated))
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefgh\n", string(current))
+}
+
+func TestRotatingFile_RotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 0, time.Hour, 0)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	f.openedAt = now
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	now = now.Add(30 * time.Minute)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+	assert.Empty(t, listBackups(t, path))
+
+	now = now.Add(time.Hour)
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+	assert.Len(t, listBackups(t, path), 1)
+}
+
+func TestRotatingFile_PrunesBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 0, 0, 2)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		_, err = f.Write([]byte("line\n"))
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+		require.NoError(t, f.Rotate())
+	}
+
+	backups := listBackups(t, path)
+	require.Len(t, backups, 2)
+	assert.Equal(t, path+".20260101-000003.000", backups[0])
+	assert.Equal(t, path+".20260101-000004.000", backups[1])
+}
+
+func TestRotatingFile_WriteAfterClose(t *testing.T) {
+	f, err := OpenRotatingFile(filepath.Join(t.TempDir(), "access.log"), 0, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = f.Write([]byte("x"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -3,7 +3,12 @@ package common
 import (
 	"crypto/rand"
 	"crypto/subtle"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -44,3 +49,56 @@ func GenerateToken(length int) (string, error) {
 
 	return string(token), nil
 }
+
+// ParseByteSize parses a byte count, or a rate in bytes per second, with an
+// optional K, M or G suffix (powers of 1024), such as "512K" or "10M".
+// An empty string parses as zero.
+func ParseByteSize(s string) (int64, error) {
+	raw := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a non-negative number with an optional K, M or G suffix", raw)
+	}
+	return value * multiplier, nil
+}
+
+// WriteFileAtomic replaces the file at path with data. It writes to a
+// temporary file first, so a crash never leaves a truncated file behind.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package proto
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Control message kinds.
+const (
+	ControlRequest  = 0x01 // Expects a response with the same ID
+	ControlResponse = 0x02 // Answers the request with the same ID
+	ControlNotify   = 0x03 // One-way message, never answered
+)
+
+// Control methods. The payload of each is a JSON document.
+const (
+	MethodPing   = "ping"   // Either side: liveness check with an empty payload
+	MethodStats  = "stats"  // Server to client: answered with ClientStats
+	MethodReload = "reload" // Server to client: reload domain lists, answered with ReloadResult
+
+	MethodAddPorts    = "add_ports"    // Client to server: claim PortsRequest.Ports, answered with PortsResult
+	MethodRemovePorts = "remove_ports" // Client to server: release PortsRequest.Ports, answered with PortsResult
+)
+
+const (
+	MaxControlFrameSize = 1 << 20 // Bytes after the length prefix
+	MaxControlMethodLen = 255
+	MaxControlErrorLen  = 1024
+
+	controlWriteTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidControlMessage = errors.New("malformed control message")
+	ErrUnknownControlMethod  = errors.New("unknown control method")
+	ErrControlClosed         = errors.New("control stream closed")
+)
+
+// ControlMessage is one frame on the control stream. After a version 2
+// handshake negotiating CapControl, the client opens one extra stream right
+// after the session is set up; both sides then exchange these frames on it.
+type ControlMessage struct {
+	Kind    uint8  // One of the Control kinds
+	ID      uint32 // Pairs a response with its request (0 for notifications)
+	Method  string // Method of a request or notification
+	Error   string // Why a request failed, on responses
+	Payload []byte // JSON-encoded body (may be empty)
+}
+
+// ClientStats is the answer of a client to a stats request.
+type ClientStats struct {
+	ActiveStreams int64 `json:"active_streams"`
+	TotalStreams  int64 `json:"total_streams"`
+	BytesUp       int64 `json:"bytes_up"`
+	BytesDown     int64 `json:"bytes_down"`
+	QuotaUsed     int64 `json:"quota_used,omitempty"`
+	QuotaLimit    int64 `json:"quota_limit,omitempty"`
+}
+
+// ReloadResult is the answer of a client to a reload request.
+type ReloadResult struct {
+	Changed bool `json:"changed"` // Whether any domain list had changed
+}
+
+// PortsRequest asks the server to bind or release ports within the session.
+// Adding is all-or-nothing: if any port cannot be bound, none is.
+type PortsRequest struct {
+	Ports []int `json:"ports"`
+}
+
+// PortsResult lists the ports the session holds after a port request.
+type PortsResult struct {
+	Ports []int `json:"ports"`
+}
+
+// WriteControlMessage writes m as a frame: a four-byte length, then kind,
+// ID, method with a one-byte length, error with a two-byte length and the
+// payload filling the rest of the frame.
+func WriteControlMessage(w io.Writer, m ControlMessage) error {
+	if m.Kind < ControlRequest || m.Kind > ControlNotify ||
+		len(m.Method) > MaxControlMethodLen || len(m.Error) > MaxControlErrorLen {
+		return ErrInvalidControlMessage
+	}
+	size := 1 + 4 + 1 + len(m.Method) + 2 + len(m.Error) + len(m.Payload)
+	if size > MaxControlFrameSize {
+		return ErrMessageTooLarge
+	}
+
+	buf := make([]byte, 0, 4+size)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, m.Kind)
+	buf = binary.BigEndian.AppendUint32(buf, m.ID)
+	buf = append(buf, uint8(len(m.Method)))
+	buf = append(buf, m.Method...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(m.Error)))
+	buf = append(buf, m.Error...)
+	buf = append(buf, m.Payload...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadControlMessage reads one frame written by WriteControlMessage.
+func ReadControlMessage(r io.Reader) (ControlMessage, error) {
+	var m ControlMessage
+
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return m, err
+	}
+	if size > MaxControlFrameSize {
+		return m, ErrMessageTooLarge
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return m, err
+	}
+
+	if len(frame) < 6 {
+		return m, ErrInvalidControlMessage
+	}
+	m.Kind = frame[0]
+	m.ID = binary.BigEndian.Uint32(frame[1:5])
+	if m.Kind < ControlRequest || m.Kind > ControlNotify {
+		return m, ErrInvalidControlMessage
+	}
+	frame = frame[5:]
+
+	n := int(frame[0])
+	if len(frame) < 1+n+2 {
+		return m, ErrInvalidControlMessage
+	}
+	m.Method = string(frame[1 : 1+n])
+	frame = frame[1+n:]
+
+	n = int(binary.BigEndian.Uint16(frame))
+	if n > MaxControlErrorLen || len(frame) < 2+n {
+		return m, ErrInvalidControlMessage
+	}
+	m.Error = string(frame[2 : 2+n])
+	if payload := frame[2+n:]; len(payload) > 0 {
+		m.Payload = payload
+	}
+	return m, nil
+}
+
+// ControlHandler handles a request or notification received on a control
+// stream. For requests, the result is sent back JSON-encoded, or the error's
+// text if it fails; for notifications both are discarded.
+type ControlHandler func(method string, payload []byte) (any, error)
+
+// ControlError is a failure reported by the peer in answer to a request.
+type ControlError struct {
+	Method  string
+	Message string
+}
+
+func (e *ControlError) Error() string {
+	return e.Method + ": " + e.Message
+}
+
+// ControlConn is one end of a control stream. Requests may be issued from
+// several goroutines while Serve reads the stream and dispatches incoming
+// messages to the handler.
+type ControlConn struct {
+	conn    net.Conn
+	handler ControlHandler
+
+	writeMu sync.Mutex // Serializes frames
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan ControlMessage
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewControlConn wraps conn. A nil handler answers every request with
+// ErrUnknownControlMethod.
+func NewControlConn(conn net.Conn, handler ControlHandler) *ControlConn {
+	if handler == nil {
+		handler = func(string, []byte) (any, error) { return nil, ErrUnknownControlMethod }
+	}
+	return &ControlConn{
+		conn:    conn,
+		handler: handler,
+		pending: make(map[uint32]chan ControlMessage),
+		done:    make(chan struct{}),
+	}
+}
+
+// Serve reads messages until the stream fails or is closed, then closes the
+// connection and fails pending requests. Requests are handled concurrently.
+func (c *ControlConn) Serve() error {
+	defer func() {
+		_ = c.Close()
+	}()
+
+	for {
+		m, err := ReadControlMessage(c.conn)
+		if err != nil {
+			return err
+		}
+
+		switch m.Kind {
+		case ControlRequest:
+			go c.answer(m)
+		case ControlNotify:
+			go func() {
+				_, _ = c.handler(m.Method, m.Payload)
+			}()
+		case ControlResponse:
+			c.mu.Lock()
+			ch, ok := c.pending[m.ID]
+			delete(c.pending, m.ID)
+			c.mu.Unlock()
+			if ok {
+				ch <- m
+			}
+		}
+	}
+}
+
+func (c *ControlConn) answer(req ControlMessage) {
+	resp := ControlMessage{Kind: ControlResponse, ID: req.ID, Method: req.Method}
+
+	result, err := c.handler(req.Method, req.Payload)
+	if err == nil && result != nil {
+		resp.Payload, err = json.Marshal(result)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		if len(resp.Error) > MaxControlErrorLen {
+			resp.Error = resp.Error[:MaxControlErrorLen]
+		}
+		resp.Payload = nil
+	}
+
+	_ = c.write(resp)
+}
+
+// Call sends a request and waits for its response, decoding the payload into
+// result if it is non-nil. A failure reported by the peer is a *ControlError.
+func (c *ControlConn) Call(ctx context.Context, method string, params, result any) error {
+	payload, err := marshalPayload(params)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan ControlMessage, 1)
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ControlMessage{Kind: ControlRequest, ID: id, Method: method, Payload: payload}); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != "" {
+			return &ControlError{Method: method, Message: resp.Error}
+		}
+		if result != nil && len(resp.Payload) > 0 {
+			if err := json.Unmarshal(resp.Payload, result); err != nil {
+				return fmt.Errorf("invalid %s response: %w", method, err)
+			}
+		}
+		return nil
+	case <-c.done:
+		return ErrControlClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify sends a one-way message.
+func (c *ControlConn) Notify(method string, params any) error {
+	payload, err := marshalPayload(params)
+	if err != nil {
+		return err
+	}
+	return c.write(ControlMessage{Kind: ControlNotify, Method: method, Payload: payload})
+}
+
+func marshalPayload(params any) ([]byte, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
+
+func (c *ControlConn) write(m ControlMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return ErrControlClosed
+	default:
+	}
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout)); err != nil {
+		return err
+	}
+	return WriteControlMessage(c.conn, m)
+}
+
+// Done is closed once the control stream is closed.
+func (c *ControlConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the stream, failing pending and future requests.
+func (c *ControlConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
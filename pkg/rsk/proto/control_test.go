@@ -0,0 +1,142 @@
+package proto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestControlMessageRoundTrip(t *testing.T) {
+	messages := []ControlMessage{
+		{Kind: ControlRequest, ID: 1, Method: MethodStats},
+		{Kind: ControlResponse, ID: 1, Method: MethodStats, Payload: []byte(`{"active_streams":3}`)},
+		{Kind: ControlResponse, ID: 2, Method: MethodReload, Error: "no domain rules"},
+		{Kind: ControlNotify, Method: "custom", Payload: []byte(`"hello"`)},
+	}
+
+	var buf bytes.Buffer
+	for _, m := range messages {
+		if err := WriteControlMessage(&buf, m); err != nil {
+			t.Fatalf("WriteControlMessage(%+v) error = %v", m, err)
+		}
+	}
+	for _, want := range messages {
+		got, err := ReadControlMessage(&buf)
+		if err != nil {
+			t.Fatalf("ReadControlMessage() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadControlMessage() = %+v, want %+v", got, want)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Unread bytes after messages: %d", buf.Len())
+	}
+}
+
+func TestControlMessageInvalid(t *testing.T) {
+	if err := WriteControlMessage(&bytes.Buffer{}, ControlMessage{Kind: 0x09}); !errors.Is(err, ErrInvalidControlMessage) {
+		t.Errorf("WriteControlMessage(bad kind) error = %v, want %v", err, ErrInvalidControlMessage)
+	}
+	big := ControlMessage{Kind: ControlNotify, Payload: make([]byte, MaxControlFrameSize)}
+	if err := WriteControlMessage(&bytes.Buffer{}, big); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("WriteControlMessage(too large) error = %v, want %v", err, ErrMessageTooLarge)
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"too large", []byte{0x00, 0x20, 0x00, 0x00}, ErrMessageTooLarge},
+		{"too short", []byte{0, 0, 0, 3, ControlNotify, 0, 0}, ErrInvalidControlMessage},
+		{"bad kind", []byte{0, 0, 0, 8, 0x07, 0, 0, 0, 0, 0, 0, 0}, ErrInvalidControlMessage},
+		{"method overflow", []byte{0, 0, 0, 8, ControlNotify, 0, 0, 0, 0, 5, 0, 0}, ErrInvalidControlMessage},
+		{"error overflow", []byte{0, 0, 0, 8, ControlNotify, 0, 0, 0, 0, 0, 0, 9}, ErrInvalidControlMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadControlMessage(bytes.NewReader(tt.frame))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ReadControlMessage() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlConn(t *testing.T) {
+	a, b := net.Pipe()
+
+	notified := make(chan string, 1)
+	server := NewControlConn(a, nil)
+	client := NewControlConn(b, func(method string, payload []byte) (any, error) {
+		switch method {
+		case MethodPing:
+			return nil, nil
+		case MethodStats:
+			return ClientStats{ActiveStreams: 2, BytesUp: 100}, nil
+		case "notice":
+			notified <- string(payload)
+			return nil, nil
+		}
+		return nil, ErrUnknownControlMethod
+	})
+	go func() { _ = server.Serve() }()
+	go func() { _ = client.Serve() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Call(ctx, MethodPing, nil, nil); err != nil {
+		t.Fatalf("Call(ping) error = %v", err)
+	}
+
+	var stats ClientStats
+	if err := server.Call(ctx, MethodStats, nil, &stats); err != nil {
+		t.Fatalf("Call(stats) error = %v", err)
+	}
+	if stats.ActiveStreams != 2 || stats.BytesUp != 100 {
+		t.Errorf("Call(stats) = %+v", stats)
+	}
+
+	err := server.Call(ctx, "drain", nil, nil)
+	var ctrlErr *ControlError
+	if !errors.As(err, &ctrlErr) || ctrlErr.Message != ErrUnknownControlMethod.Error() {
+		t.Errorf("Call(drain) error = %v, want unknown method", err)
+	}
+
+	// Requests flow both ways; a nil handler knows no methods
+	if err := client.Call(ctx, MethodPing, nil, nil); !errors.As(err, &ctrlErr) {
+		t.Errorf("Call(ping) to nil handler error = %v, want ControlError", err)
+	}
+
+	if err := server.Notify("notice", "draining"); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != `"draining"` {
+			t.Errorf("Notification payload = %s", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("Notification not delivered")
+	}
+
+	// Closing one end fails calls on both
+	_ = client.Close()
+	if err := client.Call(ctx, MethodPing, nil, nil); !errors.Is(err, ErrControlClosed) {
+		t.Errorf("Call() after Close error = %v, want %v", err, ErrControlClosed)
+	}
+	select {
+	case <-server.Done():
+	case <-ctx.Done():
+		t.Fatal("Peer did not notice the closed stream")
+	}
+	if err := server.Call(ctx, MethodPing, nil, nil); !errors.Is(err, ErrControlClosed) {
+		t.Errorf("Call() on closed peer error = %v, want %v", err, ErrControlClosed)
+	}
+}
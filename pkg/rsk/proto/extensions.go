@@ -0,0 +1,271 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Capabilities is a bitset of optional protocol features. In a version 2
+// handshake the client announces the features it supports and the server
+// replies with those it supports too; both sides then use only that common set.
+type Capabilities uint32
+
+const (
+	CapStriping      Capabilities = 1 << iota // Striped multi-connection sessions
+	CapClientInfo                             // Client labels and metadata
+	CapControl                                // Control stream opened after the session is set up
+	CapConnectStatus                          // CONNECT_RESP with the dial result on every stream
+	CapConnectPort                            // CONNECT_REQ carries the claimed port the connection arrived on
+)
+
+// SupportedCapabilities are the features this implementation supports.
+const SupportedCapabilities = CapStriping | CapClientInfo | CapControl | CapConnectStatus | CapConnectPort
+
+var capabilityNames = []struct {
+	cap  Capabilities
+	name string
+}{
+	{CapStriping, "striping"},
+	{CapClientInfo, "client_info"},
+	{CapControl, "control"},
+	{CapConnectStatus, "connect_status"},
+	{CapConnectPort, "connect_port"},
+}
+
+// Has reports whether all capabilities in other are set.
+func (c Capabilities) Has(other Capabilities) bool {
+	return c&other == other
+}
+
+// String lists the capabilities by name, with unknown bits in hex.
+func (c Capabilities) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c.Has(n.cap) {
+			names = append(names, n.name)
+			c &^= n.cap
+		}
+	}
+	if c != 0 {
+		names = append(names, "0x"+strconv.FormatUint(uint64(c), 16))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Extension types of version 2 messages.
+const (
+	ExtStripe        = 0x01 // StripeInfo: 16-byte session ID and member count
+	ExtClientInfo    = 0x02 // ClientInfo block, as appended to version 1 HELLOs
+	ExtBestEffort    = 0x03 // HELLO: empty; bind the free ports instead of rejecting the client
+	ExtRejectedPorts = 0x04 // HELLO_RESP: per rejected port, the port and a status code
+)
+
+const (
+	MaxExtensions   = 255
+	MaxExtensionLen = MaxHelloSize
+)
+
+var ErrInvalidExtension = errors.New("malformed or duplicate extension")
+
+// Extension is a TLV entry in the extension area of a version 2 HELLO or
+// HELLO_RESP. Receivers skip types they do not know, so new fields can be
+// added without breaking older peers.
+type Extension struct {
+	Type uint8
+	Data []byte
+}
+
+func extensionsSize(exts []Extension) int {
+	n := 1
+	for _, ext := range exts {
+		n += 3 + len(ext.Data)
+	}
+	return n
+}
+
+// writeExtensions writes the capabilities and the extension area: a count,
+// then each extension as type, two-byte length and data.
+func writeExtensions(w io.Writer, caps Capabilities, exts []Extension) error {
+	if len(exts) > MaxExtensions {
+		return ErrInvalidExtension
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(caps)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{uint8(len(exts))}); err != nil {
+		return err
+	}
+	for _, ext := range exts {
+		if len(ext.Data) > MaxExtensionLen {
+			return ErrInvalidExtension
+		}
+		if _, err := w.Write([]byte{ext.Type}); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint16(len(ext.Data))); err != nil {
+			return err
+		}
+		// Synchronous conns such as net.Pipe block on empty writes
+		if len(ext.Data) == 0 {
+			continue
+		}
+		if _, err := w.Write(ext.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readExtensions(r io.Reader) (Capabilities, []Extension, error) {
+	var caps uint32
+	if err := binary.Read(r, binary.BigEndian, &caps); err != nil {
+		return 0, nil, err
+	}
+	var count uint8
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return 0, nil, err
+	}
+
+	exts := make([]Extension, 0, count)
+	for range count {
+		var ext Extension
+		if err := binary.Read(r, binary.BigEndian, &ext.Type); err != nil {
+			return 0, nil, err
+		}
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return 0, nil, err
+		}
+		if int(n) > MaxExtensionLen {
+			return 0, nil, ErrInvalidExtension
+		}
+		ext.Data = make([]byte, n)
+		if _, err := io.ReadFull(r, ext.Data); err != nil {
+			return 0, nil, err
+		}
+		exts = append(exts, ext)
+	}
+	return Capabilities(caps), exts, nil
+}
+
+// helloExtensions returns the extensions encoding the optional parts of a
+// version 2 HELLO, followed by any others.
+func helloExtensions(h Hello) ([]Extension, error) {
+	var exts []Extension
+	if h.Stripe != nil {
+		data := make([]byte, 0, len(h.Stripe.SessionID)+1)
+		data = append(data, h.Stripe.SessionID[:]...)
+		data = append(data, h.Stripe.Members)
+		exts = append(exts, Extension{Type: ExtStripe, Data: data})
+	}
+	if h.Info != nil {
+		var buf bytes.Buffer
+		if err := writeInfo(&buf, h.Info); err != nil {
+			return nil, err
+		}
+		exts = append(exts, Extension{Type: ExtClientInfo, Data: buf.Bytes()})
+	}
+	if h.BestEffort {
+		exts = append(exts, Extension{Type: ExtBestEffort})
+	}
+	for _, ext := range h.Extensions {
+		if ext.Type == ExtStripe || ext.Type == ExtClientInfo || ext.Type == ExtBestEffort {
+			return nil, ErrInvalidExtension
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// decodeHelloExtensions fills the optional parts of h from its extensions,
+// keeping the ones it does not know in h.Extensions.
+func decodeHelloExtensions(h *Hello, exts []Extension) error {
+	for _, ext := range exts {
+		switch ext.Type {
+		case ExtStripe:
+			if h.Stripe != nil || len(ext.Data) != 17 {
+				return ErrInvalidExtension
+			}
+			stripe := &StripeInfo{Members: ext.Data[16]}
+			copy(stripe.SessionID[:], ext.Data)
+			h.Stripe = stripe
+		case ExtClientInfo:
+			if h.Info != nil {
+				return ErrInvalidExtension
+			}
+			r := bytes.NewReader(ext.Data)
+			info, err := readInfo(r)
+			if err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return ErrInvalidExtension
+				}
+				return err
+			}
+			if r.Len() != 0 {
+				return ErrInvalidExtension
+			}
+			h.Info = info
+		case ExtBestEffort:
+			if h.BestEffort || len(ext.Data) != 0 {
+				return ErrInvalidExtension
+			}
+			h.BestEffort = true
+		default:
+			h.Extensions = append(h.Extensions, ext)
+		}
+	}
+	return nil
+}
+
+// helloRespExtensions returns the extensions encoding the optional parts of
+// a version 2 HELLO_RESP, followed by any others.
+func helloRespExtensions(h HelloResp) ([]Extension, error) {
+	var exts []Extension
+	if len(h.Rejected) > 0 {
+		if len(h.Rejected) > MaxPortCount {
+			return nil, ErrInvalidExtension
+		}
+		data := make([]byte, 0, 3*len(h.Rejected))
+		for _, r := range h.Rejected {
+			data = binary.BigEndian.AppendUint16(data, r.Port)
+			data = append(data, r.Status)
+		}
+		exts = append(exts, Extension{Type: ExtRejectedPorts, Data: data})
+	}
+	for _, ext := range h.Extensions {
+		if ext.Type == ExtRejectedPorts {
+			return nil, ErrInvalidExtension
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// decodeHelloRespExtensions fills the optional parts of h from its
+// extensions, keeping the ones it does not know in h.Extensions.
+func decodeHelloRespExtensions(h *HelloResp, exts []Extension) error {
+	for _, ext := range exts {
+		switch ext.Type {
+		case ExtRejectedPorts:
+			n := len(ext.Data) / 3
+			if h.Rejected != nil || n == 0 || n > MaxPortCount || len(ext.Data)%3 != 0 {
+				return ErrInvalidExtension
+			}
+			h.Rejected = make([]PortRejection, n)
+			for i := range h.Rejected {
+				entry := ext.Data[3*i:]
+				h.Rejected[i] = PortRejection{Port: binary.BigEndian.Uint16(entry), Status: entry[2]}
+			}
+		default:
+			h.Extensions = append(h.Extensions, ext)
+		}
+	}
+	return nil
+}
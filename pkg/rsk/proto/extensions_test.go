@@ -0,0 +1,231 @@
+package proto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHelloV2RoundTrip(t *testing.T) {
+	hello := Hello{
+		Magic:        HelloMagic(false),
+		Version:      Version2,
+		Token:        []byte("test-token-123"),
+		Ports:        []uint16{20000, 20001},
+		Name:         "exit-fra-1",
+		Stripe:       &StripeInfo{SessionID: [16]byte{9, 8, 7}, Members: 3},
+		Info:         &ClientInfo{Version: "v1.5.0", OS: "linux", Labels: map[string]string{"region": "eu"}},
+		Capabilities: CapStriping | CapClientInfo | 1<<30,
+		Extensions:   []Extension{{Type: 0x7f, Data: []byte("future")}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHello(&buf, hello); err != nil {
+		t.Fatalf("WriteHello() error = %v", err)
+	}
+	got, err := ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, hello) {
+		t.Errorf("ReadHello() = %+v, want %+v", got, hello)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Unread bytes after HELLO: %d", buf.Len())
+	}
+}
+
+func TestHelloV2Minimal(t *testing.T) {
+	hello := Hello{
+		Magic:   HelloMagic(false),
+		Version: Version2,
+		Token:   []byte("token"),
+		Ports:   []uint16{20000},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHello(&buf, hello); err != nil {
+		t.Fatalf("WriteHello() error = %v", err)
+	}
+	// Base fields, then four bytes of capabilities and an empty extension area
+	if want := 4 + 1 + 1 + 5 + 1 + 2 + 1 + 4 + 1; buf.Len() != want {
+		t.Errorf("Encoded length = %d, want %d", buf.Len(), want)
+	}
+	got, err := ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello() error = %v", err)
+	}
+	if got.Stripe != nil || got.Info != nil || got.Capabilities != 0 || got.Extensions != nil {
+		t.Errorf("Unexpected optional parts: %+v", got)
+	}
+}
+
+func TestHelloV2InvalidExtensions(t *testing.T) {
+	base := func(exts ...Extension) []byte {
+		var buf bytes.Buffer
+		hello := Hello{
+			Magic:      HelloMagic(false),
+			Version:    Version2,
+			Token:      []byte("token"),
+			Ports:      []uint16{20000},
+			Extensions: exts,
+		}
+		if err := WriteHello(&buf, hello); err != nil {
+			t.Fatalf("WriteHello() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	// Known extensions must be set through their fields
+	var buf bytes.Buffer
+	err := WriteHello(&buf, Hello{
+		Magic:      HelloMagic(false),
+		Version:    Version2,
+		Token:      []byte("token"),
+		Ports:      []uint16{20000},
+		Extensions: []Extension{{Type: ExtStripe, Data: make([]byte, 17)}},
+	})
+	if err != ErrInvalidExtension {
+		t.Errorf("WriteHello() error = %v, want %v", err, ErrInvalidExtension)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "short stripe", data: patchExtType(base(Extension{Type: 0x7f, Data: make([]byte, 5)}), ExtStripe), wantErr: ErrInvalidExtension},
+		{name: "truncated client info", data: patchExtType(base(Extension{Type: 0x7f, Data: []byte{3, 'v'}}), ExtClientInfo), wantErr: ErrInvalidExtension},
+		{name: "stripe without members", data: patchExtType(base(Extension{Type: 0x7f, Data: make([]byte, 17)}), ExtStripe), wantErr: ErrInvalidStripe},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHello(bytes.NewReader(tt.data)); err != tt.wantErr {
+				t.Errorf("ReadHello() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// patchExtType replaces the type of the only extension of an encoded HELLO.
+func patchExtType(data []byte, typ uint8) []byte {
+	// Token "token", one port and an empty name precede capabilities and the count
+	offset := 4 + 1 + 1 + 5 + 1 + 2 + 1 + 4 + 1
+	data[offset] = typ
+	return data
+}
+
+func TestHelloRespV2RoundTrip(t *testing.T) {
+	resp := HelloResp{
+		Version:       Version2,
+		Status:        StatusOK,
+		AcceptedPorts: []uint16{20000},
+		Message:       "Connection accepted",
+		Capabilities:  CapClientInfo,
+		Extensions:    []Extension{{Type: 0x10, Data: []byte{1, 2}}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHelloResp(&buf, resp); err != nil {
+		t.Fatalf("WriteHelloResp() error = %v", err)
+	}
+	got, err := ReadHelloResp(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloResp() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, resp) {
+		t.Errorf("ReadHelloResp() = %+v, want %+v", got, resp)
+	}
+
+	// Version 1 responses carry no capabilities
+	resp = HelloResp{Version: Version, Status: StatusOK, Capabilities: CapClientInfo}
+	buf.Reset()
+	if err := WriteHelloResp(&buf, resp); err != nil {
+		t.Fatalf("WriteHelloResp() error = %v", err)
+	}
+	got, err = ReadHelloResp(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloResp() error = %v", err)
+	}
+	if got.Capabilities != 0 || buf.Len() != 0 {
+		t.Errorf("Version 1 response decoded capabilities %v, %d unread bytes", got.Capabilities, buf.Len())
+	}
+}
+
+func TestBestEffortRoundTrip(t *testing.T) {
+	hello := Hello{
+		Magic:      HelloMagic(false),
+		Version:    Version2,
+		Token:      []byte("token"),
+		Ports:      []uint16{20000, 20001, 20002},
+		BestEffort: true,
+	}
+	var buf bytes.Buffer
+	if err := WriteHello(&buf, hello); err != nil {
+		t.Fatalf("WriteHello() error = %v", err)
+	}
+	got, err := ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, hello) {
+		t.Errorf("ReadHello() = %+v, want %+v", got, hello)
+	}
+
+	resp := HelloResp{
+		Version:       Version2,
+		Status:        StatusOK,
+		AcceptedPorts: []uint16{20001},
+		Rejected: []PortRejection{
+			{Port: 20000, Status: StatusPortInUse},
+			{Port: 20002, Status: StatusPortForbidden},
+		},
+		Extensions: []Extension{{Type: 0x10, Data: []byte{1}}},
+	}
+	buf.Reset()
+	if err := WriteHelloResp(&buf, resp); err != nil {
+		t.Fatalf("WriteHelloResp() error = %v", err)
+	}
+	gotResp, err := ReadHelloResp(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloResp() error = %v", err)
+	}
+	if !reflect.DeepEqual(gotResp, resp) {
+		t.Errorf("ReadHelloResp() = %+v, want %+v", gotResp, resp)
+	}
+
+	// Known extension types cannot be passed as raw extensions
+	hello.Extensions = []Extension{{Type: ExtBestEffort}}
+	if err := WriteHello(&bytes.Buffer{}, hello); err != ErrInvalidExtension {
+		t.Errorf("WriteHello() error = %v, want %v", err, ErrInvalidExtension)
+	}
+	resp.Extensions = []Extension{{Type: ExtRejectedPorts}}
+	if err := WriteHelloResp(&bytes.Buffer{}, resp); err != ErrInvalidExtension {
+		t.Errorf("WriteHelloResp() error = %v, want %v", err, ErrInvalidExtension)
+	}
+
+	// Rejections are three bytes each
+	truncated := []byte{Version2, StatusOK, 0, 0, 0, 0, 0, 0, 1, ExtRejectedPorts, 0, 2, 0x4e, 0x20}
+	if _, err := ReadHelloResp(bytes.NewReader(truncated)); err != ErrInvalidExtension {
+		t.Errorf("ReadHelloResp() error = %v, want %v", err, ErrInvalidExtension)
+	}
+}
+
+func TestCapabilitiesString(t *testing.T) {
+	tests := []struct {
+		caps Capabilities
+		want string
+	}{
+		{0, "none"},
+		{CapStriping, "striping"},
+		{CapStriping | CapClientInfo, "striping,client_info"},
+		{SupportedCapabilities, "striping,client_info,control,connect_status,connect_port"},
+		{CapClientInfo | 1<<20, "client_info,0x100000"},
+	}
+	for _, tt := range tests {
+		if got := tt.caps.String(); got != tt.want {
+			t.Errorf("Capabilities(%d).String() = %q, want %q", uint32(tt.caps), got, tt.want)
+		}
+	}
+}
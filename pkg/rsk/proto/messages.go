@@ -3,12 +3,16 @@ package proto
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"slices"
 )
 
 const (
-	MagicValue = "RSK1"
-	Version    = 0x01
+	MagicValue   = "RSK1"
+	MagicStriped = "RSKS" // HELLO from a member of a striped multi-connection session
+	Version      = 0x01   // Fixed layout; stripe info is announced by the magic
+	Version2     = 0x02   // Capabilities and TLV extensions; the magic is always "RSK1"
 )
 
 const (
@@ -18,6 +22,14 @@ const (
 	MinPortCount = 1
 	MaxNameLen   = 64
 	MaxHelloSize = 2048
+
+	MaxStripeMembers = 16
+	MinStripeMembers = 1
+
+	MaxLabels        = 16
+	MaxLabelKeyLen   = 32
+	MaxLabelValueLen = 64
+	MaxInfoFieldLen  = 64
 )
 
 var (
@@ -27,23 +39,203 @@ var (
 	ErrInvalidPortCount = errors.New("port count must be 1-16")
 	ErrInvalidNameLen   = errors.New("name length must be 0-64 bytes")
 	ErrMessageTooLarge  = errors.New("message exceeds maximum size")
+	ErrInvalidStripe    = errors.New("stripe info must be present exactly for RSKS hellos, with 1-16 members")
+	ErrInvalidInfo      = errors.New("client info is only carried by version 2 hellos")
+	ErrInvalidInfoField = errors.New("client info fields must be 0-64 bytes")
+	ErrInvalidLabels    = errors.New("at most 16 labels, with 1-32 byte keys and 0-64 byte values")
 )
 
-// Hello represents the HELLO message.
+// Hello represents the HELLO message. Version 1 announces the optional
+// Stripe by the magic and appends it after the name; version 2 uses
+// MagicValue and carries Stripe and Info as extensions after the
+// capabilities.
 type Hello struct {
-	Magic   [4]byte  // "RSK1"
-	Version uint8    // Protocol version
-	Token   []byte   // Authentication token
-	Ports   []uint16 // Ports to claim
-	Name    string   // Client name
+	Magic   [4]byte     // One of the Magic constants, see HelloMagic
+	Version uint8       // Protocol version
+	Token   []byte      // Authentication token
+	Ports   []uint16    // Ports to claim
+	Name    string      // Client name
+	Stripe  *StripeInfo // Striped session membership (version 1: only with MagicStriped)
+	Info    *ClientInfo // Client labels and metadata (version 2 only)
+
+	Capabilities Capabilities // Features the client supports (version 2)
+	BestEffort   bool         // Accept the ports that can be bound instead of none (version 2; not sent in version 1)
+	Extensions   []Extension  // Extensions other than Stripe, Info and BestEffort (version 2)
+}
+
+// StripeInfo identifies one connection of a striped session: several parallel
+// control connections that the server treats as one logical client session.
+// It is appended to the HELLO after the name when the magic is "RSKS".
+type StripeInfo struct {
+	SessionID [16]byte // Client-chosen identifier shared by all members
+	Members   uint8    // Number of connections the client intends to open
+}
+
+// ClientInfo describes a client: labels set by its operator and metadata it
+// collected itself. It is carried in a version 2 HELLO extension.
+type ClientInfo struct {
+	Version  string            // Client software version
+	OS       string            // Operating system, as runtime.GOOS
+	Arch     string            // Architecture, as runtime.GOARCH
+	Hostname string            // Host name of the client machine
+	PublicIP string            // Egress public IP, if known
+	Labels   map[string]string // Operator-defined labels such as region=eu-west
+}
+
+// HelloMagic returns the magic of a version 1 HELLO, which announces whether
+// stripe info follows. Version 2 HELLOs always use MagicValue.
+func HelloMagic(striped bool) [4]byte {
+	if striped {
+		return [4]byte([]byte(MagicStriped))
+	}
+	return [4]byte([]byte(MagicValue))
+}
+
+func validMagic(magic [4]byte) bool {
+	return string(magic[:]) == MagicValue || string(magic[:]) == MagicStriped
+}
+
+func stripedMagic(magic [4]byte) bool {
+	return string(magic[:]) == MagicStriped
+}
+
+func validVersion(version uint8) bool {
+	return version == Version || version == Version2
+}
+
+func validStripe(h Hello) bool {
+	if h.Version == Version && stripedMagic(h.Magic) != (h.Stripe != nil) {
+		return false
+	}
+	return h.Stripe == nil || h.Stripe.Members >= MinStripeMembers && h.Stripe.Members <= MaxStripeMembers
+}
+
+// ValidateLabels checks the number and lengths of labels.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return ErrInvalidLabels
+	}
+	for k, v := range labels {
+		if len(k) < 1 || len(k) > MaxLabelKeyLen || len(v) > MaxLabelValueLen {
+			return ErrInvalidLabels
+		}
+	}
+	return nil
+}
+
+func validateInfo(h Hello) error {
+	if h.Version == Version && h.Info != nil {
+		return ErrInvalidInfo
+	}
+	if h.Info == nil {
+		return nil
+	}
+	for _, field := range h.Info.fields() {
+		if len(field) > MaxInfoFieldLen {
+			return ErrInvalidInfoField
+		}
+	}
+	return ValidateLabels(h.Info.Labels)
+}
+
+func (c *ClientInfo) fields() []string {
+	return []string{c.Version, c.OS, c.Arch, c.Hostname, c.PublicIP}
+}
+
+// writeShortString writes s prefixed by its one-byte length.
+func writeShortString(w io.Writer, s string) error {
+	if _, err := w.Write([]byte{uint8(len(s))}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// readShortString reads a string prefixed by its one-byte length, which must not exceed maxLen.
+func readShortString(r io.Reader, maxLen int, errTooLong error) (string, error) {
+	var n uint8
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if int(n) > maxLen {
+		return "", errTooLong
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// writeInfo encodes the info block: the metadata fields, then the label
+// count and labels sorted by key, all strings with one-byte lengths.
+func writeInfo(w io.Writer, c *ClientInfo) error {
+	for _, field := range c.fields() {
+		if err := writeShortString(w, field); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte{uint8(len(c.Labels))}); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := writeShortString(w, k); err != nil {
+			return err
+		}
+		if err := writeShortString(w, c.Labels[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readInfo(r io.Reader) (*ClientInfo, error) {
+	info := &ClientInfo{}
+	for _, field := range []*string{&info.Version, &info.OS, &info.Arch, &info.Hostname, &info.PublicIP} {
+		var err error
+		if *field, err = readShortString(r, MaxInfoFieldLen, ErrInvalidInfoField); err != nil {
+			return nil, err
+		}
+	}
+
+	var count uint8
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if count > MaxLabels {
+		return nil, ErrInvalidLabels
+	}
+	if count > 0 {
+		info.Labels = make(map[string]string, count)
+	}
+	for range count {
+		k, err := readShortString(r, MaxLabelKeyLen, ErrInvalidLabels)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readShortString(r, MaxLabelValueLen, ErrInvalidLabels)
+		if err != nil {
+			return nil, err
+		}
+		if k == "" {
+			return nil, ErrInvalidLabels
+		}
+		info.Labels[k] = v
+	}
+	return info, nil
 }
 
 // WriteHello encodes and writes a HELLO message.
 func WriteHello(w io.Writer, h Hello) error {
-	if string(h.Magic[:]) != MagicValue {
+	if !validMagic(h.Magic) || h.Version == Version2 && string(h.Magic[:]) != MagicValue {
 		return ErrInvalidMagic
 	}
-	if h.Version != Version {
+	if !validVersion(h.Version) {
 		return ErrInvalidVersion
 	}
 	if len(h.Token) < MinTokenLen || len(h.Token) > MaxTokenLen {
@@ -55,9 +247,27 @@ func WriteHello(w io.Writer, h Hello) error {
 	if len(h.Name) > MaxNameLen {
 		return ErrInvalidNameLen
 	}
+	if !validStripe(h) {
+		return ErrInvalidStripe
+	}
+	if err := validateInfo(h); err != nil {
+		return err
+	}
 
 	// Calculate total size
 	totalSize := 4 + 1 + 1 + len(h.Token) + 1 + len(h.Ports)*2 + 1 + len(h.Name)
+	var exts []Extension
+	if h.Version == Version2 {
+		var err error
+		if exts, err = helloExtensions(h); err != nil {
+			return err
+		}
+		totalSize += 4 + extensionsSize(exts)
+	} else {
+		if h.Stripe != nil {
+			totalSize += len(h.Stripe.SessionID) + 1
+		}
+	}
 	if totalSize > MaxHelloSize {
 		return ErrMessageTooLarge
 	}
@@ -101,6 +311,19 @@ func WriteHello(w io.Writer, h Hello) error {
 		}
 	}
 
+	if h.Version == Version2 {
+		return writeExtensions(w, h.Capabilities, exts)
+	}
+
+	if h.Stripe != nil {
+		if _, err := w.Write(h.Stripe.SessionID[:]); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, h.Stripe.Members); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -111,7 +334,7 @@ func ReadHello(r io.Reader) (Hello, error) {
 	if _, err := io.ReadFull(r, h.Magic[:]); err != nil {
 		return h, err
 	}
-	if string(h.Magic[:]) != MagicValue {
+	if !validMagic(h.Magic) {
 		return h, ErrInvalidMagic
 	}
 
@@ -119,9 +342,12 @@ func ReadHello(r io.Reader) (Hello, error) {
 	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
 		return h, err
 	}
-	if h.Version != Version {
+	if !validVersion(h.Version) {
 		return h, ErrInvalidVersion
 	}
+	if h.Version == Version2 && string(h.Magic[:]) != MagicValue {
+		return h, ErrInvalidMagic
+	}
 
 	// Read TOKEN_LEN (1 byte)
 	var tokenLen uint8
@@ -168,6 +394,38 @@ func ReadHello(r io.Reader) (Hello, error) {
 		h.Name = string(nameBytes)
 	}
 
+	if h.Version == Version2 {
+		caps, exts, err := readExtensions(r)
+		if err != nil {
+			return h, err
+		}
+		h.Capabilities = caps
+		if err := decodeHelloExtensions(&h, exts); err != nil {
+			return h, err
+		}
+		if !validStripe(h) {
+			return h, ErrInvalidStripe
+		}
+		if err := validateInfo(h); err != nil {
+			return h, err
+		}
+		return h, nil
+	}
+
+	if stripedMagic(h.Magic) {
+		stripe := &StripeInfo{}
+		if _, err := io.ReadFull(r, stripe.SessionID[:]); err != nil {
+			return h, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &stripe.Members); err != nil {
+			return h, err
+		}
+		h.Stripe = stripe
+		if !validStripe(h) {
+			return h, ErrInvalidStripe
+		}
+	}
+
 	return h, nil
 }
 
@@ -191,17 +449,29 @@ var (
 	ErrInvalidMessageLen        = errors.New("message length must be 0-255 bytes")
 )
 
-// HelloResp represents the HELLO_RESP message.
+// HelloResp represents the HELLO_RESP message. A server answers a version
+// 2 HELLO with a version 2 response, which appends the capabilities both
+// sides support and an extension area.
 type HelloResp struct {
 	Version       uint8    // Protocol version
 	Status        uint8    // Status code
 	AcceptedPorts []uint16 // Accepted ports
 	Message       string   // Status message
+
+	Capabilities Capabilities    // Features the server and client both support (version 2)
+	Rejected     []PortRejection // Ports of a best-effort HELLO that were not bound (version 2)
+	Extensions   []Extension     // Extensions other than Rejected (version 2)
+}
+
+// PortRejection tells why a port of a best-effort HELLO was not bound.
+type PortRejection struct {
+	Port   uint16
+	Status uint8 // StatusPortForbidden or StatusPortInUse
 }
 
 // WriteHelloResp encodes and writes a HELLO_RESP message.
 func WriteHelloResp(w io.Writer, h HelloResp) error {
-	if h.Version != Version {
+	if !validVersion(h.Version) {
 		return ErrInvalidVersion
 	}
 	if len(h.AcceptedPorts) > MaxAcceptedPortCount {
@@ -241,6 +511,14 @@ func WriteHelloResp(w io.Writer, h HelloResp) error {
 		}
 	}
 
+	if h.Version == Version2 {
+		exts, err := helloRespExtensions(h)
+		if err != nil {
+			return err
+		}
+		return writeExtensions(w, h.Capabilities, exts)
+	}
+
 	return nil
 }
 
@@ -251,7 +529,7 @@ func ReadHelloResp(r io.Reader) (HelloResp, error) {
 	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
 		return h, err
 	}
-	if h.Version != Version {
+	if !validVersion(h.Version) {
 		return h, ErrInvalidVersion
 	}
 
@@ -292,6 +570,17 @@ func ReadHelloResp(r io.Reader) (HelloResp, error) {
 		h.Message = string(msgBytes)
 	}
 
+	if h.Version == Version2 {
+		caps, exts, err := readExtensions(r)
+		if err != nil {
+			return h, err
+		}
+		h.Capabilities = caps
+		if err := decodeHelloRespExtensions(&h, exts); err != nil {
+			return h, err
+		}
+	}
+
 	return h, nil
 }
 
@@ -301,7 +590,8 @@ const (
 )
 
 var (
-	ErrInvalidAddrLen = errors.New("address length must be 1-1024 bytes")
+	ErrInvalidAddrLen     = errors.New("address length must be 1-1024 bytes")
+	ErrInvalidConnectPort = errors.New("connect port must be 0-65535")
 )
 
 // ConnectReq represents the CONNECT_REQ message.
@@ -344,3 +634,85 @@ func ReadConnectReq(r io.Reader) (string, error) {
 
 	return string(addrBytes), nil
 }
+
+// WriteConnectReqPort writes a CONNECT_REQ preceded by the claimed port the
+// connection arrived on (0 if none), as sent when CapConnectPort was negotiated.
+func WriteConnectReqPort(w io.Writer, port int, addr string) error {
+	if port < 0 || port > 65535 {
+		return ErrInvalidConnectPort
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(port)); err != nil {
+		return err
+	}
+	return WriteConnectReq(w, addr)
+}
+
+// ReadConnectReqPort reads a CONNECT_REQ written by WriteConnectReqPort.
+func ReadConnectReqPort(r io.Reader) (int, string, error) {
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return 0, "", err
+	}
+	addr, err := ReadConnectReq(r)
+	return int(port), addr, err
+}
+
+// CONNECT_RESP status codes. Clients that negotiated CapConnectStatus answer
+// every CONNECT_REQ with one of them once the target is dialed; data is
+// relayed only after ConnectOK.
+const (
+	ConnectOK          = 0x00 // Target connected
+	ConnectDenied      = 0x01 // Refused by the client's filter, domain rules or port policy
+	ConnectRefused     = 0x02 // Target refused the connection
+	ConnectNoSuchHost  = 0x03 // Target host name does not resolve
+	ConnectUnreachable = 0x04 // Target not reachable from the client: timeout, no route or failed lookup
+	ConnectQuota       = 0x05 // Client's data quota is used up
+)
+
+// ConnectError is a CONNECT_RESP status other than ConnectOK. Its text
+// follows the wording SOCKS servers map to reply codes.
+type ConnectError struct {
+	Status uint8
+}
+
+func (e *ConnectError) Error() string {
+	switch e.Status {
+	case ConnectDenied:
+		return "connection refused by the exit's policy"
+	case ConnectRefused:
+		return "connection refused by the target"
+	case ConnectNoSuchHost:
+		return "no such host"
+	case ConnectUnreachable:
+		return "target unreachable from the exit"
+	case ConnectQuota:
+		// Sent as "network unreachable", apart from policy and target refusals
+		return "network is unreachable: the exit's data quota is exhausted"
+	}
+	return fmt.Sprintf("connect failed with status %#02x", e.Status)
+}
+
+// ExitFailure reports whether the status shows the client's own network
+// failing, rather than the target or the client's policy turning it down.
+func (e *ConnectError) ExitFailure() bool {
+	return e.Status == ConnectUnreachable
+}
+
+// WriteConnectResp writes a CONNECT_RESP: the status as a single byte.
+func WriteConnectResp(w io.Writer, status uint8) error {
+	_, err := w.Write([]byte{status})
+	return err
+}
+
+// ReadConnectResp reads a CONNECT_RESP. It returns nil for ConnectOK and a
+// *ConnectError for any other status.
+func ReadConnectResp(r io.Reader) error {
+	var status [1]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return err
+	}
+	if status[0] != ConnectOK {
+		return &ConnectError{Status: status[0]}
+	}
+	return nil
+}
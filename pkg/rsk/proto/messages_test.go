@@ -2,6 +2,10 @@ package proto
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -235,6 +239,40 @@ func TestConnectReqRoundTrip(t *testing.T) {
 	}
 }
 
+func TestConnectRespRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, status := range []uint8{ConnectOK, ConnectDenied, ConnectUnreachable, ConnectQuota} {
+		if err := WriteConnectResp(&buf, status); err != nil {
+			t.Fatalf("WriteConnectResp(%d) error = %v", status, err)
+		}
+	}
+
+	if err := ReadConnectResp(&buf); err != nil {
+		t.Errorf("ReadConnectResp() error = %v, want nil for ConnectOK", err)
+	}
+
+	var connErr *ConnectError
+	if err := ReadConnectResp(&buf); !errors.As(err, &connErr) || connErr.Status != ConnectDenied || connErr.ExitFailure() {
+		t.Errorf("ReadConnectResp() error = %v, want denied", err)
+	}
+	if !strings.Contains(connErr.Error(), "refused") {
+		t.Errorf("Denied error %q does not map to a SOCKS refusal", connErr.Error())
+	}
+	if err := ReadConnectResp(&buf); !errors.As(err, &connErr) || !connErr.ExitFailure() {
+		t.Errorf("ReadConnectResp() error = %v, want an exit failure", err)
+	}
+	if err := ReadConnectResp(&buf); !errors.As(err, &connErr) || connErr.Status != ConnectQuota || connErr.ExitFailure() {
+		t.Errorf("ReadConnectResp() error = %v, want quota", err)
+	}
+	if !strings.Contains(connErr.Error(), "network is unreachable") {
+		t.Errorf("Quota error %q does not map to a SOCKS network unreachable reply", connErr.Error())
+	}
+
+	if err := ReadConnectResp(&buf); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadConnectResp() on empty input error = %v, want EOF", err)
+	}
+}
+
 func TestHelloValidation(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -256,13 +294,24 @@ func TestHelloValidation(t *testing.T) {
 			name: "invalid version",
 			hello: Hello{
 				Magic:   [4]byte{'R', 'S', 'K', '1'},
-				Version: 0x02,
+				Version: 0x03,
 				Token:   []byte("token"),
 				Ports:   []uint16{20000},
 				Name:    "test",
 			},
 			wantErr: ErrInvalidVersion,
 		},
+		{
+			name: "version 2 with striped magic",
+			hello: Hello{
+				Magic:   [4]byte{'R', 'S', 'K', 'S'},
+				Version: Version2,
+				Token:   []byte("token"),
+				Ports:   []uint16{20000},
+				Stripe:  &StripeInfo{Members: 2},
+			},
+			wantErr: ErrInvalidMagic,
+		},
 		{
 			name: "token too short",
 			hello: Hello{
@@ -308,3 +357,211 @@ func TestHelloValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestStripedHelloRoundTrip(t *testing.T) {
+	hello := Hello{
+		Magic:   [4]byte{'R', 'S', 'K', 'S'},
+		Version: 0x01,
+		Token:   []byte("test-token-123"),
+		Ports:   []uint16{20000, 20001},
+		Name:    "striped-client",
+		Stripe: &StripeInfo{
+			SessionID: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			Members:   4,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHello(&buf, hello); err != nil {
+		t.Fatalf("WriteHello() error = %v", err)
+	}
+
+	got, err := ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello() error = %v", err)
+	}
+
+	if string(got.Magic[:]) != MagicStriped {
+		t.Errorf("Magic mismatch: got %q, want %q", got.Magic, MagicStriped)
+	}
+	if got.Name != hello.Name {
+		t.Errorf("Name mismatch: got %v, want %v", got.Name, hello.Name)
+	}
+	if got.Stripe == nil {
+		t.Fatal("Stripe missing after round trip")
+	}
+	if *got.Stripe != *hello.Stripe {
+		t.Errorf("Stripe mismatch: got %+v, want %+v", *got.Stripe, *hello.Stripe)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Unread bytes after HELLO: %d", buf.Len())
+	}
+}
+
+func TestStripedHelloValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello Hello
+	}{
+		{
+			name: "striped magic without stripe info",
+			hello: Hello{
+				Magic:   [4]byte{'R', 'S', 'K', 'S'},
+				Version: 0x01,
+				Token:   []byte("token"),
+				Ports:   []uint16{20000},
+			},
+		},
+		{
+			name: "stripe info with plain magic",
+			hello: Hello{
+				Magic:   [4]byte{'R', 'S', 'K', '1'},
+				Version: 0x01,
+				Token:   []byte("token"),
+				Ports:   []uint16{20000},
+				Stripe:  &StripeInfo{Members: 2},
+			},
+		},
+		{
+			name: "too many members",
+			hello: Hello{
+				Magic:   [4]byte{'R', 'S', 'K', 'S'},
+				Version: 0x01,
+				Token:   []byte("token"),
+				Ports:   []uint16{20000},
+				Stripe:  &StripeInfo{Members: MaxStripeMembers + 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHello(&buf, tt.hello); err != ErrInvalidStripe {
+				t.Errorf("WriteHello() error = %v, want %v", err, ErrInvalidStripe)
+			}
+		})
+	}
+}
+
+func TestInfoHelloRoundTrip(t *testing.T) {
+	info := &ClientInfo{
+		Version:  "v1.4.0",
+		OS:       "linux",
+		Arch:     "arm64",
+		Hostname: "exit-fra-1",
+		PublicIP: "203.0.113.7",
+		Labels:   map[string]string{"region": "eu-central", "isp": "acme", "tier": ""},
+	}
+	tests := []struct {
+		name   string
+		stripe *StripeInfo
+	}{
+		{name: "plain", stripe: nil},
+		{name: "striped", stripe: &StripeInfo{SessionID: [16]byte{1}, Members: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := Hello{
+				Magic:        HelloMagic(false),
+				Version:      Version2,
+				Token:        []byte("test-token-123"),
+				Ports:        []uint16{20000},
+				Name:         "exit-fra-1",
+				Stripe:       tt.stripe,
+				Info:         info,
+				Capabilities: CapStriping | CapClientInfo,
+			}
+
+			var buf bytes.Buffer
+			if err := WriteHello(&buf, hello); err != nil {
+				t.Fatalf("WriteHello() error = %v", err)
+			}
+			got, err := ReadHello(&buf)
+			if err != nil {
+				t.Fatalf("ReadHello() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Info, info) {
+				t.Errorf("Info mismatch: got %+v, want %+v", got.Info, info)
+			}
+			if !reflect.DeepEqual(got.Stripe, tt.stripe) {
+				t.Errorf("Stripe mismatch: got %+v, want %+v", got.Stripe, tt.stripe)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("Unread bytes after HELLO: %d", buf.Len())
+			}
+		})
+	}
+}
+
+func TestHelloMagic(t *testing.T) {
+	tests := []struct {
+		striped bool
+		want    string
+	}{
+		{false, MagicValue},
+		{true, MagicStriped},
+	}
+	for _, tt := range tests {
+		if got := HelloMagic(tt.striped); string(got[:]) != tt.want {
+			t.Errorf("HelloMagic(%v) = %q, want %q", tt.striped, got, tt.want)
+		}
+	}
+}
+
+func TestInfoHelloValidation(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := range MaxLabels + 1 {
+		tooMany[string(rune('a'+i))] = "x"
+	}
+	tests := []struct {
+		name    string
+		magic   string
+		version uint8
+		info    *ClientInfo
+		wantErr error
+	}{
+		{name: "info in version 1", magic: MagicValue, version: Version, info: &ClientInfo{}, wantErr: ErrInvalidInfo},
+		{name: "retired info magic", magic: "RSKI", version: Version, info: &ClientInfo{}, wantErr: ErrInvalidMagic},
+		{name: "long hostname", magic: MagicValue, version: Version2, info: &ClientInfo{Hostname: strings.Repeat("h", MaxInfoFieldLen+1)}, wantErr: ErrInvalidInfoField},
+		{name: "empty label key", magic: MagicValue, version: Version2, info: &ClientInfo{Labels: map[string]string{"": "x"}}, wantErr: ErrInvalidLabels},
+		{name: "long label value", magic: MagicValue, version: Version2, info: &ClientInfo{Labels: map[string]string{"k": strings.Repeat("v", MaxLabelValueLen+1)}}, wantErr: ErrInvalidLabels},
+		{name: "too many labels", magic: MagicValue, version: Version2, info: &ClientInfo{Labels: tooMany}, wantErr: ErrInvalidLabels},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := Hello{
+				Magic:   [4]byte([]byte(tt.magic)),
+				Version: tt.version,
+				Token:   []byte("token"),
+				Ports:   []uint16{20000},
+				Info:    tt.info,
+			}
+			var buf bytes.Buffer
+			if err := WriteHello(&buf, hello); err != tt.wantErr {
+				t.Errorf("WriteHello() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnectReqPortRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteConnectReqPort(&buf, 20001, "example.com:443"); err != nil {
+		t.Fatalf("WriteConnectReqPort() error = %v", err)
+	}
+	port, addr, err := ReadConnectReqPort(&buf)
+	if err != nil {
+		t.Fatalf("ReadConnectReqPort() error = %v", err)
+	}
+	if port != 20001 || addr != "example.com:443" {
+		t.Errorf("ReadConnectReqPort() = %d, %q, want 20001, %q", port, addr, "example.com:443")
+	}
+
+	if err := WriteConnectReqPort(&buf, 70000, "example.com:443"); !errors.Is(err, ErrInvalidConnectPort) {
+		t.Errorf("WriteConnectReqPort() error = %v, want ErrInvalidConnectPort", err)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Close reasons recorded in the access log.
+const (
+	CloseReasonConsumer      = "consumer_closed" // SOCKS consumer ended the connection
+	CloseReasonTarget        = "target_closed"   // Target (via the client) ended the connection
+	CloseReasonSession       = "session_closed"  // Client session went away
+	CloseReasonError         = "error"           // Read or write failed
+	CloseReasonDialFailed    = "dial_failed"     // Stream or CONNECT_REQ failed, or the client could not connect to the target
+	CloseReasonLimitReached  = "limit_reached"   // Per-client connection limit reached
+	CloseReasonNoRoute       = "no_route"        // Routing rules rejected the target or its client is not connected
+	CloseReasonClientOffline = "client_offline"  // Port's client is offline and its fallback could not relay
+	CloseReasonBreakerOpen   = "breaker_open"    // Port's circuit breaker is open after repeated failures
+	CloseReasonQuota         = "quota_exhausted" // Client refused the stream because its data quota is used up
+)
+
+// AccessEntry is one line of the access log, describing a single SOCKS connection.
+type AccessEntry struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	DurationMs   int64     `json:"duration_ms"`
+	Consumer     string    `json:"consumer"`
+	Port         int       `json:"port"`
+	ClientName   string    `json:"client_name,omitempty"`
+	ClientID     string    `json:"client_id"`
+	Target       string    `json:"target"`
+	BytesUp      int64     `json:"bytes_up"`   // Consumer to target
+	BytesDown    int64     `json:"bytes_down"` // Target to consumer
+	CloseReason  string    `json:"close_reason"`
+	ErrorMessage string    `json:"error,omitempty"`
+	Fallback     string    `json:"fallback,omitempty"` // Fallback that handled the connection while the port's client was offline
+}
+
+// AccessLog writes AccessEntry records as JSON lines.
+type AccessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog creates an access log writing to w, typically a *common.RotatingFile.
+func NewAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{w: w}
+}
+
+// Record writes entry as a single JSON line.
+func (l *AccessLog) Record(entry AccessEntry) error {
+	entry.DurationMs = entry.End.Sub(entry.Start).Milliseconds()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(line)
+	return err
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"golang.org/x/net/proxy"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAccessLog_Record(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewAccessLog(&buf)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, log.Record(AccessEntry{
+		Start:       start,
+		End:         start.Add(1500 * time.Millisecond),
+		Consumer:    "127.0.0.1:5000",
+		Port:        20001,
+		ClientID:    "id",
+		Target:      "example.com:443",
+		BytesUp:     10,
+		BytesDown:   20,
+		CloseReason: CloseReasonTarget,
+	}))
+
+	assert.True(t, strings.HasSuffix(buf.String(), "}\n"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, float64(1500), got["duration_ms"])
+	assert.Equal(t, "example.com:443", got["target"])
+	assert.Equal(t, "target_closed", got["close_reason"])
+	assert.NotContains(t, got, "error")
+}
+
+func TestSOCKSManager_AccessLog(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var buf syncBuffer
+	socksManager.SetAccessLog(NewAccessLog(&buf))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	release, err := registry.ReservePorts([]int{port})
+	require.NoError(t, err)
+	defer release()
+
+	// go-socks5 expects TCP addresses on the dialed stream, so run yamux over loopback TCP
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = tcpListener.Close() }()
+	clientConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	serverConn, err := tcpListener.Accept()
+	require.NoError(t, err)
+
+	serverSess, err := yamux.Server(serverConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+	defer func() { _ = serverSess.Close() }()
+	clientSess, err := yamux.Client(clientConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+	defer func() { _ = clientSess.Close() }()
+
+	connectAddr := make(chan string, 1)
+
+	// Client side: answer one CONNECT_REQ with a single reply, then close
+	go func() {
+		stream, err := clientSess.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = stream.Close() }()
+		addr, err := proto.ReadConnectReq(stream)
+		if err != nil {
+			return
+		}
+		connectAddr <- addr
+		msg := make([]byte, 4)
+		if _, err := io.ReadFull(stream, msg); err != nil {
+			return
+		}
+		_, _ = stream.Write([]byte("pong!"))
+	}()
+
+	socksListener, err := socksManager.StartListener(port, "127.0.0.1", serverSess)
+	require.NoError(t, err)
+	defer func() { _ = socksListener.Close() }()
+
+	meta := ClientMeta{ClientName: "exit-1", ClientID: "client-uuid"}
+	require.NoError(t, registry.BindSession(port, serverSess, socksListener, meta, 10))
+
+	dialer, err := proxy.SOCKS5("tcp", socksListener.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := dialer.Dial("tcp", "example.com:443")
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	consumerAddr := conn.LocalAddr().String()
+	// Host names reach the client unresolved
+	assert.Equal(t, "example.com:443", <-connectAddr)
+	_ = conn.Close()
+
+	require.Eventually(t, func() bool { return buf.String() != "" }, 2*time.Second, 10*time.Millisecond)
+
+	var entry AccessEntry
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &entry))
+	assert.Equal(t, consumerAddr, entry.Consumer)
+	assert.Equal(t, port, entry.Port)
+	assert.Equal(t, "exit-1", entry.ClientName)
+	assert.Equal(t, "client-uuid", entry.ClientID)
+	assert.Equal(t, "example.com:443", entry.Target)
+	assert.Equal(t, int64(4), entry.BytesUp)
+	assert.Equal(t, int64(5), entry.BytesDown)
+	assert.Equal(t, CloseReasonTarget, entry.CloseReason)
+}
+
+func TestSOCKSManager_AccessLogLimitReached(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var buf syncBuffer
+	socksManager.SetAccessLog(NewAccessLog(&buf))
+
+	port := 20001
+	release, err := registry.ReservePorts([]int{port})
+	require.NoError(t, err)
+	defer release()
+
+	serverSess, _ := newYamuxPair(t)
+	require.NoError(t, registry.BindSession(port, serverSess, &mockNetListener{}, ClientMeta{ClientID: "id"}, 1))
+	require.True(t, registry.IncrementConnections(port))
+
+	dialer := socksManager.createDialer(port, serverSess)
+	_, err = dialer(t.Context(), "tcp", "10.0.0.1:80")
+	require.Error(t, err)
+
+	var entry AccessEntry
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &entry))
+	assert.Equal(t, CloseReasonLimitReached, entry.CloseReason)
+	assert.Equal(t, "10.0.0.1:80", entry.Target)
+	assert.NotEmpty(t, entry.ErrorMessage)
+}
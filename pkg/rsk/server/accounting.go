@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/common"
+)
+
+// Usage export formats.
+const (
+	UsageFormatJSON = "json"
+	UsageFormatCSV  = "csv"
+)
+
+// DirectAccountName is the client name under which connections made by the
+// direct fallback of a port are accounted.
+const DirectAccountName = "(direct)"
+
+// defaultAccountingInterval is how often usage is saved unless configured.
+const defaultAccountingInterval = time.Minute
+
+// UsageRecord holds the traffic of one client on one port during one hour.
+type UsageRecord struct {
+	Client      string    `json:"client"`
+	Port        int       `json:"port"`
+	Hour        time.Time `json:"hour"`
+	Connections int64     `json:"connections"`
+	BytesUp     int64     `json:"bytes_up"`   // Consumer to target
+	BytesDown   int64     `json:"bytes_down"` // Target to consumer
+}
+
+// UsageTotal holds the traffic of one client within a report window. Port is
+// zero for the client's total across all its ports.
+type UsageTotal struct {
+	Client      string `json:"client"`
+	Port        int    `json:"port,omitempty"`
+	Connections int64  `json:"connections"`
+	BytesUp     int64  `json:"bytes_up"`
+	BytesDown   int64  `json:"bytes_down"`
+}
+
+// UsageReport is the traffic within [From, To), with per-port and per-client totals.
+type UsageReport struct {
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Totals []UsageTotal `json:"totals"`
+}
+
+type usageKey struct {
+	client string
+	port   int
+	hour   int64 // Unix time of the start of the hour
+}
+
+// liveUsage is an open connection whose byte counters are folded into the
+// records when usage is read or saved, instead of taking the lock on every
+// read and write.
+type liveUsage struct {
+	client   string
+	port     int
+	up, down *atomic.Int64 // Incremented by the connection
+
+	foldedUp, foldedDown int64 // Already charged to a record, guarded by Accounting.mu
+}
+
+// Accounting counts connections and bytes per client name and port in hourly
+// buckets. Counters are keyed by client name, so they carry over when a
+// client reconnects, and are persisted to a file so restarts keep them.
+type Accounting struct {
+	path      string
+	retention time.Duration
+
+	mu      sync.Mutex
+	records map[usageKey]*UsageRecord
+	live    map[*liveUsage]struct{}
+	dirty   bool
+
+	now func() time.Time // Replaced in tests
+}
+
+// NewAccounting creates an accounting loading earlier usage from path if it
+// exists. Hours older than retention are dropped when saving (0 keeps all).
+// An empty path keeps usage in memory only.
+func NewAccounting(path string, retention time.Duration) (*Accounting, error) {
+	a := &Accounting{
+		path:      path,
+		retention: retention,
+		records:   make(map[usageKey]*UsageRecord),
+		live:      make(map[*liveUsage]struct{}),
+		now:       time.Now,
+	}
+	if path == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return a, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read accounting file: %w", err)
+	}
+
+	var records []UsageRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse accounting file %s: %w", path, err)
+	}
+	for _, r := range records {
+		rec := r
+		a.records[usageKey{client: r.Client, port: r.Port, hour: r.Hour.Unix()}] = &rec
+	}
+	return a, nil
+}
+
+// recordLocked returns the record of the current hour for client and port.
+func (a *Accounting) recordLocked(client string, port int) *UsageRecord {
+	hour := a.now().UTC().Truncate(time.Hour)
+	key := usageKey{client: client, port: port, hour: hour.Unix()}
+	rec, ok := a.records[key]
+	if !ok {
+		rec = &UsageRecord{Client: client, Port: port, Hour: hour}
+		a.records[key] = rec
+	}
+	a.dirty = true
+	return rec
+}
+
+// AddConnection counts a connection relayed for client on port.
+func (a *Accounting) AddConnection(client string, port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.recordLocked(client, port).Connections++
+}
+
+// AddBytes charges bytes relayed for client on port.
+func (a *Accounting) AddBytes(client string, port int, up, down int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addBytesLocked(client, port, up, down)
+}
+
+func (a *Accounting) addBytesLocked(client string, port int, up, down int64) {
+	if up == 0 && down == 0 {
+		return
+	}
+	rec := a.recordLocked(client, port)
+	rec.BytesUp += up
+	rec.BytesDown += down
+}
+
+// track charges the bytes a connection counts in up and down to client on
+// port. They are folded into the hour in which usage is next read or saved,
+// at the latest every save interval, and when untrack is called.
+func (a *Accounting) track(client string, port int, up, down *atomic.Int64) *liveUsage {
+	u := &liveUsage{client: client, port: port, up: up, down: down}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.live[u] = struct{}{}
+	return u
+}
+
+// untrack folds the final bytes of u and stops tracking it.
+func (a *Accounting) untrack(u *liveUsage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.foldLocked(u)
+	delete(a.live, u)
+}
+
+func (a *Accounting) foldLocked(u *liveUsage) {
+	up, down := u.up.Load(), u.down.Load()
+	a.addBytesLocked(u.client, u.port, up-u.foldedUp, down-u.foldedDown)
+	u.foldedUp, u.foldedDown = up, down
+}
+
+// foldAllLocked charges the bytes of all open connections counted so far.
+func (a *Accounting) foldAllLocked() {
+	for u := range a.live {
+		a.foldLocked(u)
+	}
+}
+
+// Report sums the usage of the hours starting within [from, to). A zero from
+// or to leaves that end of the window open.
+func (a *Accounting) Report(from, to time.Time) UsageReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.foldAllLocked()
+
+	ports := make(map[usageKey]*UsageTotal)
+	clients := make(map[string]*UsageTotal)
+	for _, rec := range a.records {
+		if (!from.IsZero() && rec.Hour.Before(from)) || (!to.IsZero() && !rec.Hour.Before(to)) {
+			continue
+		}
+		portKey := usageKey{client: rec.Client, port: rec.Port}
+		for _, total := range []*UsageTotal{
+			lookupTotal(ports, portKey, UsageTotal{Client: rec.Client, Port: rec.Port}),
+			lookupTotal(clients, rec.Client, UsageTotal{Client: rec.Client}),
+		} {
+			total.Connections += rec.Connections
+			total.BytesUp += rec.BytesUp
+			total.BytesDown += rec.BytesDown
+		}
+	}
+
+	report := UsageReport{From: from, To: to, Totals: []UsageTotal{}}
+	for _, total := range ports {
+		report.Totals = append(report.Totals, *total)
+	}
+	for _, total := range clients {
+		report.Totals = append(report.Totals, *total)
+	}
+	// Per client, its ports in order followed by the client total
+	sort.Slice(report.Totals, func(i, j int) bool {
+		ti, tj := report.Totals[i], report.Totals[j]
+		if ti.Client != tj.Client {
+			return ti.Client < tj.Client
+		}
+		if (ti.Port == 0) != (tj.Port == 0) {
+			return tj.Port == 0
+		}
+		return ti.Port < tj.Port
+	})
+	return report
+}
+
+func lookupTotal[K comparable](totals map[K]*UsageTotal, key K, init UsageTotal) *UsageTotal {
+	total, ok := totals[key]
+	if !ok {
+		total = &init
+		totals[key] = total
+	}
+	return total
+}
+
+// Save writes the usage to the accounting file if it changed since the last
+// save, dropping hours past the retention.
+func (a *Accounting) Save() error {
+	a.mu.Lock()
+	a.foldAllLocked()
+	if a.path == "" || !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	if a.retention > 0 {
+		cutoff := a.now().Add(-a.retention)
+		for key, rec := range a.records {
+			if rec.Hour.Add(time.Hour).Before(cutoff) {
+				delete(a.records, key)
+			}
+		}
+	}
+	records := make([]UsageRecord, 0, len(a.records))
+	for _, rec := range a.records {
+		records = append(records, *rec)
+	}
+	a.dirty = false
+	a.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		ri, rj := records[i], records[j]
+		if !ri.Hour.Equal(rj.Hour) {
+			return ri.Hour.Before(rj.Hour)
+		}
+		if ri.Client != rj.Client {
+			return ri.Client < rj.Client
+		}
+		return ri.Port < rj.Port
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		err = common.WriteFileAtomic(a.path, data)
+	}
+	if err != nil {
+		a.mu.Lock()
+		a.dirty = true
+		a.mu.Unlock()
+		return fmt.Errorf("failed to write accounting file: %w", err)
+	}
+	return nil
+}
+
+// Run saves the usage every interval and once more when ctx is canceled.
+func (a *Accounting) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Save(); err != nil {
+				logger.Error("Failed to save accounting", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				logger.Warn("Failed to save accounting", "error", err)
+			}
+		}
+	}
+}
+
+// WriteUsageReport writes report to w in the given format. CSV rows with an
+// empty port hold the client's total across its ports.
+func WriteUsageReport(w io.Writer, report UsageReport, format string) error {
+	switch format {
+	case UsageFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case UsageFormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"client", "port", "connections", "bytes_up", "bytes_down"})
+		for _, total := range report.Totals {
+			port := ""
+			if total.Port != 0 {
+				port = strconv.Itoa(total.Port)
+			}
+			_ = cw.Write([]string{
+				total.Client,
+				port,
+				strconv.FormatInt(total.Connections, 10),
+				strconv.FormatInt(total.BytesUp, 10),
+				strconv.FormatInt(total.BytesDown, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("invalid usage format %q: expected %s or %s", format, UsageFormatJSON, UsageFormatCSV)
+	}
+}
+
+// ParseUsageTime parses a report window bound given as RFC 3339 or as a
+// YYYY-MM-DD date, which stands for midnight UTC. Empty yields the zero time.
+func ParseUsageTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
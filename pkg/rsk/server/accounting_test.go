@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+func TestAccounting_HourlyBuckets(t *testing.T) {
+	a, err := NewAccounting("", 0)
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	a.AddConnection("team-a", 20001)
+	a.AddBytes("team-a", 20001, 100, 1000)
+	a.AddConnection("team-a", 20002)
+	a.AddBytes("team-a", 20002, 10, 20)
+	a.AddConnection("team-b", 20003)
+
+	now = now.Add(time.Hour)
+	a.AddConnection("team-a", 20001)
+	a.AddBytes("team-a", 20001, 1, 2)
+
+	report := a.Report(time.Time{}, time.Time{})
+	assert.Equal(t, []UsageTotal{
+		{Client: "team-a", Port: 20001, Connections: 2, BytesUp: 101, BytesDown: 1002},
+		{Client: "team-a", Port: 20002, Connections: 1, BytesUp: 10, BytesDown: 20},
+		{Client: "team-a", Connections: 3, BytesUp: 111, BytesDown: 1022},
+		{Client: "team-b", Port: 20003, Connections: 1},
+		{Client: "team-b", Connections: 1},
+	}, report.Totals)
+
+	// Only the second hour
+	from := time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)
+	report = a.Report(from, from.Add(time.Hour))
+	assert.Equal(t, []UsageTotal{
+		{Client: "team-a", Port: 20001, Connections: 1, BytesUp: 1, BytesDown: 2},
+		{Client: "team-a", Connections: 1, BytesUp: 1, BytesDown: 2},
+	}, report.Totals)
+
+	// Empty window
+	report = a.Report(from.Add(time.Hour), time.Time{})
+	assert.Empty(t, report.Totals)
+}
+
+func TestAccounting_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	a, err := NewAccounting(path, 0)
+	require.NoError(t, err)
+	a.now = func() time.Time { return now }
+	a.AddConnection("team-a", 20001)
+	a.AddBytes("team-a", 20001, 5, 50)
+	require.NoError(t, a.Save())
+
+	// Usage carries over a restart and keeps accumulating
+	b, err := NewAccounting(path, 0)
+	require.NoError(t, err)
+	b.now = func() time.Time { return now }
+	b.AddBytes("team-a", 20001, 5, 50)
+	assert.Equal(t, []UsageTotal{
+		{Client: "team-a", Port: 20001, Connections: 1, BytesUp: 10, BytesDown: 100},
+		{Client: "team-a", Connections: 1, BytesUp: 10, BytesDown: 100},
+	}, b.Report(time.Time{}, time.Time{}).Totals)
+}
+
+func TestAccounting_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	a, err := NewAccounting(path, 24*time.Hour)
+	require.NoError(t, err)
+	a.now = func() time.Time { return now }
+	a.AddConnection("old", 20001)
+	now = now.Add(48 * time.Hour)
+	a.AddConnection("new", 20002)
+	require.NoError(t, a.Save())
+
+	b, err := NewAccounting(path, 0)
+	require.NoError(t, err)
+	totals := b.Report(time.Time{}, time.Time{}).Totals
+	require.Len(t, totals, 2)
+	assert.Equal(t, "new", totals[0].Client)
+}
+
+func TestAccounting_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	_, err := NewAccounting(path, 0)
+	assert.Error(t, err)
+}
+
+func TestWriteUsageReport(t *testing.T) {
+	report := UsageReport{
+		From: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		Totals: []UsageTotal{
+			{Client: "team-a", Port: 20001, Connections: 2, BytesUp: 10, BytesDown: 20},
+			{Client: "team-a", Connections: 2, BytesUp: 10, BytesDown: 20},
+		},
+	}
+
+	var csvOut bytes.Buffer
+	require.NoError(t, WriteUsageReport(&csvOut, report, UsageFormatCSV))
+	assert.Equal(t, "client,port,connections,bytes_up,bytes_down\n"+
+		"team-a,20001,2,10,20\n"+
+		"team-a,,2,10,20\n", csvOut.String())
+
+	var jsonOut bytes.Buffer
+	require.NoError(t, WriteUsageReport(&jsonOut, report, UsageFormatJSON))
+	var decoded UsageReport
+	require.NoError(t, json.Unmarshal(jsonOut.Bytes(), &decoded))
+	assert.Equal(t, report.Totals, decoded.Totals)
+	assert.True(t, report.From.Equal(decoded.From))
+
+	assert.Error(t, WriteUsageReport(io.Discard, report, "xml"))
+}
+
+func TestParseUsageTime(t *testing.T) {
+	got, err := ParseUsageTime("2026-03-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), got)
+
+	got, err = ParseUsageTime("2026-03-01T12:00:00+02:00")
+	require.NoError(t, err)
+	assert.True(t, got.Equal(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)))
+
+	got, err = ParseUsageTime("")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+
+	_, err = ParseUsageTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestSOCKSManager_Accounting(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	accounting, err := NewAccounting("", 0)
+	require.NoError(t, err)
+	socksManager.SetAccounting(accounting)
+
+	port := 20001
+	release, err := registry.ReservePorts([]int{port})
+	require.NoError(t, err)
+	defer release()
+
+	serverSess, clientSess := newYamuxPair(t)
+	meta := ClientMeta{ClientName: "team-a", ClientID: "id-1"}
+	require.NoError(t, registry.BindSession(port, serverSess, &mockNetListener{}, meta, 10))
+
+	// Client side: echo one message back
+	go func() {
+		stream, err := clientSess.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = stream.Close() }()
+		if _, err := proto.ReadConnectReq(stream); err != nil {
+			return
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			return
+		}
+		_, _ = stream.Write(append(buf, '!'))
+	}()
+
+	conn, err := socksManager.createDialer(port, serverSess)(context.Background(), "tcp", "example.com:443")
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+
+	// Open connections are included in reports
+	want := []UsageTotal{
+		{Client: "team-a", Port: port, Connections: 1, BytesUp: 4, BytesDown: 5},
+		{Client: "team-a", Connections: 1, BytesUp: 4, BytesDown: 5},
+	}
+	assert.Equal(t, want, accounting.Report(time.Time{}, time.Time{}).Totals)
+
+	// Closing does not count the bytes again
+	_ = conn.Close()
+	assert.Equal(t, want, accounting.Report(time.Time{}, time.Time{}).Totals)
+}
+
+func TestUsageHandler(t *testing.T) {
+	a, err := NewAccounting("", 0)
+	require.NoError(t, err)
+	a.AddConnection("team-a", 20001)
+	var up, down atomic.Int64
+	u := a.track("team-a", 20001, &up, &down)
+	defer a.untrack(u)
+	up.Add(7)
+	down.Add(70)
+
+	handler := NewUsageHandler(a)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, UsagePath+"?format=csv", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "client,port,connections,bytes_up,bytes_down\n"+
+		"team-a,20001,1,7,70\n"+
+		"team-a,,1,7,70\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, UsagePath+"?from=2000-01-01", nil))
+	var report UsageReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Len(t, report.Totals, 2)
+
+	for _, query := range []string{"?from=yesterday", "?format=xml"} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, UsagePath+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
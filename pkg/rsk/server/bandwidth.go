@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/tbxark/rsk/pkg/rsk/common"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimit caps throughput in bytes per second. Zero means unlimited.
+type BandwidthLimit struct {
+	Up   int64 // Consumer to target
+	Down int64 // Target to consumer
+}
+
+// IsZero reports whether the limit leaves both directions unlimited.
+func (l BandwidthLimit) IsZero() bool {
+	return l.Up <= 0 && l.Down <= 0
+}
+
+// BandwidthLimiter throttles SOCKS connections with token buckets shared by
+// all connections on a port and by all ports of a client. Clients are told
+// apart by name, so reconnecting, or connecting twice, does not earn a
+// client a fresh allowance. Buckets live until the last session using them
+// closes; those of direct fallback connections live as long as the limiter.
+type BandwidthLimiter struct {
+	port      BandwidthLimit            // Applies to each port
+	client    BandwidthLimit            // Applies to each client across its ports
+	overrides map[string]BandwidthLimit // Client limits by client name
+
+	mu      sync.Mutex
+	buckets map[string]*bucketPair
+}
+
+// bucketPair holds the token buckets of one scope; nil means unlimited.
+type bucketPair struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+
+	sessions map[transport.Session]struct{} // Open sessions using the buckets
+}
+
+// NewBandwidthLimiter creates a limiter with per-port and per-client limits.
+// overrides replaces the per-client limit for the named clients.
+func NewBandwidthLimiter(port, client BandwidthLimit, overrides map[string]BandwidthLimit) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		port:      port,
+		client:    client,
+		overrides: overrides,
+		buckets:   make(map[string]*bucketPair),
+	}
+}
+
+// Wrap returns conn throttled by the buckets of its port and client, or conn
+// itself if no limit applies to it.
+func (b *BandwidthLimiter) Wrap(conn net.Conn, port int, meta ClientMeta, sess transport.Session) net.Conn {
+	clientLimit := b.client
+	if override, ok := b.overrides[meta.ClientName]; ok {
+		clientLimit = override
+	}
+	if b.port.IsZero() && clientLimit.IsZero() {
+		return conn
+	}
+
+	portBuckets := b.bucketsFor(fmt.Sprintf("port/%d", port), b.port, sess)
+	clientBuckets := b.bucketsFor("client/"+meta.ClientName, clientLimit, sess)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rateLimitedConn{
+		Conn:   conn,
+		up:     nonNil(portBuckets.up, clientBuckets.up),
+		down:   nonNil(portBuckets.down, clientBuckets.down),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// WrapDirect returns conn, opened by the direct fallback of port, throttled
+// by the port limit, or conn itself without one. Direct connections of a
+// port share buckets of their own, which live as long as the limiter.
+func (b *BandwidthLimiter) WrapDirect(conn net.Conn, port int) net.Conn {
+	if b.port.IsZero() {
+		return conn
+	}
+	buckets := b.bucketsFor(fmt.Sprintf("port/%d/direct", port), b.port, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rateLimitedConn{
+		Conn:   conn,
+		up:     nonNil(buckets.up),
+		down:   nonNil(buckets.down),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// bucketsFor returns the buckets stored under key, creating them for limit if
+// needed. Buckets are dropped once every session that asked for them has
+// closed, or kept for good if sess is nil.
+func (b *BandwidthLimiter) bucketsFor(key string, limit BandwidthLimit, sess transport.Session) *bucketPair {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pair, ok := b.buckets[key]
+	if !ok {
+		pair = &bucketPair{
+			up:       newBucket(limit.Up),
+			down:     newBucket(limit.Down),
+			sessions: make(map[transport.Session]struct{}),
+		}
+		b.buckets[key] = pair
+	}
+	if sess == nil {
+		return pair
+	}
+	if _, held := pair.sessions[sess]; held {
+		return pair
+	}
+
+	pair.sessions[sess] = struct{}{}
+	go func() {
+		<-sess.CloseChan()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(pair.sessions, sess)
+		if len(pair.sessions) == 0 && b.buckets[key] == pair {
+			delete(b.buckets, key)
+		}
+	}()
+	return pair
+}
+
+// newBucket creates a token bucket allowing bytesPerSec with a burst of one
+// second of traffic, or nil for an unlimited direction.
+func newBucket(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+func nonNil(limiters ...*rate.Limiter) []*rate.Limiter {
+	var out []*rate.Limiter
+	for _, l := range limiters {
+		if l != nil {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// rateLimitedConn throttles writes (upload) and reads (download) through
+// token buckets. Closing it aborts any wait.
+type rateLimitedConn struct {
+	net.Conn
+	up     []*rate.Limiter
+	down   []*rate.Limiter
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	if len(c.down) == 0 {
+		return c.Conn.Read(b)
+	}
+	if chunk := maxChunk(c.down); len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if waitErr := waitN(c.ctx, c.down, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	if len(c.up) == 0 {
+		return c.Conn.Write(b)
+	}
+	chunk := maxChunk(c.up)
+	written := 0
+	for written < len(b) {
+		end := min(written+chunk, len(b))
+		if err := waitN(c.ctx, c.up, end-written); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite passes the half-close from the SOCKS server through to the stream.
+func (c *rateLimitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *rateLimitedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// maxChunk returns the largest number of bytes every limiter can grant at once.
+func maxChunk(limiters []*rate.Limiter) int {
+	chunk := limiters[0].Burst()
+	for _, l := range limiters[1:] {
+		chunk = min(chunk, l.Burst())
+	}
+	return chunk
+}
+
+func waitN(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return net.ErrClosed
+		}
+	}
+	return nil
+}
+
+// ParseBandwidthOverrides parses comma-separated per-client limits given as
+// "name=up/down" entries, such as "bulk=1M/10M,trusted=0/0".
+func ParseBandwidthOverrides(s string) (map[string]BandwidthLimit, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	overrides := make(map[string]BandwidthLimit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		name, limits, ok := strings.Cut(entry, "=")
+		upStr, downStr, ok2 := strings.Cut(limits, "/")
+		if !ok || !ok2 || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid client bandwidth %q: expected name=up/down", entry)
+		}
+
+		up, err := common.ParseByteSize(upStr)
+		if err != nil {
+			return nil, err
+		}
+		down, err := common.ParseByteSize(downStr)
+		if err != nil {
+			return nil, err
+		}
+		overrides[strings.TrimSpace(name)] = BandwidthLimit{Up: up, Down: down}
+	}
+	return overrides, nil
+}
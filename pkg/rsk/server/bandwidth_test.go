@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeWithPeer returns one end of a pipe whose other end writes size bytes
+// and then closes, and reads everything written to it.
+func pipeWithPeer(t *testing.T, size int) net.Conn {
+	t.Helper()
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+	go func() {
+		_, _ = remote.Write(make([]byte, size))
+		_ = remote.Close()
+	}()
+	return local
+}
+
+func TestBandwidthLimiter_ThrottlesDownload(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{Down: 64 * 1024}, BandwidthLimit{}, nil)
+	meta := ClientMeta{ClientName: "exit", ClientID: "id-1"}
+
+	// The first 64 KiB pass as burst, the next 32 KiB take half a second
+	conn := limiter.Wrap(pipeWithPeer(t, 96*1024), 20001, meta, sess)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, conn)
+	require.NoError(t, err)
+	assert.Equal(t, int64(96*1024), n)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestBandwidthLimiter_ThrottlesUpload(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{}, BandwidthLimit{Up: 64 * 1024}, nil)
+	meta := ClientMeta{ClientName: "exit", ClientID: "id-1"}
+
+	conn := limiter.Wrap(pipeWithPeer(t, 0), 20001, meta, sess)
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 96*1024))
+	require.NoError(t, err)
+	assert.Equal(t, 96*1024, n)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestBandwidthLimiter_SharesBucketsPerPortAndClient(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{Up: 1000}, BandwidthLimit{Down: 2000}, nil)
+	meta := ClientMeta{ClientName: "exit", ClientID: "id-1"}
+
+	a := limiter.Wrap(pipeWithPeer(t, 0), 20001, meta, sess).(*rateLimitedConn)
+	b := limiter.Wrap(pipeWithPeer(t, 0), 20001, meta, sess).(*rateLimitedConn)
+	c := limiter.Wrap(pipeWithPeer(t, 0), 20002, meta, sess).(*rateLimitedConn)
+
+	// Connections on one port share the port bucket; other ports get their own
+	require.Len(t, a.up, 1)
+	assert.Same(t, a.up[0], b.up[0])
+	assert.NotSame(t, a.up[0], c.up[0])
+
+	// All ports of the client share the client bucket
+	require.Len(t, a.down, 1)
+	assert.Same(t, a.down[0], c.down[0])
+}
+
+func TestBandwidthLimiter_Overrides(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{}, BandwidthLimit{Up: 1000, Down: 1000},
+		map[string]BandwidthLimit{"trusted": {}, "bulk": {Down: 500}})
+
+	raw := pipeWithPeer(t, 0)
+	assert.Same(t, raw, limiter.Wrap(raw, 20001, ClientMeta{ClientName: "trusted", ClientID: "id-1"}, sess),
+		"a client overridden to unlimited is not wrapped")
+
+	bulk := limiter.Wrap(pipeWithPeer(t, 0), 20002, ClientMeta{ClientName: "bulk", ClientID: "id-2"}, sess).(*rateLimitedConn)
+	assert.Empty(t, bulk.up)
+	require.Len(t, bulk.down, 1)
+	assert.Equal(t, 500, bulk.down[0].Burst())
+}
+
+func TestBandwidthLimiter_DropsBucketsWithSession(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{Up: 1000}, BandwidthLimit{Up: 1000}, nil)
+	limiter.Wrap(pipeWithPeer(t, 0), 20001, ClientMeta{ClientID: "id-1"}, sess)
+
+	limiter.mu.Lock()
+	assert.Len(t, limiter.buckets, 2)
+	limiter.mu.Unlock()
+
+	require.NoError(t, sess.Close())
+	assert.Eventually(t, func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.buckets) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBandwidthLimiter_SharesBucketsAcrossSessions(t *testing.T) {
+	first, _ := newYamuxPair(t)
+	second, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{}, BandwidthLimit{Down: 1000}, nil)
+
+	// A client reconnecting under the same name gets a new ID but keeps its bucket
+	a := limiter.Wrap(pipeWithPeer(t, 0), 20001, ClientMeta{ClientName: "exit", ClientID: "id-1"}, first).(*rateLimitedConn)
+	b := limiter.Wrap(pipeWithPeer(t, 0), 20002, ClientMeta{ClientName: "exit", ClientID: "id-2"}, second).(*rateLimitedConn)
+	require.Len(t, a.down, 1)
+	assert.Same(t, a.down[0], b.down[0])
+
+	// The bucket outlives the first session while the second is open
+	require.NoError(t, first.Close())
+	time.Sleep(50 * time.Millisecond)
+	c := limiter.Wrap(pipeWithPeer(t, 0), 20002, ClientMeta{ClientName: "exit", ClientID: "id-2"}, second).(*rateLimitedConn)
+	assert.Same(t, a.down[0], c.down[0])
+
+	require.NoError(t, second.Close())
+	assert.Eventually(t, func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.buckets) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRateLimitedConn_CloseAbortsWait(t *testing.T) {
+	sess, _ := newYamuxPair(t)
+	limiter := NewBandwidthLimiter(BandwidthLimit{Up: 10}, BandwidthLimit{}, nil)
+	conn := limiter.Wrap(pipeWithPeer(t, 0), 20001, ClientMeta{ClientID: "id-1"}, sess)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1000))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after Close")
+	}
+}
+
+func TestParseBandwidthOverrides(t *testing.T) {
+	overrides, err := ParseBandwidthOverrides("bulk=1M/10M, trusted=0/0")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]BandwidthLimit{
+		"bulk":    {Up: 1 << 20, Down: 10 << 20},
+		"trusted": {},
+	}, overrides)
+
+	_, err = ParseBandwidthOverrides("bulk=1M")
+	assert.Error(t, err)
+	_, err = ParseBandwidthOverrides("=1M/1M")
+	assert.Error(t, err)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"    // Connections pass
+	BreakerOpen     = "open"      // Connections fail fast until the cool-down ends
+	BreakerHalfOpen = "half_open" // One trial connection decides whether to close again
+)
+
+// defaultBreakerCoolDown is used when no cool-down is configured.
+const defaultBreakerCoolDown = 30 * time.Second
+
+// breakerTrialTimeout bounds how long a half-open trial stays undecided. A
+// trial that has neither answered nor failed by then, such as an idle
+// connection whose consumer never sends, is abandoned and the next
+// connection becomes the trial.
+const breakerTrialTimeout = 15 * time.Second
+
+// CircuitBreaker tracks the outcome of connections through one slot. After
+// threshold failures in a row it opens and fails connections fast for the
+// cool-down, then lets a single trial connection through.
+type CircuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int       // Failures in a row while closed
+	openedAt time.Time // When the breaker last opened
+	trial    bool      // Whether the half-open trial connection is in flight
+	trialAt  time.Time // When the trial started
+
+	now func() time.Time // Replaced in tests
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(threshold int, coolDown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		state:     BreakerClosed,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a connection may be attempted. Once the cool-down
+// has ended, the first caller becomes the trial connection and must report
+// its outcome within breakerTrialTimeout.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.readyLocked() {
+		return false
+	}
+	if b.state != BreakerClosed {
+		b.state = BreakerHalfOpen
+		b.trial = true
+		b.trialAt = b.now()
+	}
+	return true
+}
+
+// Ready reports whether Allow would let a connection through, without
+// claiming the trial.
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readyLocked()
+}
+
+func (b *CircuitBreaker) readyLocked() bool {
+	switch b.state {
+	case BreakerOpen:
+		return b.now().Sub(b.openedAt) >= b.coolDown
+	case BreakerHalfOpen:
+		return !b.trial || b.now().Sub(b.trialAt) >= breakerTrialTimeout
+	default:
+		return true
+	}
+}
+
+// Success records a working connection and reports whether it closed the breaker.
+func (b *CircuitBreaker) Success() (recovered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recovered = b.state != BreakerClosed
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+	return recovered
+}
+
+// Failure records a failed connection and reports whether it opened the breaker.
+func (b *CircuitBreaker) Failure() (tripped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.trial = false
+	case BreakerClosed:
+		b.failures++
+		if b.failures < b.threshold {
+			return false
+		}
+	default:
+		return false
+	}
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.failures = 0
+	return true
+}
+
+// Cancel records a connection whose outcome is unknown, such as one the
+// consumer closed before the target answered. A trial may be retried.
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// State returns the current state; an open breaker whose cool-down has
+// ended is reported as half-open.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.readyLocked() {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// A success resets the failures in a row
+	assert.False(t, b.Failure())
+	assert.False(t, b.Failure())
+	assert.False(t, b.Success())
+	assert.False(t, b.Failure())
+	assert.False(t, b.Failure())
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.True(t, b.Failure())
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Ready())
+	assert.False(t, b.Allow())
+
+	// After the cool-down exactly one trial passes
+	now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.True(t, b.Ready())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// A canceled trial may be retried; a failed one reopens the breaker
+	b.Cancel()
+	assert.True(t, b.Allow())
+	assert.True(t, b.Failure())
+	assert.Equal(t, BreakerOpen, b.State())
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Success())
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.True(t, b.Allow())
+}
+
+func TestCircuitBreaker_TrialTimeout(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Failure())
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+
+	// An idle trial blocks the port only until it times out
+	now = now.Add(breakerTrialTimeout - time.Second)
+	assert.False(t, b.Allow())
+	now = now.Add(time.Second)
+	assert.True(t, b.Ready())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestRegistry_FindClientSkipsOpenBreakers(t *testing.T) {
+	registry := NewRegistry()
+	registry.EnableBreakers(1, time.Hour)
+	s1, _ := newYamuxPair(t)
+	s2, _ := newYamuxPair(t)
+
+	_, err := registry.ReservePorts([]int{20001, 20002})
+	require.NoError(t, err)
+	require.NoError(t, registry.BindSession(20001, s1, &mockNetListener{}, ClientMeta{ClientName: "exit"}, 10))
+	require.NoError(t, registry.BindSession(20002, s2, &mockNetListener{}, ClientMeta{ClientName: "exit"}, 10))
+
+	require.True(t, registry.Breaker(20001).Failure())
+
+	port, sess, ok := registry.FindClient("exit")
+	require.True(t, ok)
+	assert.Equal(t, 20002, port)
+	assert.Equal(t, s2, sess)
+	assert.Equal(t, BreakerOpen, registry.Status()[0].Breaker)
+	assert.Equal(t, BreakerClosed, registry.Status()[1].Breaker)
+
+	// Without breakers, slots report none
+	assert.Nil(t, NewRegistry().Breaker(20001))
+}
+
+// serveConnectStatus answers CONNECT_REQs on sess like a client reporting
+// connect status, with the status status returns for the target. Streams
+// answered with ConnectOK are kept open until the server closes them.
+func serveConnectStatus(sess *yamux.Session, status func(addr string) uint8) {
+	for {
+		stream, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = stream.Close() }()
+			addr, err := proto.ReadConnectReq(stream)
+			if err != nil {
+				return
+			}
+			code := status(addr)
+			if err := proto.WriteConnectResp(stream, code); err != nil || code != proto.ConnectOK {
+				return
+			}
+			_, _ = io.Copy(io.Discard, stream)
+		}()
+	}
+}
+
+// startBreakerTestPort binds a free port to serverSess for a client with caps.
+func startBreakerTestPort(t *testing.T, registry *Registry, socksManager *SOCKSManager, serverSess *yamux.Session, caps proto.Capabilities) int {
+	t.Helper()
+	port := freePort(t)
+	_, err := registry.ReservePorts([]int{port})
+	require.NoError(t, err)
+	listener, err := socksManager.StartListener(port, "127.0.0.1", serverSess)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	require.NoError(t, registry.BindSession(port, serverSess, listener, ClientMeta{ClientName: "exit", Capabilities: caps}, 10))
+	return port
+}
+
+func TestSOCKSManager_Breaker(t *testing.T) {
+	registry := NewRegistry()
+	registry.EnableBreakers(2, time.Hour)
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var buf syncBuffer
+	socksManager.SetAccessLog(NewAccessLog(&buf))
+
+	// The client's policy denies port 25, its quota is used up for port 26
+	// and its uplink cannot reach anything else
+	serverSess, clientSess := newTCPYamuxPair(t)
+	go serveConnectStatus(clientSess, func(addr string) uint8 {
+		switch {
+		case strings.HasSuffix(addr, ":25"):
+			return proto.ConnectDenied
+		case strings.HasSuffix(addr, ":26"):
+			return proto.ConnectQuota
+		}
+		return proto.ConnectUnreachable
+	})
+	port := startBreakerTestPort(t, registry, socksManager, serverSess, proto.CapConnectStatus)
+
+	// Denied targets and an exhausted quota say nothing about the exit and
+	// never trip the breaker
+	for range 3 {
+		_, err := dialSOCKS(t, port, "mail.example.com:25")
+		assert.Error(t, err)
+		_, err = dialSOCKS(t, port, "example.com:26")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, BreakerClosed, registry.Breaker(port).State())
+	require.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), `"close_reason":"quota_exhausted"`)
+	}, time.Second, 10*time.Millisecond)
+
+	for range 2 {
+		_, err := dialSOCKS(t, port, "example.com:443")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, BreakerOpen, registry.Breaker(port).State())
+
+	// Connections now fail fast without reaching the client
+	streams := serverSess.NumStreams()
+	_, err := dialSOCKS(t, port, "example.com:443")
+	assert.Error(t, err)
+	assert.Equal(t, streams, serverSess.NumStreams())
+	require.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), `"close_reason":"breaker_open"`)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSOCKSManager_BreakerWithoutConnectStatus(t *testing.T) {
+	registry := NewRegistry()
+	registry.EnableBreakers(2, time.Hour)
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Older clients close the stream for denied and unreachable targets
+	// alike, so closing without data is not counted as a failure
+	serverSess, clientSess := newTCPYamuxPair(t)
+	go serveProbes(clientSess, false)
+	port := startBreakerTestPort(t, registry, socksManager, serverSess, 0)
+
+	for range 3 {
+		conn, err := dialSOCKS(t, port, "example.com:443")
+		require.NoError(t, err)
+		_, _ = io.ReadAll(conn)
+		_ = conn.Close()
+	}
+	assert.Equal(t, BreakerClosed, registry.Breaker(port).State())
+}
+
+func TestSOCKSManager_ConnectPort(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The client learns which claimed port each connection arrived on
+	serverSess, clientSess := newTCPYamuxPair(t)
+	ports := make(chan int, 1)
+	go func() {
+		stream, err := clientSess.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = stream.Close() }()
+		port, _, err := proto.ReadConnectReqPort(stream)
+		if err != nil {
+			return
+		}
+		ports <- port
+		_ = proto.WriteConnectResp(stream, proto.ConnectRefused)
+	}()
+	port := startBreakerTestPort(t, registry, socksManager, serverSess, proto.CapConnectStatus|proto.CapConnectPort)
+
+	_, err := dialSOCKS(t, port, "example.com:443")
+	assert.Error(t, err)
+	select {
+	case got := <-ports:
+		assert.Equal(t, port, got)
+	case <-time.After(time.Second):
+		t.Fatal("client received no CONNECT_REQ")
+	}
+}
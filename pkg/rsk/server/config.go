@@ -1,7 +1,9 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +23,71 @@ type Config struct {
 	MaxAuthFailures   int           `validate:"required,min=1"`
 	AuthBlockDuration time.Duration `validate:"required,min=1ms"`
 	MaxConnsPerClient int           `validate:"required,min=1"`
+
+	// Optional WebSocket transport for clients that can only reach the server over HTTP(S).
+	WebSocketListenAddr string // Address for the HTTP listener (empty disables WebSocket)
+	WebSocketPath       string // HTTP path serving the handshake (default: /rsk)
+
+	// Optional QUIC transport, served on a separate UDP listener. Requires TLS.
+	QUICListenAddr string // UDP address for the QUIC listener (empty disables QUIC)
+
+	TLSCertFile string `validate:"required_with=TLSKeyFile"`  // Certificate for TLS listeners
+	TLSKeyFile  string `validate:"required_with=TLSCertFile"` // Private key for TLS listeners
+
+	// Optional SOCKS access log, one JSON line per connection.
+	AccessLogFile       string        // Path of the access log (empty disables it)
+	AccessLogMaxSize    int64         `validate:"min=0"` // Rotate after this many bytes (0 disables size rotation)
+	AccessLogMaxAge     time.Duration `validate:"min=0"` // Rotate files older than this (0 disables time rotation)
+	AccessLogMaxBackups int           `validate:"min=0"` // Rotated files to keep (0 keeps all)
+
+	// Optional bandwidth limits in bytes per second (0 is unlimited).
+	PortBandwidth            BandwidthLimit            // Shared by all connections on one port
+	ClientBandwidth          BandwidthLimit            // Shared by all ports of one client
+	ClientBandwidthOverrides map[string]BandwidthLimit // ClientBandwidth by client name
+
+	// Health checks of client sessions; unhealthy clients are skipped by routing.
+	HealthCheckInterval    time.Duration `validate:"min=0"` // Time between probes (0 disables health checks)
+	HealthCheckTimeout     time.Duration `validate:"min=0"` // Bounds each ping and test CONNECT (default 20s)
+	HealthCheckTarget      string        // host:port each client is asked to CONNECT to (empty only pings)
+	HealthDegradedRTT      time.Duration `validate:"min=0"` // Ping round trip above which a client is degraded (0 disables)
+	HealthFailureThreshold int           `validate:"min=0"` // Failed probes in a row before a client is unhealthy (default 2)
+
+	// Optional circuit breakers per bound port. A port whose connections keep
+	// failing fails new ones fast, or uses its fallback, for the cool-down.
+	BreakerThreshold int           `validate:"min=0"` // Failed connections in a row opening a breaker (0 disables breakers)
+	BreakerCoolDown  time.Duration `validate:"min=0"` // How long an open breaker waits before a trial connection (default 30s)
+
+	// Optional HTTP endpoint serving the status of bound ports as JSON.
+	StatusListenAddr string // Address of the status listener (empty disables it)
+
+	// Optional fallbacks for ports whose client is offline. Ports with a
+	// fallback keep a SOCKS5 listener while no client holds them.
+	PortFallbacks              map[int]PortFallback // Fallback by port
+	DirectAllowPrivateNetworks bool                 // Let direct fallback reach private networks
+	DirectBlockedNetworks      []string             // CIDR blocks direct fallback never reaches
+
+	// Optional routing entry port choosing the client by destination.
+	RouteListenAddr string // Address of the routing SOCKS5 listener (empty disables routing)
+	RouteRulesFile  string `validate:"required_with=RouteListenAddr"` // File with one "kind value action" rule per line
+	RouteFallback   string // Client name or label selector for targets no rule matches, or "reject" (default)
+
+	// Optional traffic accounting per client name and port, in hourly buckets.
+	AccountingFile      string        // File persisting usage across restarts (empty disables accounting)
+	AccountingInterval  time.Duration `validate:"min=0"` // How often usage is saved (default 1m)
+	AccountingRetention time.Duration `validate:"min=0"` // Hours older than this are dropped (0 keeps all)
+}
+
+// HasBandwidthLimits reports whether any bandwidth limit is configured.
+func (c *Config) HasBandwidthLimits() bool {
+	if !c.PortBandwidth.IsZero() || !c.ClientBandwidth.IsZero() {
+		return true
+	}
+	for _, limit := range c.ClientBandwidthOverrides {
+		if !limit.IsZero() {
+			return true
+		}
+	}
+	return false
 }
 
 var validate = validator.New()
@@ -35,9 +102,86 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("token validation failed: %w", err)
 	}
 
+	if c.WebSocketPath != "" && !strings.HasPrefix(c.WebSocketPath, "/") {
+		return fmt.Errorf("websocket path %q must start with /", c.WebSocketPath)
+	}
+
+	if c.QUICListenAddr != "" && !c.TLSEnabled() {
+		return fmt.Errorf("QUIC transport requires a TLS certificate and key")
+	}
+
+	if c.HealthCheckTarget != "" {
+		if _, _, err := net.SplitHostPort(c.HealthCheckTarget); err != nil {
+			return fmt.Errorf("invalid health check target %q: %w", c.HealthCheckTarget, err)
+		}
+	}
+
+	if c.RouteFallback != "" && c.RouteFallback != RouteReject {
+		if _, err := ParseClientSelector(c.RouteFallback); err != nil {
+			return fmt.Errorf("invalid route fallback: %w", err)
+		}
+	}
+
+	for port, fallback := range c.PortFallbacks {
+		if err := c.validateFallback(port, fallback); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range c.DirectBlockedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR block %q: %w", cidr, err)
+		}
+	}
+
+	limits := []BandwidthLimit{c.PortBandwidth, c.ClientBandwidth}
+	for _, limit := range c.ClientBandwidthOverrides {
+		limits = append(limits, limit)
+	}
+	for _, limit := range limits {
+		if limit.Up < 0 || limit.Down < 0 {
+			return fmt.Errorf("bandwidth limits must not be negative")
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateFallback(port int, fallback PortFallback) error {
+	if port < c.PortMin || port > c.PortMax {
+		return fmt.Errorf("fallback port %d outside port range %d-%d", port, c.PortMin, c.PortMax)
+	}
+	switch fallback.Mode {
+	case FallbackReject, FallbackDirect:
+	case FallbackPort:
+		if fallback.Port == port {
+			return fmt.Errorf("port %d cannot fall back to itself", port)
+		}
+		if fallback.Port < c.PortMin || fallback.Port > c.PortMax {
+			return fmt.Errorf("fallback target port %d outside port range %d-%d", fallback.Port, c.PortMin, c.PortMax)
+		}
+	default:
+		return fmt.Errorf("invalid fallback %q for port %d", fallback.Mode, port)
+	}
 	return nil
 }
 
+// TLSEnabled reports whether a certificate and key are configured.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// LoadTLSConfig loads the configured certificate and key into a TLS server configuration.
+func (c *Config) LoadTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 // ParsePortRange parses a port range string in the format "min-max".
 func ParsePortRange(portRange string) (int, int, error) {
 	parts := strings.Split(portRange, "-")
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// controlStreams tracks the control streams of one client session. A striped
+// session has one per member connection; requests use the newest live one.
+type controlStreams struct {
+	mu    sync.Mutex
+	conns []*proto.ControlConn
+}
+
+func (s *controlStreams) add(conn *proto.ControlConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns = append(s.conns, conn)
+}
+
+func (s *controlStreams) remove(conn *proto.ControlConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns = slices.DeleteFunc(s.conns, func(c *proto.ControlConn) bool { return c == conn })
+}
+
+// current returns the newest control stream, or nil if there is none.
+func (s *controlStreams) current() *proto.ControlConn {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) == 0 {
+		return nil
+	}
+	return s.conns[len(s.conns)-1]
+}
+
+// serveControlStreams accepts the control streams a client opens on sess
+// and serves them with handler until the session closes. Each member
+// connection of a striped session opens exactly one; clients open no other
+// streams, so any further stream is closed right away.
+func serveControlStreams(sess transport.Session, streams *controlStreams, handler proto.ControlHandler, meta ClientMeta, logger *slog.Logger) {
+	logger = logger.With("client_id", meta.ClientID, "client_name", meta.ClientName)
+	served := make(map[transport.Session]bool) // Members with a control stream
+	for {
+		stream, member, err := acceptMember(sess)
+		if err != nil {
+			return
+		}
+
+		for m := range served {
+			if m.IsClosed() {
+				delete(served, m)
+			}
+		}
+		if served[member] {
+			logger.Warn("Rejecting extra control stream")
+			_ = stream.Close()
+			continue
+		}
+		served[member] = true
+
+		conn := proto.NewControlConn(stream, handler)
+		streams.add(conn)
+		logger.Debug("Control stream opened")
+
+		go func() {
+			err := conn.Serve()
+			streams.remove(conn)
+			logger.Debug("Control stream closed", "error", err)
+		}()
+	}
+}
+
+// acceptMember accepts the next stream on sess and returns it with the
+// member connection it was opened on, which is sess itself unless sess is a
+// striped session.
+func acceptMember(sess transport.Session) (net.Conn, transport.Session, error) {
+	if group, ok := sess.(*sessionGroup); ok {
+		return group.acceptMember()
+	}
+	stream, err := sess.Accept()
+	return stream, sess, err
+}
+
+// ControlResult is the outcome of a control request sent to one client.
+type ControlResult struct {
+	ClientName string          `json:"client_name"`
+	ClientID   string          `json:"client_id"`
+	Ports      []int           `json:"ports"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// CallClients sends a control request to every client bound to a port, or
+// only to those named name if it is not empty, and collects the answers.
+// Clients without a control stream are reported with an error.
+func CallClients(ctx context.Context, registry *Registry, name, method string) []ControlResult {
+	clients := registry.Controls()
+
+	results := make([]ControlResult, 0, len(clients))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, client := range clients {
+		if name != "" && client.Meta.ClientName != name {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := ControlResult{
+				ClientName: client.Meta.ClientName,
+				ClientID:   client.Meta.ClientID,
+				Ports:      client.Ports,
+			}
+			if conn := client.streams.current(); conn == nil {
+				result.Error = "client has no control stream"
+			} else if err := conn.Call(ctx, method, nil, &result.Result); err != nil {
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b ControlResult) int { return a.Ports[0] - b.Ports[0] })
+	return results
+}
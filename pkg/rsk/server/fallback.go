@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/client"
+)
+
+// Fallback modes for ports whose client is offline.
+const (
+	FallbackReject = "reject" // Refuse connections with a SOCKS failure reply
+	FallbackDirect = "direct" // Dial targets from the server itself
+	FallbackPort   = "port"   // Relay through the client of another port
+)
+
+// directDialTimeout bounds resolving and dialing a target for direct fallback.
+const directDialTimeout = 10 * time.Second
+
+// PortFallback is what a port does with SOCKS connections while no client
+// is bound to it.
+type PortFallback struct {
+	Mode string // One of the Fallback constants
+	Port int    // Port whose client takes over, for FallbackPort
+}
+
+// String returns the fallback as written in configuration, such as "port:20003".
+func (f PortFallback) String() string {
+	if f.Mode == FallbackPort {
+		return fmt.Sprintf("%s:%d", FallbackPort, f.Port)
+	}
+	return f.Mode
+}
+
+// ParsePortFallbacks parses comma-separated "port=fallback" entries, where
+// fallback is reject, direct or port:N, such as "20001=direct,20002=port:20003".
+func ParsePortFallbacks(s string) (map[int]PortFallback, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	fallbacks := make(map[int]PortFallback)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		portStr, policy, ok := strings.Cut(entry, "=")
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid port fallback %q: expected port=reject, port=direct or port=port:N", entry)
+		}
+
+		var fallback PortFallback
+		switch policy = strings.TrimSpace(policy); {
+		case policy == FallbackReject || policy == FallbackDirect:
+			fallback.Mode = policy
+		case strings.HasPrefix(policy, FallbackPort+":"):
+			target, err := strconv.Atoi(strings.TrimPrefix(policy, FallbackPort+":"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid port fallback %q: %w", entry, err)
+			}
+			fallback = PortFallback{Mode: FallbackPort, Port: target}
+		default:
+			return nil, fmt.Errorf("invalid port fallback %q: expected reject, direct or port:N", entry)
+		}
+		fallbacks[port] = fallback
+	}
+	return fallbacks, nil
+}
+
+// DirectDialer connects to targets from the server itself, vetted by the
+// same address filter exit clients use.
+type DirectDialer struct {
+	filter *client.AddressFilter
+	dialer *client.Dialer
+
+	// dial connects to a vetted target; replaced in tests.
+	dial func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// NewDirectDialer creates a direct dialer. Loopback and link-local targets
+// are always refused, private networks unless allowPrivate is set.
+func NewDirectDialer(allowPrivate bool, blockedCIDRs []string) (*DirectDialer, error) {
+	filter, err := client.NewAddressFilter(allowPrivate, blockedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := client.NewDialer(client.DialerConfig{Timeout: directDialTimeout})
+	if err != nil {
+		return nil, err
+	}
+	d := &DirectDialer{filter: filter, dialer: dialer}
+	d.dial = d.dialFiltered
+	return d, nil
+}
+
+// Dial connects to addr ("host:port") if the filter permits it.
+func (d *DirectDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return d.dial(ctx, addr)
+}
+
+func (d *DirectDialer) dialFiltered(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, directDialTimeout)
+	defer cancel()
+
+	decision, err := d.filter.Resolve(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("target %s not allowed: %w", addr, err)
+	}
+	conn, _, err := d.dialer.DialDecision(ctx, decision)
+	return conn, err
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestParsePortFallbacks(t *testing.T) {
+	fallbacks, err := ParsePortFallbacks("20001=direct, 20002=port:20003,20004=reject")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]PortFallback{
+		20001: {Mode: FallbackDirect},
+		20002: {Mode: FallbackPort, Port: 20003},
+		20004: {Mode: FallbackReject},
+	}, fallbacks)
+	assert.Equal(t, "port:20003", fallbacks[20002].String())
+
+	fallbacks, err = ParsePortFallbacks("")
+	require.NoError(t, err)
+	assert.Nil(t, fallbacks)
+
+	for _, s := range []string{"20001", "x=direct", "20001=proxy", "20001=port:x"} {
+		_, err := ParsePortFallbacks(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestConfigValidate_PortFallbacks(t *testing.T) {
+	base := Config{
+		ListenAddr:        ":9527",
+		Token:             []byte("test-token-12345"),
+		BindIP:            "127.0.0.1",
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        1,
+		MaxAuthFailures:   1,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 1,
+	}
+
+	tests := []struct {
+		name      string
+		fallbacks map[int]PortFallback
+		wantErr   bool
+	}{
+		{"valid", map[int]PortFallback{20001: {Mode: FallbackDirect}, 20002: {Mode: FallbackPort, Port: 20003}}, false},
+		{"port outside range", map[int]PortFallback{30000: {Mode: FallbackReject}}, true},
+		{"target outside range", map[int]PortFallback{20001: {Mode: FallbackPort, Port: 30000}}, true},
+		{"falls back to itself", map[int]PortFallback{20001: {Mode: FallbackPort, Port: 20001}}, true},
+		{"invalid mode", map[int]PortFallback{20001: {Mode: "proxy"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.PortFallbacks = tt.fallbacks
+			if tt.wantErr {
+				assert.Error(t, cfg.Validate())
+			} else {
+				assert.NoError(t, cfg.Validate())
+			}
+		})
+	}
+
+	cfg := base
+	cfg.DirectBlockedNetworks = []string{"10.0.0.0"}
+	assert.Error(t, cfg.Validate())
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	return port
+}
+
+func dialSOCKS(t *testing.T, port int, target string) (net.Conn, error) {
+	t.Helper()
+	dialer, err := proxy.SOCKS5("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil, proxy.Direct)
+	require.NoError(t, err)
+	return dialer.Dial("tcp", target)
+}
+
+func TestSOCKSManager_Fallbacks(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var buf syncBuffer
+	socksManager.SetAccessLog(NewAccessLog(&buf))
+	accounting, err := NewAccounting("", 0)
+	require.NoError(t, err)
+	socksManager.SetAccounting(accounting)
+	bandwidth := NewBandwidthLimiter(BandwidthLimit{Up: 1 << 20, Down: 1 << 20}, BandwidthLimit{}, nil)
+	socksManager.SetBandwidthLimiter(bandwidth)
+
+	rejectPort, relayPort, directPort, backupPort := freePort(t), freePort(t), freePort(t), freePort(t)
+
+	// Direct connections reach a local echo server, which the real filter refuses
+	direct, err := NewDirectDialer(false, nil)
+	require.NoError(t, err)
+	direct.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = echo.Close() }()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("direct"))
+			_ = conn.Close()
+		}
+	}()
+
+	socksManager.SetFallbacks(map[int]PortFallback{
+		rejectPort: {Mode: FallbackReject},
+		relayPort:  {Mode: FallbackPort, Port: backupPort},
+		directPort: {Mode: FallbackDirect},
+	}, direct)
+	defer socksManager.StopAllStandby()
+	for _, port := range []int{rejectPort, relayPort, directPort, backupPort} {
+		require.NoError(t, socksManager.StartStandby(port, "127.0.0.1"))
+	}
+
+	// The backup port has no fallback and so no standby listener
+	_, err = dialSOCKS(t, backupPort, "example.com:443")
+	assert.Error(t, err)
+
+	_, err = dialSOCKS(t, rejectPort, "example.com:443")
+	assert.Error(t, err)
+	require.Eventually(t, func() bool { return buf.String() != "" }, time.Second, 10*time.Millisecond)
+	assert.Contains(t, buf.String(), `"close_reason":"client_offline"`)
+	assert.Contains(t, buf.String(), `"fallback":"reject"`)
+
+	// Relaying through another port needs that port's client
+	_, err = dialSOCKS(t, relayPort, "example.com:443")
+	assert.Error(t, err)
+
+	serverSess, clientSess := newTCPYamuxPair(t)
+	go serveEcho(clientSess)
+	_, err = registry.ReservePorts([]int{backupPort})
+	require.NoError(t, err)
+	require.NoError(t, registry.BindSession(backupPort, serverSess, &mockNetListener{}, ClientMeta{ClientName: "backup"}, 10))
+
+	conn, err := dialSOCKS(t, relayPort, "example.com:443")
+	require.NoError(t, err)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", string(got))
+	_ = conn.Close()
+
+	conn, err = dialSOCKS(t, directPort, echo.Addr().String())
+	require.NoError(t, err)
+	got, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "direct", string(got))
+	_ = conn.Close()
+
+	// Direct connections are accounted and throttled under their port
+	require.Eventually(t, func() bool {
+		for _, total := range accounting.Report(time.Time{}, time.Time{}).Totals {
+			if total == (UsageTotal{Client: DirectAccountName, Port: directPort, Connections: 1, BytesDown: 6}) {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	bandwidth.mu.Lock()
+	assert.Contains(t, bandwidth.buckets, "port/"+strconv.Itoa(directPort)+"/direct")
+	bandwidth.mu.Unlock()
+}
+
+func TestSOCKSManager_StandbyHandover(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	port := freePort(t)
+	socksManager.SetFallbacks(map[int]PortFallback{port: {Mode: FallbackReject}}, nil)
+	defer socksManager.StopAllStandby()
+
+	require.NoError(t, socksManager.StartStandby(port, "127.0.0.1"))
+	// Starting again is a no-op
+	require.NoError(t, socksManager.StartStandby(port, "127.0.0.1"))
+
+	// A client reserving the port takes over its address
+	release, err := registry.ReservePorts([]int{port})
+	require.NoError(t, err)
+	socksManager.StopStandby(port)
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+
+	// No standby while the port is reserved
+	require.NoError(t, socksManager.StartStandby(port, "127.0.0.1"))
+	_ = listener.Close()
+	release()
+
+	require.NoError(t, socksManager.StartStandby(port, "127.0.0.1"))
+	_, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Error(t, err)
+}
+
+func TestDirectDialer_Filter(t *testing.T) {
+	direct, err := NewDirectDialer(true, []string{"192.0.2.0/24"})
+	require.NoError(t, err)
+
+	_, err = direct.Dial(context.Background(), "127.0.0.1:80")
+	assert.ErrorContains(t, err, "loopback")
+	_, err = direct.Dial(context.Background(), "192.0.2.1:80")
+	assert.ErrorContains(t, err, "blocked network")
+}
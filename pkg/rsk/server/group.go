@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+var errGroupClosed = errors.New("session group closed")
+
+// memberStream is a stream the client opened on one member of a group.
+type memberStream struct {
+	conn   net.Conn
+	member transport.Session
+}
+
+// sessionGroup combines the member sessions of a striped client into one
+// logical session. New streams are spread across the live members, and the
+// group stays open until its last member is gone.
+type sessionGroup struct {
+	id         string // Striped session ID (hex)
+	maxMembers int    // Maximum number of members, as announced by the client
+
+	mu      sync.Mutex
+	ports   []int // Ports bound to the group
+	members []transport.Session
+	started bool // Whether at least one member has joined
+
+	accepted chan memberStream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newSessionGroup(id string, ports []int, maxMembers int) *sessionGroup {
+	return &sessionGroup{
+		id:         id,
+		ports:      ports,
+		maxMembers: maxMembers,
+		accepted:   make(chan memberStream),
+		closed:     make(chan struct{}),
+	}
+}
+
+// Add adds a member session to the group. The member is removed again when
+// its session closes; the group closes when no members remain.
+func (g *sessionGroup) Add(sess transport.Session) error {
+	g.mu.Lock()
+	if g.IsClosed() {
+		g.mu.Unlock()
+		return errGroupClosed
+	}
+	if len(g.members) >= g.maxMembers {
+		g.mu.Unlock()
+		return errors.New("session group is full")
+	}
+	g.members = append(g.members, sess)
+	g.started = true
+	g.mu.Unlock()
+
+	go g.acceptLoop(sess)
+	go func() {
+		select {
+		case <-sess.CloseChan():
+			g.remove(sess)
+		case <-g.closed:
+		}
+	}()
+
+	return nil
+}
+
+// Ports returns the ports bound to the group.
+func (g *sessionGroup) Ports() []int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.ports)
+}
+
+// SetPorts records the ports bound to the group after they changed.
+func (g *sessionGroup) SetPorts(ports []int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ports = slices.Clone(ports)
+}
+
+func (g *sessionGroup) remove(sess transport.Session) {
+	g.mu.Lock()
+	for i, m := range g.members {
+		if m == sess {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	empty := g.started && len(g.members) == 0
+	g.mu.Unlock()
+
+	if empty {
+		g.markClosed()
+	}
+}
+
+// acceptLoop forwards streams opened by the client on one member to Accept.
+func (g *sessionGroup) acceptLoop(sess transport.Session) {
+	for {
+		stream, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case g.accepted <- memberStream{conn: stream, member: sess}:
+		case <-g.closed:
+			_ = stream.Close()
+			return
+		}
+	}
+}
+
+// Members returns the number of live member sessions.
+func (g *sessionGroup) Members() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.members)
+}
+
+// Open opens a stream on the least loaded live member.
+func (g *sessionGroup) Open() (net.Conn, error) {
+	for {
+		sess := g.pick()
+		if sess == nil {
+			return nil, errGroupClosed
+		}
+
+		stream, err := sess.Open()
+		if err == nil {
+			return stream, nil
+		}
+
+		// Drop the failed member and try the next one
+		_ = sess.Close()
+		g.remove(sess)
+	}
+}
+
+func (g *sessionGroup) pick() transport.Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var best transport.Session
+	for _, m := range g.members {
+		if m.IsClosed() {
+			continue
+		}
+		if best == nil || m.NumStreams() < best.NumStreams() {
+			best = m
+		}
+	}
+	return best
+}
+
+// Ping measures the round trip of the least loaded live member.
+func (g *sessionGroup) Ping() (time.Duration, error) {
+	sess := g.pick()
+	if sess == nil {
+		return 0, errGroupClosed
+	}
+	p, ok := sess.(pinger)
+	if !ok {
+		return 0, nil
+	}
+	return p.Ping()
+}
+
+// Accept waits for the next stream opened by the client on any member.
+func (g *sessionGroup) Accept() (net.Conn, error) {
+	stream, _, err := g.acceptMember()
+	return stream, err
+}
+
+// acceptMember is Accept, also returning the member the stream was opened on.
+func (g *sessionGroup) acceptMember() (net.Conn, transport.Session, error) {
+	select {
+	case s := <-g.accepted:
+		return s.conn, s.member, nil
+	case <-g.closed:
+		return nil, nil, errGroupClosed
+	}
+}
+
+// Close closes all member sessions and the group.
+func (g *sessionGroup) Close() error {
+	g.mu.Lock()
+	members := g.members
+	g.members = nil
+	g.mu.Unlock()
+
+	for _, m := range members {
+		_ = m.Close()
+	}
+	g.markClosed()
+	return nil
+}
+
+func (g *sessionGroup) markClosed() {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+	})
+}
+
+// CloseChan returns a channel that is closed when the group ends.
+func (g *sessionGroup) CloseChan() <-chan struct{} {
+	return g.closed
+}
+
+// IsClosed reports whether the group has ended.
+func (g *sessionGroup) IsClosed() bool {
+	select {
+	case <-g.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumStreams returns the total number of open streams across all members.
+func (g *sessionGroup) NumStreams() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	total := 0
+	for _, m := range g.members {
+		total += m.NumStreams()
+	}
+	return total
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newYamuxPair returns connected server and client yamux sessions.
+func newYamuxPair(t *testing.T) (*yamux.Session, *yamux.Session) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+
+	serverSess, err := yamux.Server(serverConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+	clientSess, err := yamux.Client(clientConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = serverSess.Close()
+		_ = clientSess.Close()
+	})
+	return serverSess, clientSess
+}
+
+func TestSessionGroup_SpreadsStreams(t *testing.T) {
+	group := newSessionGroup("g1", []int{20001}, 2)
+
+	s1, c1 := newYamuxPair(t)
+	s2, c2 := newYamuxPair(t)
+	require.NoError(t, group.Add(s1))
+	require.NoError(t, group.Add(s2))
+	assert.Equal(t, 2, group.Members())
+
+	// Drain client-side accepts so streams stay open
+	for _, c := range []*yamux.Session{c1, c2} {
+		go func(c *yamux.Session) {
+			for {
+				if _, err := c.Accept(); err != nil {
+					return
+				}
+			}
+		}(c)
+	}
+
+	st1, err := group.Open()
+	require.NoError(t, err)
+	defer func() { _ = st1.Close() }()
+	st2, err := group.Open()
+	require.NoError(t, err)
+	defer func() { _ = st2.Close() }()
+
+	assert.Equal(t, 1, s1.NumStreams())
+	assert.Equal(t, 1, s2.NumStreams())
+	assert.Equal(t, 2, group.NumStreams())
+}
+
+func TestSessionGroup_SurvivesMemberLoss(t *testing.T) {
+	group := newSessionGroup("g1", []int{20001}, 2)
+
+	s1, _ := newYamuxPair(t)
+	s2, _ := newYamuxPair(t)
+	require.NoError(t, group.Add(s1))
+	require.NoError(t, group.Add(s2))
+
+	require.NoError(t, s1.Close())
+	require.Eventually(t, func() bool { return group.Members() == 1 }, time.Second, 10*time.Millisecond)
+	assert.False(t, group.IsClosed())
+
+	stream, err := group.Open()
+	require.NoError(t, err)
+	_ = stream.Close()
+
+	require.NoError(t, s2.Close())
+	select {
+	case <-group.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("group did not close after losing its last member")
+	}
+
+	_, err = group.Open()
+	assert.ErrorIs(t, err, errGroupClosed)
+	assert.ErrorIs(t, group.Add(s2), errGroupClosed)
+}
+
+func TestSessionGroup_MemberLimit(t *testing.T) {
+	group := newSessionGroup("g1", []int{20001}, 1)
+
+	s1, _ := newYamuxPair(t)
+	s2, _ := newYamuxPair(t)
+	require.NoError(t, group.Add(s1))
+	assert.Error(t, group.Add(s2))
+}
+
+func TestRegistry_JoinOrReserveGroup(t *testing.T) {
+	registry := NewRegistry()
+
+	group, created, err := registry.JoinOrReserveGroup("g1", []int{20001, 20002}, 2)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Same ID joins the existing group
+	joined, created, err := registry.JoinOrReserveGroup("g1", []int{20001, 20002}, 2)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Same(t, group, joined)
+
+	// Another session cannot take the group's ports
+	_, _, err = registry.JoinOrReserveGroup("g2", []int{20002}, 2)
+	var portErr *PortInUseError
+	assert.ErrorAs(t, err, &portErr)
+
+	registry.ReleasePorts([]int{20001, 20002})
+	registry.RemoveGroup("g1")
+
+	_, created, err = registry.JoinOrReserveGroup("g2", []int{20002}, 2)
+	require.NoError(t, err)
+	assert.True(t, created)
+}
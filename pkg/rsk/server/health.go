@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// Health states of a client session.
+const (
+	HealthUnknown   = "unknown"   // Not probed yet
+	HealthHealthy   = "healthy"   // Probes pass
+	HealthDegraded  = "degraded"  // Probes pass slowly, or failed fewer times than the threshold
+	HealthUnhealthy = "unhealthy" // Probes failed the threshold number of times in a row
+)
+
+// Health check defaults.
+const (
+	defaultHealthCheckTimeout     = 20 * time.Second // Above the clients' default dial timeout
+	defaultHealthFailureThreshold = 2
+)
+
+// ClientHealth is the outcome of the latest health probes of a session.
+type ClientHealth struct {
+	State     string        // One of the Health constants
+	RTT       time.Duration // Round trip of the last successful ping (0 if unsupported)
+	CheckedAt time.Time     // When the last probe finished
+	Failures  int           // Failed probes in a row
+	Err       error         // Why the last probe failed, if it did
+}
+
+// pinger is implemented by sessions that can measure their round trip time,
+// such as *yamux.Session.
+type pinger interface {
+	Ping() (time.Duration, error)
+}
+
+// HealthCheckConfig configures a HealthChecker.
+type HealthCheckConfig struct {
+	Interval     time.Duration // Time between probe rounds
+	Timeout      time.Duration // Bounds each ping and the test CONNECT
+	Target       string        // host:port to CONNECT to through the client (empty only pings)
+	DegradedRTT  time.Duration // Ping round trip above which a session is degraded (0 disables)
+	FailureLimit int           // Failed probes in a row before a session is unhealthy
+}
+
+// HealthChecker periodically probes every client session and records the
+// result on the session's slots in the registry.
+type HealthChecker struct {
+	registry *Registry
+	cfg      HealthCheckConfig
+	logger   *slog.Logger
+}
+
+// NewHealthChecker creates a health checker.
+func NewHealthChecker(registry *Registry, cfg HealthCheckConfig, logger *slog.Logger) *HealthChecker {
+	if cfg.FailureLimit < 1 {
+		cfg.FailureLimit = 1
+	}
+	return &HealthChecker{registry: registry, cfg: cfg, logger: logger}
+}
+
+// Run probes all sessions every interval until ctx is canceled.
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.CheckAll()
+		}
+	}
+}
+
+// CheckAll probes every live session once, concurrently.
+func (h *HealthChecker) CheckAll() {
+	var wg sync.WaitGroup
+	for _, sess := range h.registry.Sessions() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.check(sess)
+		}()
+	}
+	wg.Wait()
+}
+
+// check probes sess and records the outcome, logging state changes.
+func (h *HealthChecker) check(sess transport.Session) {
+	meta, _ := h.registry.SessionMeta(sess)
+	rtt, err := h.probe(sess, meta.Capabilities)
+	if sess.IsClosed() {
+		return
+	}
+
+	prev, _ := h.registry.SessionHealth(sess)
+	health := ClientHealth{RTT: rtt, CheckedAt: time.Now(), Err: err}
+	switch {
+	case err != nil:
+		health.Failures = prev.Failures + 1
+		health.State = HealthDegraded
+		if health.Failures >= h.cfg.FailureLimit {
+			health.State = HealthUnhealthy
+		}
+	case h.cfg.DegradedRTT > 0 && rtt > h.cfg.DegradedRTT:
+		health.State = HealthDegraded
+	default:
+		health.State = HealthHealthy
+	}
+	meta = h.registry.SetSessionHealth(sess, health)
+
+	attrs := []any{"client_name", meta.ClientName, "client_id", meta.ClientID, "state", health.State, "rtt", rtt}
+	if err != nil {
+		attrs = append(attrs, "failures", health.Failures, "error", err)
+	}
+	switch {
+	case health.State == prev.State:
+		h.logger.Debug("Client health check", attrs...)
+	case health.State == HealthUnhealthy:
+		h.logger.Warn("Client became unhealthy", attrs...)
+	default:
+		h.logger.Info("Client health changed", append(attrs, "previous", prev.State)...)
+	}
+}
+
+// probe pings sess and, with a target configured, connects to it through
+// the client, which negotiated caps. It returns the ping round trip.
+func (h *HealthChecker) probe(sess transport.Session, caps proto.Capabilities) (time.Duration, error) {
+	var rtt time.Duration
+	if p, ok := sess.(pinger); ok {
+		var err error
+		if rtt, err = h.ping(p); err != nil {
+			return 0, fmt.Errorf("ping failed: %w", err)
+		}
+	}
+	if h.cfg.Target != "" {
+		if err := h.testConnect(sess, caps); err != nil {
+			return rtt, err
+		}
+	}
+	return rtt, nil
+}
+
+// ping bounds p.Ping by the timeout.
+func (h *HealthChecker) ping(p pinger) (time.Duration, error) {
+	type result struct {
+		rtt time.Duration
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rtt, err := p.Ping()
+		done <- result{rtt, err}
+	}()
+
+	timer := time.NewTimer(h.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.rtt, r.err
+	case <-timer.C:
+		return 0, fmt.Errorf("no reply within %s", h.cfg.Timeout)
+	}
+}
+
+// testConnect asks the client to connect to the target. A client with
+// connect status reports the dial result, so the probe passes only on
+// ConnectOK. Older clients have no per-stream status: one that cannot reach
+// the target closes the stream, while one that can keeps it open, so the
+// probe passes if the stream is still open after the timeout or the target
+// sent data.
+func (h *HealthChecker) testConnect(sess transport.Session, caps proto.Capabilities) error {
+	stream, err := sess.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open probe stream: %w", err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	if err := stream.SetDeadline(time.Now().Add(h.cfg.Timeout)); err != nil {
+		return err
+	}
+	// Probes belong to the session, not to one of its ports
+	if caps.Has(proto.CapConnectPort) {
+		err = proto.WriteConnectReqPort(stream, 0, h.cfg.Target)
+	} else {
+		err = proto.WriteConnectReq(stream, h.cfg.Target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send probe CONNECT_REQ: %w", err)
+	}
+
+	if caps.Has(proto.CapConnectStatus) {
+		err := proto.ReadConnectResp(stream)
+		var connErr *proto.ConnectError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &connErr):
+			return fmt.Errorf("client could not connect to %s: %w", h.cfg.Target, err)
+		default:
+			return fmt.Errorf("no probe CONNECT_RESP for %s: %w", h.cfg.Target, err)
+		}
+	}
+
+	_, err = stream.Read(make([]byte, 1))
+	var netErr net.Error
+	switch {
+	case err == nil, errors.As(err, &netErr) && netErr.Timeout():
+		return nil
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("client could not connect to %s", h.cfg.Target)
+	default:
+		return fmt.Errorf("probe connection to %s failed: %w", h.cfg.Target, err)
+	}
+}
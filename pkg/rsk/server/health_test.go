@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+// serveProbes answers CONNECT_REQs on sess, keeping streams open if reachable
+// and closing them right away otherwise, like a client whose dial failed.
+func serveProbes(sess *yamux.Session, reachable bool) {
+	for {
+		stream, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = stream.Close() }()
+			if _, err := proto.ReadConnectReq(stream); err != nil || !reachable {
+				return
+			}
+			_, _ = io.Copy(io.Discard, stream)
+		}()
+	}
+}
+
+func newHealthTestRegistry(t *testing.T, reachable bool) (*Registry, *yamux.Session) {
+	t.Helper()
+	return newHealthTestRegistryWith(t, 0, func(sess *yamux.Session) { serveProbes(sess, reachable) })
+}
+
+// newHealthTestRegistryWith binds two ports to a session whose client
+// negotiated caps and serves streams with serve.
+func newHealthTestRegistryWith(t *testing.T, caps proto.Capabilities, serve func(*yamux.Session)) (*Registry, *yamux.Session) {
+	t.Helper()
+	registry := NewRegistry()
+	serverSess, clientSess := newYamuxPair(t)
+	go serve(clientSess)
+
+	_, err := registry.ReservePorts([]int{20001, 20002})
+	require.NoError(t, err)
+	for _, port := range []int{20001, 20002} {
+		require.NoError(t, registry.BindSession(port, serverSess, &mockNetListener{}, ClientMeta{ClientName: "exit", Capabilities: caps}, 10))
+	}
+	return registry, serverSess
+}
+
+func testHealthChecker(registry *Registry, target string) *HealthChecker {
+	return NewHealthChecker(registry, HealthCheckConfig{
+		Interval:     time.Hour,
+		Timeout:      200 * time.Millisecond,
+		Target:       target,
+		FailureLimit: 2,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestHealthChecker_Healthy(t *testing.T) {
+	registry, sess := newHealthTestRegistry(t, true)
+
+	health, _ := registry.SessionHealth(sess)
+	assert.Equal(t, HealthUnknown, health.State)
+
+	testHealthChecker(registry, "example.com:80").CheckAll()
+
+	health, _ = registry.SessionHealth(sess)
+	assert.Equal(t, HealthHealthy, health.State)
+	assert.Greater(t, health.RTT, time.Duration(0))
+	assert.NoError(t, health.Err)
+
+	// Every port of the session shares the result
+	for _, status := range registry.Status() {
+		assert.Equal(t, HealthHealthy, status.Health)
+	}
+}
+
+func TestHealthChecker_UnreachableTarget(t *testing.T) {
+	registry, sess := newHealthTestRegistry(t, false)
+	checker := testHealthChecker(registry, "example.com:80")
+
+	// The first failure only degrades the client
+	checker.CheckAll()
+	health, _ := registry.SessionHealth(sess)
+	assert.Equal(t, HealthDegraded, health.State)
+	assert.Equal(t, 1, health.Failures)
+	_, _, ok := registry.FindClient("exit")
+	assert.True(t, ok)
+
+	checker.CheckAll()
+	health, _ = registry.SessionHealth(sess)
+	assert.Equal(t, HealthUnhealthy, health.State)
+	assert.ErrorContains(t, health.Err, "could not connect to example.com:80")
+
+	// Unhealthy clients are excluded from routing
+	_, _, ok = registry.FindClient("exit")
+	assert.False(t, ok)
+
+	// Pings alone pass, so the client recovers without a target
+	testHealthChecker(registry, "").CheckAll()
+	health, _ = registry.SessionHealth(sess)
+	assert.Equal(t, HealthHealthy, health.State)
+	assert.Zero(t, health.Failures)
+}
+
+func TestHealthChecker_ConnectStatus(t *testing.T) {
+	registry, sess := newHealthTestRegistryWith(t, proto.CapConnectStatus, func(sess *yamux.Session) {
+		serveConnectStatus(sess, func(addr string) uint8 {
+			if addr == "example.com:80" {
+				return proto.ConnectOK
+			}
+			return proto.ConnectUnreachable
+		})
+	})
+	newChecker := func(target string) *HealthChecker {
+		return NewHealthChecker(registry, HealthCheckConfig{
+			Interval:     time.Hour,
+			Timeout:      10 * time.Second,
+			Target:       target,
+			FailureLimit: 2,
+		}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	// The client's answer decides the probe without waiting for the timeout
+	start := time.Now()
+	newChecker("example.com:80").CheckAll()
+	assert.Less(t, time.Since(start), 5*time.Second)
+	health, _ := registry.SessionHealth(sess)
+	assert.Equal(t, HealthHealthy, health.State)
+
+	newChecker("example.net:80").CheckAll()
+	health, _ = registry.SessionHealth(sess)
+	assert.Equal(t, HealthDegraded, health.State)
+	assert.ErrorContains(t, health.Err, "target unreachable from the exit")
+}
+
+func TestHealthChecker_ConnectStatusTimeout(t *testing.T) {
+	// A client still dialing when the probe times out has not reached the
+	// target, even though the stream is open
+	registry, sess := newHealthTestRegistryWith(t, proto.CapConnectStatus, func(sess *yamux.Session) {
+		serveProbes(sess, true)
+	})
+
+	testHealthChecker(registry, "example.com:80").CheckAll()
+	health, _ := registry.SessionHealth(sess)
+	assert.Equal(t, HealthDegraded, health.State)
+	assert.ErrorContains(t, health.Err, "no probe CONNECT_RESP")
+}
+
+func TestHealthChecker_DegradedRTT(t *testing.T) {
+	registry, sess := newHealthTestRegistry(t, true)
+	checker := NewHealthChecker(registry, HealthCheckConfig{
+		Interval:    time.Hour,
+		Timeout:     time.Second,
+		DegradedRTT: time.Nanosecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	checker.CheckAll()
+	health, _ := registry.SessionHealth(sess)
+	assert.Equal(t, HealthDegraded, health.State)
+	assert.NoError(t, health.Err)
+}
+
+func TestSessionGroup_Ping(t *testing.T) {
+	group := newSessionGroup("g1", []int{20001}, 2)
+	_, err := group.Ping()
+	assert.Error(t, err)
+
+	s1, _ := newYamuxPair(t)
+	require.NoError(t, group.Add(s1))
+	rtt, err := group.Ping()
+	require.NoError(t, err)
+	assert.Greater(t, rtt, time.Duration(0))
+}
+
+func TestStatusHandler(t *testing.T) {
+	registry, _ := newHealthTestRegistry(t, true)
+	require.True(t, registry.IncrementConnections(20002))
+	testHealthChecker(registry, "").CheckAll()
+
+	srv := httptest.NewServer(NewStatusHandler(registry))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var status ServerStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.Len(t, status.Ports, 2)
+	assert.Equal(t, 20001, status.Ports[0].Port)
+	assert.Equal(t, "exit", status.Ports[1].ClientName)
+	assert.Equal(t, 1, status.Ports[1].ActiveConnections)
+	assert.Equal(t, 10, status.Ports[1].MaxConnections)
+	assert.Equal(t, HealthHealthy, status.Ports[1].Health)
+	assert.False(t, status.Ports[1].HealthCheckedAt.IsZero())
+
+	resp, err = http.Post(srv.URL, "text/plain", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
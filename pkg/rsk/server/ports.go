@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+var errSessionEnded = errors.New("session ended")
+
+// sessionPorts are the ports held by one client session. The ports of the
+// HELLO are bound when the session is set up; clients with a control stream
+// may then add and release ports without reconnecting.
+type sessionPorts struct {
+	session      transport.Session
+	group        *sessionGroup // Set for striped sessions
+	meta         ClientMeta
+	streams      *controlStreams
+	bindIP       string
+	portMin      int
+	portMax      int
+	maxConns     int32
+	registry     *Registry
+	socksManager *SOCKSManager
+	logger       *slog.Logger
+
+	mu        sync.Mutex
+	ports     []int
+	listeners map[int]net.Listener // SOCKS5 listener by port
+	closed    bool
+}
+
+// bound records the SOCKS5 listener of a port bound during session setup.
+func (p *sessionPorts) bound(port int, listener net.Listener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners[port] = listener
+}
+
+// drop releases a port that failed to bind during session setup.
+func (p *sessionPorts) drop(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ports = slices.DeleteFunc(p.ports, func(held int) bool { return held == port })
+	p.release([]int{port})
+}
+
+// held returns the ports the session holds.
+func (p *sessionPorts) held() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.ports)
+}
+
+// add reserves and binds ports for the session. If any of them cannot be
+// bound, none is.
+func (p *sessionPorts) add(ports []int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errSessionEnded
+	}
+	if len(ports) == 0 {
+		return errors.New("no ports requested")
+	}
+	if len(p.ports)+len(ports) > proto.MaxPortCount {
+		return fmt.Errorf("a session holds at most %d ports", proto.MaxPortCount)
+	}
+	for i, port := range ports {
+		if port < p.portMin || port > p.portMax {
+			return fmt.Errorf("port %d outside allowed range %d-%d", port, p.portMin, p.portMax)
+		}
+		if slices.Contains(p.ports, port) {
+			return fmt.Errorf("port %d already held by the session", port)
+		}
+		if slices.Contains(ports[:i], port) {
+			return fmt.Errorf("port %d requested twice", port)
+		}
+	}
+
+	if _, err := p.registry.ReservePorts(ports); err != nil {
+		var inUse *PortInUseError
+		if errors.As(err, &inUse) {
+			return fmt.Errorf("port %d already in use", inUse.Port)
+		}
+		return err
+	}
+	for _, port := range ports {
+		p.socksManager.StopStandby(port)
+	}
+
+	listeners := make(map[int]net.Listener, len(ports))
+	for _, port := range ports {
+		listener, err := p.socksManager.StartListener(port, p.bindIP, p.session)
+		if err == nil {
+			listeners[port] = listener
+			err = p.registry.BindSession(port, p.session, listener, p.meta, p.maxConns)
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
+			p.release(ports)
+			return fmt.Errorf("failed to bind port %d: %w", port, err)
+		}
+	}
+	p.registry.SetControl(p.session, p.streams)
+
+	p.ports = append(p.ports, ports...)
+	maps.Copy(p.listeners, listeners)
+	if p.group != nil {
+		p.group.SetPorts(p.ports)
+	}
+
+	p.logger.Info("Ports added to session",
+		"client_id", p.meta.ClientID,
+		"client_name", p.meta.ClientName,
+		"added", ports,
+		"ports", p.ports)
+	return nil
+}
+
+// remove releases ports of the session. Connections already open on them
+// continue; the session must keep at least one port.
+func (p *sessionPorts) remove(ports []int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errSessionEnded
+	}
+	if len(ports) == 0 {
+		return errors.New("no ports requested")
+	}
+	for i, port := range ports {
+		if !slices.Contains(p.ports, port) || slices.Contains(ports[:i], port) {
+			return fmt.Errorf("port %d is not held by the session", port)
+		}
+	}
+	if len(ports) >= len(p.ports) {
+		return errors.New("a session must keep at least one port")
+	}
+
+	for _, port := range ports {
+		if listener, ok := p.listeners[port]; ok {
+			_ = listener.Close()
+			delete(p.listeners, port)
+		}
+	}
+	p.release(ports)
+
+	p.ports = slices.DeleteFunc(p.ports, func(port int) bool { return slices.Contains(ports, port) })
+	if p.group != nil {
+		p.group.SetPorts(p.ports)
+	}
+
+	p.logger.Info("Ports removed from session",
+		"client_id", p.meta.ClientID,
+		"client_name", p.meta.ClientName,
+		"removed", ports,
+		"ports", p.ports)
+	return nil
+}
+
+// close releases every port when the session ends. Later requests to add
+// or remove ports fail.
+func (p *sessionPorts) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, listener := range p.listeners {
+		_ = listener.Close()
+	}
+	clear(p.listeners)
+	p.release(p.ports)
+}
+
+// release frees ports in the registry and hands those with a fallback back
+// to their standby listener.
+func (p *sessionPorts) release(ports []int) {
+	p.registry.ReleasePorts(ports)
+	for _, port := range ports {
+		if err := p.socksManager.StartStandby(port, p.bindIP); err != nil {
+			p.logger.Warn("Failed to start fallback listener", "port", port, "error", err)
+		}
+	}
+}
+
+// handleControl answers the control requests of the session's client.
+func (p *sessionPorts) handleControl(method string, payload []byte) (any, error) {
+	switch method {
+	case proto.MethodPing:
+		return nil, nil
+	case proto.MethodAddPorts, proto.MethodRemovePorts:
+		var req proto.PortsRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid %s request: %w", method, err)
+		}
+		update := p.add
+		if method == proto.MethodRemovePorts {
+			update = p.remove
+		}
+		if err := update(req.Ports); err != nil {
+			p.logger.Warn("Port change refused",
+				"client_id", p.meta.ClientID,
+				"method", method,
+				"ports", req.Ports,
+				"error", err)
+			return nil, err
+		}
+		return proto.PortsResult{Ports: p.held()}, nil
+	}
+	return nil, proto.ErrUnknownControlMethod
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// startQUIC starts the UDP listener for the QUIC transport. The client opens
+// the first stream of each connection to carry the HELLO handshake; every
+// CONNECT_REQ afterwards runs on its own native QUIC stream.
+func (s *Server) startQUIC(
+	ctx context.Context,
+	connLimiter *ConnectionLimiter,
+	rateLimiter *IPRateLimiter,
+	socksManager *SOCKSManager,
+) error {
+	tlsConfig, err := s.config.LoadTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	listener, err := transport.ListenQUIC(s.config.QUICListenAddr, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.QUICListenAddr, err)
+	}
+
+	s.logger.Info("QUIC listener started", "address", listener.Addr().String())
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	go func() {
+		for {
+			sess, err := listener.Accept(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error("Failed to accept QUIC connection", "error", err)
+				}
+				return
+			}
+
+			s.logger.Debug("Accepted new QUIC connection", "remote_addr", sess.RemoteAddr().String())
+
+			if !connLimiter.Acquire() {
+				s.logger.Warn("Connection limit reached, rejecting new connection",
+					"remote_addr", sess.RemoteAddr().String(),
+					"max_clients", s.config.MaxClients,
+					"available", connLimiter.Available())
+				_ = sess.Close()
+				continue
+			}
+
+			go s.handleQUICSession(sess, connLimiter, rateLimiter, socksManager)
+		}
+	}()
+
+	return nil
+}
+
+// handleQUICSession waits for the handshake stream and then runs the regular
+// client lifecycle with the QUIC connection as the session.
+func (s *Server) handleQUICSession(
+	sess *transport.QUICSession,
+	connLimiter *ConnectionLimiter,
+	rateLimiter *IPRateLimiter,
+	socksManager *SOCKSManager,
+) {
+	defer func() {
+		_ = sess.Close()
+	}()
+
+	stream, err := acceptWithTimeout(sess, 5*time.Second)
+	if err != nil {
+		connLimiter.Release()
+		s.logger.Warn("Failed to accept QUIC handshake stream",
+			"remote_addr", sess.RemoteAddr().String(),
+			"error", err)
+		return
+	}
+
+	handleClientConnection(
+		stream,
+		func(net.Conn) (transport.Session, error) { return sess, nil },
+		connLimiter,
+		rateLimiter,
+		s.config.Token,
+		s.config.BindIP,
+		s.config.PortMin,
+		s.config.PortMax,
+		s.config.MaxConnsPerClient,
+		s.registry,
+		socksManager,
+		s.logger,
+	)
+}
+
+// acceptWithTimeout accepts the next stream from sess, closing the session if
+// none arrives within timeout.
+func acceptWithTimeout(sess transport.Session, timeout time.Duration) (net.Conn, error) {
+	timer := time.AfterFunc(timeout, func() {
+		_ = sess.Close()
+	})
+	defer timer.Stop()
+
+	return sess.Accept()
+}
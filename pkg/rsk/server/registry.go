@@ -1,45 +1,100 @@
 package server
 
 import (
+	"maps"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/hashicorp/yamux"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
 )
 
 type ClientSlot struct {
-	port       int    // Port number
-	clientName string // Client name
-	clientID   string // Client UUID
+	port int        // Port number
+	meta ClientMeta // Client name, ID, labels and other metadata of the bound client
 
-	session       *yamux.Session // Yamux session
-	socksListener net.Listener   // SOCKS5 listener
+	session       transport.Session // Multiplexed client session
+	socksListener net.Listener      // SOCKS5 listener
 
 	activeConns int32 // Active SOCKS5 connections (atomic)
 	maxConns    int32 // Maximum allowed connections
 
+	health  ClientHealth    // Latest health probe result, protected by the registry lock
+	breaker *CircuitBreaker // Optional breaker tracking connection outcomes
+	control *controlStreams // Control streams of the session, nil if it has none
+
 	stopOnce sync.Once // Ensures cleanup happens once
 	stopFunc func()    // Custom cleanup function
 }
 
 type Registry struct {
-	mu    sync.RWMutex        // Protects slots
-	slots map[int]*ClientSlot // Port to client slot mapping
+	mu     sync.RWMutex             // Protects slots and groups
+	slots  map[int]*ClientSlot      // Port to client slot mapping
+	groups map[string]*sessionGroup // Striped session ID to session group
+
+	breakerThreshold int           // Failures opening a slot's breaker (0 disables breakers)
+	breakerCoolDown  time.Duration // How long an open breaker fails connections fast
 }
 
 // NewRegistry creates a new Registry.
 func NewRegistry() *Registry {
 	return &Registry{
-		slots: make(map[int]*ClientSlot),
+		slots:  make(map[int]*ClientSlot),
+		groups: make(map[string]*sessionGroup),
 	}
 }
 
+// EnableBreakers gives every slot bound from now on a circuit breaker that
+// opens after threshold failed connections in a row.
+func (r *Registry) EnableBreakers(threshold int, coolDown time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.breakerThreshold = threshold
+	r.breakerCoolDown = coolDown
+}
+
 // ReservePorts atomically reserves the specified ports.
 func (r *Registry) ReservePorts(ports []int) (releaseFunc func(), err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	return r.reservePortsLocked(ports)
+}
+
+// JoinOrReserveGroup returns the existing session group for a striped session
+// ID, or atomically reserves the ports and registers a new group. created is
+// true when the caller is responsible for binding the ports and, once the group
+// closes, releasing them and calling RemoveGroup.
+func (r *Registry) JoinOrReserveGroup(id string, ports []int, maxMembers int) (group *sessionGroup, created bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if group, exists := r.groups[id]; exists {
+		return group, false, nil
+	}
+
+	if _, err := r.reservePortsLocked(ports); err != nil {
+		return nil, false, err
+	}
+
+	group = newSessionGroup(id, ports, maxMembers)
+	r.groups[id] = group
+	return group, true, nil
+}
+
+// RemoveGroup unregisters a session group.
+func (r *Registry) RemoveGroup(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.groups, id)
+}
+
+func (r *Registry) reservePortsLocked(ports []int) (releaseFunc func(), err error) {
 	for _, port := range ports {
 		if _, exists := r.slots[port]; exists {
 			return nil, &PortInUseError{Port: port}
@@ -79,12 +134,20 @@ func (e *PortInUseError) Error() string {
 }
 
 type ClientMeta struct {
-	ClientName string // Client name
-	ClientID   string // Client UUID
+	ClientName string            // Client name
+	ClientID   string            // Client UUID
+	Labels     map[string]string // Labels set by the client's operator
+	Version    string            // Client software version
+	Platform   string            // Client OS and architecture, such as linux/amd64
+	Hostname   string            // Host name of the client machine
+	PublicIP   string            // Egress public IP reported by the client
+	RemoteAddr string            // Address the client connected from
+
+	Capabilities proto.Capabilities // Protocol features negotiated with the client
 }
 
-// BindSession associates a yamux session and SOCKS listener with a reserved port.
-func (r *Registry) BindSession(port int, sess *yamux.Session, listener net.Listener, meta ClientMeta, maxConns int32) error {
+// BindSession associates a client session and SOCKS listener with a reserved port.
+func (r *Registry) BindSession(port int, sess transport.Session, listener net.Listener, meta ClientMeta, maxConns int32) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -96,10 +159,15 @@ func (r *Registry) BindSession(port int, sess *yamux.Session, listener net.Liste
 	// Update the slot with session and listener
 	slot.session = sess
 	slot.socksListener = listener
-	slot.clientName = meta.ClientName
-	slot.clientID = meta.ClientID
+	slot.meta = meta
 	slot.maxConns = maxConns
 	slot.activeConns = 0
+	slot.health = ClientHealth{State: HealthUnknown}
+	slot.breaker = nil
+	slot.control = nil
+	if r.breakerThreshold > 0 {
+		slot.breaker = NewCircuitBreaker(r.breakerThreshold, r.breakerCoolDown)
+	}
 
 	return nil
 }
@@ -112,8 +180,8 @@ func (e *PortNotReservedError) Error() string {
 	return "port not reserved"
 }
 
-// GetSession retrieves the yamux session associated with a port.
-func (r *Registry) GetSession(port int) (*yamux.Session, bool) {
+// GetSession retrieves the client session associated with a port.
+func (r *Registry) GetSession(port int) (transport.Session, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -125,6 +193,240 @@ func (r *Registry) GetSession(port int) (*yamux.Session, bool) {
 	return slot.session, true
 }
 
+// GetClientMeta returns the metadata of the client bound to a port.
+func (r *Registry) GetClientMeta(port int) (ClientMeta, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	slot, exists := r.slots[port]
+	if !exists || slot.session == nil {
+		return ClientMeta{}, false
+	}
+
+	return slot.meta, true
+}
+
+// IsReserved reports whether port is reserved or bound by a client.
+func (r *Registry) IsReserved(port int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, exists := r.slots[port]
+	return exists
+}
+
+// Breaker returns the circuit breaker of the slot bound to port, or nil if
+// breakers are disabled or the port is not bound.
+func (r *Registry) Breaker(port int) *CircuitBreaker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if slot, exists := r.slots[port]; exists {
+		return slot.breaker
+	}
+	return nil
+}
+
+// FindClient returns the lowest port bound by a client named name, and the
+// client's session. Unhealthy clients and slots whose breaker is open are skipped.
+func (r *Registry) FindClient(name string) (int, transport.Session, bool) {
+	return r.SelectClient(ClientSelector{Name: name})
+}
+
+// SelectClient returns the lowest port bound by a client sel matches, and
+// the client's session, skipping clients like FindClient. Only routing
+// selects clients this way; a port's own listener and port fallbacks use
+// the port's session whatever its health.
+func (r *Registry) SelectClient(sel ClientSelector) (int, transport.Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found *ClientSlot
+	for _, slot := range r.slots {
+		if slot.session == nil || !sel.Matches(slot.meta.ClientName, slot.meta.Labels) || slot.session.IsClosed() || slot.health.State == HealthUnhealthy {
+			continue
+		}
+		if slot.breaker != nil && !slot.breaker.Ready() {
+			continue
+		}
+		if found == nil || slot.port < found.port {
+			found = slot
+		}
+	}
+	if found == nil {
+		return 0, nil, false
+	}
+	return found.port, found.session, true
+}
+
+// Sessions returns every live client session once.
+func (r *Registry) Sessions() []transport.Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[transport.Session]bool)
+	var sessions []transport.Session
+	for _, slot := range r.slots {
+		if slot.session == nil || slot.session.IsClosed() || seen[slot.session] {
+			continue
+		}
+		seen[slot.session] = true
+		sessions = append(sessions, slot.session)
+	}
+	return sessions
+}
+
+// SessionHealth returns the health recorded for sess.
+func (r *Registry) SessionHealth(sess transport.Session) (ClientHealth, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, slot := range r.slots {
+		if slot.session == sess {
+			return slot.health, true
+		}
+	}
+	return ClientHealth{State: HealthUnknown}, false
+}
+
+// SessionMeta returns the metadata of the client owning sess.
+func (r *Registry) SessionMeta(sess transport.Session) (ClientMeta, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, slot := range r.slots {
+		if slot.session == sess {
+			return slot.meta, true
+		}
+	}
+	return ClientMeta{}, false
+}
+
+// SetSessionHealth records health on every slot bound to sess and returns
+// the metadata of the client owning it.
+func (r *Registry) SetSessionHealth(sess transport.Session, health ClientHealth) ClientMeta {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var meta ClientMeta
+	for _, slot := range r.slots {
+		if slot.session == sess {
+			slot.health = health
+			meta = slot.meta
+		}
+	}
+	return meta
+}
+
+// SetControl records the control streams of sess on every slot bound to it.
+func (r *Registry) SetControl(sess transport.Session, streams *controlStreams) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, slot := range r.slots {
+		if slot.session == sess {
+			slot.control = streams
+		}
+	}
+}
+
+// ClientControl is a live client session with the ports it holds and its
+// control streams.
+type ClientControl struct {
+	Meta    ClientMeta
+	Ports   []int
+	streams *controlStreams
+}
+
+// Controls returns every live client session once, ordered by lowest port.
+func (r *Registry) Controls() []ClientControl {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bySession := make(map[transport.Session]*ClientControl)
+	var clients []*ClientControl
+	for _, slot := range r.slots {
+		if slot.session == nil || slot.session.IsClosed() {
+			continue
+		}
+		client, ok := bySession[slot.session]
+		if !ok {
+			client = &ClientControl{Meta: slot.meta, streams: slot.control}
+			bySession[slot.session] = client
+			clients = append(clients, client)
+		}
+		client.Ports = append(client.Ports, slot.port)
+	}
+
+	controls := make([]ClientControl, 0, len(clients))
+	for _, client := range clients {
+		sort.Ints(client.Ports)
+		controls = append(controls, *client)
+	}
+	sort.Slice(controls, func(i, j int) bool { return controls[i].Ports[0] < controls[j].Ports[0] })
+	return controls
+}
+
+// SlotStatus describes a port bound by a client, for status output.
+type SlotStatus struct {
+	Port              int               `json:"port"`
+	ClientName        string            `json:"client_name"`
+	ClientID          string            `json:"client_id"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	ClientVersion     string            `json:"client_version,omitempty"`
+	Platform          string            `json:"platform,omitempty"`
+	Hostname          string            `json:"hostname,omitempty"`
+	PublicIP          string            `json:"public_ip,omitempty"`
+	RemoteAddr        string            `json:"remote_addr,omitempty"`
+	ActiveConnections int               `json:"active_connections"`
+	MaxConnections    int               `json:"max_connections"`
+	Health            string            `json:"health"`
+	RTTMs             float64           `json:"rtt_ms,omitempty"`
+	HealthCheckedAt   time.Time         `json:"health_checked_at,omitzero"`
+	HealthFailures    int               `json:"health_failures,omitempty"`
+	HealthError       string            `json:"health_error,omitempty"`
+	Breaker           string            `json:"breaker,omitempty"`
+}
+
+// Status returns the status of every bound port, ordered by port.
+func (r *Registry) Status() []SlotStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]SlotStatus, 0, len(r.slots))
+	for _, slot := range r.slots {
+		if slot.session == nil {
+			continue
+		}
+		status := SlotStatus{
+			Port:              slot.port,
+			ClientName:        slot.meta.ClientName,
+			ClientID:          slot.meta.ClientID,
+			Labels:            maps.Clone(slot.meta.Labels),
+			ClientVersion:     slot.meta.Version,
+			Platform:          slot.meta.Platform,
+			Hostname:          slot.meta.Hostname,
+			PublicIP:          slot.meta.PublicIP,
+			RemoteAddr:        slot.meta.RemoteAddr,
+			ActiveConnections: int(atomic.LoadInt32(&slot.activeConns)),
+			MaxConnections:    int(slot.maxConns),
+			Health:            slot.health.State,
+			RTTMs:             float64(slot.health.RTT.Microseconds()) / 1000,
+			HealthCheckedAt:   slot.health.CheckedAt,
+			HealthFailures:    slot.health.Failures,
+		}
+		if slot.health.Err != nil {
+			status.HealthError = slot.health.Err.Error()
+		}
+		if slot.breaker != nil {
+			status.Breaker = slot.breaker.State()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Port < statuses[j].Port })
+	return statuses
+}
+
 // ReleasePorts removes the specified ports from the registry and closes associated resources.
 // This operation is idempotent - calling it multiple times is safe.
 func (r *Registry) ReleasePorts(ports []int) {
@@ -103,8 +103,8 @@ func TestBindSession(t *testing.T) {
 	slot := r.slots[port]
 	assert.Equal(t, mockSession, slot.session)
 	assert.Equal(t, mockListener, slot.socksListener)
-	assert.Equal(t, "test-client", slot.clientName)
-	assert.Equal(t, "test-id-123", slot.clientID)
+	assert.Equal(t, "test-client", slot.meta.ClientName)
+	assert.Equal(t, "test-id-123", slot.meta.ClientID)
 	assert.Equal(t, int32(100), slot.maxConns)
 	assert.Equal(t, int32(0), slot.activeConns)
 }
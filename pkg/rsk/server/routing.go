@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RouteReject is the route action refusing the connection.
+const RouteReject = "reject"
+
+// Route rule kinds.
+const (
+	RouteDomain = "domain" // Domain suffix: "cn" matches cn and any name under it
+	RouteCIDR   = "cidr"   // Network containing an IP address target
+	RoutePort   = "port"   // Destination port or range such as "8000-8999"
+	RouteRegex  = "regex"  // Regular expression matched against the target host
+)
+
+// RouteLabelPrefix starts route actions selecting clients by label, such as
+// "label:region=eu,isp=acme".
+const RouteLabelPrefix = "label:"
+
+// ClientSelector matches clients by name, or by labels if Labels is set.
+type ClientSelector struct {
+	Name   string
+	Labels map[string]string // Labels a client must all have, with equal values
+}
+
+// ParseClientSelector parses a route action other than RouteReject: a
+// client name, or RouteLabelPrefix followed by comma-separated key=value labels.
+func ParseClientSelector(action string) (ClientSelector, error) {
+	spec, ok := strings.CutPrefix(action, RouteLabelPrefix)
+	if !ok {
+		if action == "" {
+			return ClientSelector{}, fmt.Errorf("empty route action")
+		}
+		return ClientSelector{Name: action}, nil
+	}
+
+	labels := make(map[string]string)
+	for part := range strings.SplitSeq(spec, ",") {
+		key, value, found := strings.Cut(part, "=")
+		if !found || key == "" {
+			return ClientSelector{}, fmt.Errorf("invalid label selector %q: expected %skey=value[,key=value...]", action, RouteLabelPrefix)
+		}
+		labels[key] = value
+	}
+	return ClientSelector{Labels: labels}, nil
+}
+
+// Matches reports whether a client with the given name and labels is selected.
+func (s ClientSelector) Matches(name string, labels map[string]string) bool {
+	if s.Labels == nil {
+		return name == s.Name
+	}
+	for key, value := range s.Labels {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// RouteRule sends targets matching Kind and Value through the client the
+// action Client selects, or refuses them if Client is RouteReject.
+type RouteRule struct {
+	Kind   string
+	Value  string
+	Client string
+
+	match func(host string, ip net.IP, port int) bool
+}
+
+// ParseRouteRule compiles a rule.
+func ParseRouteRule(kind, value, client string) (RouteRule, error) {
+	rule := RouteRule{Kind: kind, Value: value, Client: client}
+	if client == "" {
+		return rule, fmt.Errorf("route rule %s %s has no action", kind, value)
+	}
+	if client != RouteReject {
+		if _, err := ParseClientSelector(client); err != nil {
+			return rule, err
+		}
+	}
+
+	switch kind {
+	case RouteDomain:
+		suffix := normalizeHost(strings.TrimPrefix(strings.TrimPrefix(value, "*"), "."))
+		if suffix == "" || strings.ContainsAny(suffix, "*/: ") {
+			return rule, fmt.Errorf("invalid domain suffix %q", value)
+		}
+		rule.match = func(host string, ip net.IP, _ int) bool {
+			return ip == nil && (host == suffix || strings.HasSuffix(host, "."+suffix))
+		}
+	case RouteCIDR:
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid CIDR block %q: %w", value, err)
+		}
+		rule.match = func(_ string, ip net.IP, _ int) bool {
+			return ip != nil && network.Contains(ip)
+		}
+	case RoutePort:
+		portMin, portMax, err := parseRoutePorts(value)
+		if err != nil {
+			return rule, err
+		}
+		rule.match = func(_ string, _ net.IP, port int) bool {
+			return port >= portMin && port <= portMax
+		}
+	case RouteRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid route regex %q: %w", value, err)
+		}
+		rule.match = func(host string, _ net.IP, _ int) bool {
+			return re.MatchString(host)
+		}
+	default:
+		return rule, fmt.Errorf("invalid route rule kind %q: expected %s, %s, %s or %s",
+			kind, RouteDomain, RouteCIDR, RoutePort, RouteRegex)
+	}
+	return rule, nil
+}
+
+func parseRoutePorts(value string) (int, int, error) {
+	lo, hi, found := strings.Cut(value, "-")
+	if !found {
+		hi = lo
+	}
+	portMin, errMin := strconv.Atoi(lo)
+	portMax, errMax := strconv.Atoi(hi)
+	if errMin != nil || errMax != nil || portMin < 1 || portMax > 65535 || portMin > portMax {
+		return 0, 0, fmt.Errorf("invalid route port %q: expected a port or min-max range within 1-65535", value)
+	}
+	return portMin, portMax, nil
+}
+
+// LoadRouteRules reads rules from a file with one "kind value action" rule
+// per line, such as "domain cn exit-cn" or "port 25 reject". Blank lines and
+// text after # are ignored.
+func LoadRouteRules(path string) ([]RouteRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open route rules: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var rules []RouteRule
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected kind, value and action", path, lineNo)
+		}
+		rule, err := ParseRouteRule(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read route rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Router picks the client a SOCKS target is relayed through. Rules are
+// checked in order and the first match wins; targets no rule matches take
+// the fallback action.
+type Router struct {
+	rules    []RouteRule
+	fallback string // Route action or RouteReject
+}
+
+// NewRouter creates a router. An empty fallback rejects unmatched targets.
+func NewRouter(rules []RouteRule, fallback string) *Router {
+	if fallback == "" {
+		fallback = RouteReject
+	}
+	return &Router{rules: rules, fallback: fallback}
+}
+
+// Route returns the action selecting the client to relay addr ("host:port")
+// through: a client name or a label selector, see ParseClientSelector.
+// Host names are matched as given; CIDR rules only match IP address targets,
+// since names are resolved by the client at the exit.
+func (r *Router) Route(addr string) (string, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid target port %q", portStr)
+	}
+	host = normalizeHost(host)
+	ip := net.ParseIP(host)
+
+	client := r.fallback
+	for _, rule := range r.rules {
+		if rule.match(host, ip, port) {
+			client = rule.Client
+			break
+		}
+	}
+	if client == RouteReject {
+		return "", fmt.Errorf("target %s rejected by routing rules", addr)
+	}
+	return client, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
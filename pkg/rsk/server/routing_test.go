@@ -0,0 +1,271 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"golang.org/x/net/proxy"
+)
+
+func mustRule(t *testing.T, kind, value, client string) RouteRule {
+	t.Helper()
+	rule, err := ParseRouteRule(kind, value, client)
+	require.NoError(t, err)
+	return rule
+}
+
+func TestRouter_Route(t *testing.T) {
+	router := NewRouter([]RouteRule{
+		mustRule(t, RoutePort, "25", RouteReject),
+		mustRule(t, RouteDomain, "cn", "client-a"),
+		mustRule(t, RouteCIDR, "10.20.0.0/16", "client-b"),
+		mustRule(t, RouteRegex, `^git\.`, "client-b"),
+		mustRule(t, RoutePort, "8000-8999", "client-b"),
+	}, "client-c")
+
+	tests := []struct {
+		addr   string
+		client string
+	}{
+		{"example.cn:443", "client-a"},
+		{"WWW.Example.CN.:443", "client-a"},
+		{"cn:443", "client-a"},
+		{"example.com.cn.evil.com:443", "client-c"},
+		{"10.20.3.4:22", "client-b"},
+		{"10.21.3.4:22", "client-c"},
+		{"[2001:db8::1]:443", "client-c"},
+		{"git.example.com:22", "client-b"},
+		{"example.com:8080", "client-b"},
+		{"example.com:443", "client-c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			client, err := router.Route(tt.addr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.client, client)
+		})
+	}
+
+	// First match wins, so the port rule rejects mail to .cn hosts too
+	_, err := router.Route("mail.example.cn:25")
+	assert.Error(t, err)
+}
+
+func TestRouter_FallbackReject(t *testing.T) {
+	router := NewRouter([]RouteRule{mustRule(t, RouteDomain, "*.corp", "client-a")}, "")
+
+	client, err := router.Route("git.corp:22")
+	require.NoError(t, err)
+	assert.Equal(t, "client-a", client)
+
+	_, err = router.Route("example.com:443")
+	assert.Error(t, err)
+}
+
+func TestParseRouteRule_Invalid(t *testing.T) {
+	for _, tt := range [][3]string{
+		{"domain", "", "a"},
+		{"domain", "*", "a"},
+		{"cidr", "10.0.0.0", "a"},
+		{"port", "0", "a"},
+		{"port", "9000-8000", "a"},
+		{"regex", "(", "a"},
+		{"geoip", "CN", "a"},
+		{"domain", "cn", ""},
+	} {
+		_, err := ParseRouteRule(tt[0], tt[1], tt[2])
+		assert.Error(t, err, "%v", tt)
+	}
+}
+
+func TestLoadRouteRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`# Chinese sites leave through the Shanghai exit
+domain  cn            exit-sh
+
+cidr    10.20.0.0/16  exit-dc   # data center
+port    25            reject
+`), 0o600))
+
+	rules, err := LoadRouteRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	assert.Equal(t, RouteRule{Kind: RouteCIDR, Value: "10.20.0.0/16", Client: "exit-dc"},
+		RouteRule{Kind: rules[1].Kind, Value: rules[1].Value, Client: rules[1].Client})
+
+	require.NoError(t, os.WriteFile(path, []byte("domain cn exit-sh\ncidr 10.20.0.0/16\n"), 0o600))
+	_, err = LoadRouteRules(path)
+	assert.ErrorContains(t, err, "routes.conf:2")
+
+	_, err = LoadRouteRules(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.Error(t, err)
+}
+
+func TestRegistry_FindClient(t *testing.T) {
+	registry := NewRegistry()
+	sessA, _ := newYamuxPair(t)
+	sessB, _ := newYamuxPair(t)
+
+	_, err := registry.ReservePorts([]int{20003, 20001, 20002})
+	require.NoError(t, err)
+	require.NoError(t, registry.BindSession(20003, sessA, &mockNetListener{}, ClientMeta{ClientName: "a"}, 10))
+	require.NoError(t, registry.BindSession(20001, sessA, &mockNetListener{}, ClientMeta{ClientName: "a"}, 10))
+	require.NoError(t, registry.BindSession(20002, sessB, &mockNetListener{}, ClientMeta{ClientName: "b"}, 10))
+
+	port, sess, ok := registry.FindClient("a")
+	require.True(t, ok)
+	assert.Equal(t, 20001, port)
+	assert.Same(t, sessA, sess)
+
+	_, _, ok = registry.FindClient("c")
+	assert.False(t, ok)
+
+	// Closed sessions are skipped
+	_ = sessB.Close()
+	_, _, ok = registry.FindClient("b")
+	assert.False(t, ok)
+}
+
+// serveEcho answers CONNECT_REQs on sess by writing back the requested address.
+func serveEcho(sess *yamux.Session) {
+	for {
+		stream, err := sess.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = stream.Close() }()
+			addr, err := proto.ReadConnectReq(stream)
+			if err != nil {
+				return
+			}
+			_, _ = stream.Write([]byte(addr))
+		}()
+	}
+}
+
+// newTCPYamuxPair returns a connected yamux pair over loopback TCP, whose
+// streams carry the TCP addresses go-socks5 needs for its replies.
+func newTCPYamuxPair(t *testing.T) (*yamux.Session, *yamux.Session) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	serverConn, err := listener.Accept()
+	require.NoError(t, err)
+
+	serverSess, err := yamux.Server(serverConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+	clientSess, err := yamux.Client(clientConn, yamux.DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = serverSess.Close()
+		_ = clientSess.Close()
+	})
+	return serverSess, clientSess
+}
+
+func TestSOCKSManager_RouteListener(t *testing.T) {
+	registry := NewRegistry()
+	socksManager := NewSOCKSManager(registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for i, name := range []string{"client-a", "client-b"} {
+		port := 20001 + i
+		serverSess, clientSess := newTCPYamuxPair(t)
+		go serveEcho(clientSess)
+		_, err := registry.ReservePorts([]int{port})
+		require.NoError(t, err)
+		require.NoError(t, registry.BindSession(port, serverSess, &mockNetListener{}, ClientMeta{ClientName: name}, 10))
+	}
+
+	router := NewRouter([]RouteRule{
+		mustRule(t, RouteDomain, "cn", "client-a"),
+		mustRule(t, RouteCIDR, "10.20.0.0/16", "client-b"),
+		mustRule(t, RouteDomain, "offline.example", "client-c"),
+	}, RouteReject)
+	listener, err := socksManager.StartRouteListener("127.0.0.1:0", router)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	dialer, err := proxy.SOCKS5("tcp", listener.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+
+	for _, target := range []string{"example.cn:443", "10.20.0.1:22"} {
+		conn, err := dialer.Dial("tcp", target)
+		require.NoError(t, err, target)
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(got))
+		_ = conn.Close()
+	}
+	// Connections count against the chosen client's port until closed
+	assert.Eventually(t, func() bool {
+		return registry.GetConnectionCount(20001) == 0 && registry.GetConnectionCount(20002) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// Rejected by the fallback, and routed to a client that is not connected
+	for _, target := range []string{"example.com:443", "offline.example:443"} {
+		_, err := dialer.Dial("tcp", target)
+		assert.Error(t, err, target)
+	}
+}
+
+func TestParseClientSelector(t *testing.T) {
+	sel, err := ParseClientSelector("exit-us")
+	require.NoError(t, err)
+	assert.Equal(t, ClientSelector{Name: "exit-us"}, sel)
+	assert.True(t, sel.Matches("exit-us", nil))
+	assert.False(t, sel.Matches("exit-eu", nil))
+
+	sel, err = ParseClientSelector("label:region=eu,isp=acme")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"region": "eu", "isp": "acme"}, sel.Labels)
+	assert.True(t, sel.Matches("any", map[string]string{"region": "eu", "isp": "acme", "tier": "1"}))
+	assert.False(t, sel.Matches("any", map[string]string{"region": "eu"}))
+	assert.False(t, sel.Matches("any", map[string]string{"region": "us", "isp": "acme"}))
+
+	for _, action := range []string{"", "label:", "label:region", "label:=eu"} {
+		_, err := ParseClientSelector(action)
+		assert.Error(t, err, action)
+	}
+
+	_, err = ParseRouteRule(RouteDomain, "eu", "label:region")
+	assert.Error(t, err)
+}
+
+func TestRegistry_SelectClientByLabels(t *testing.T) {
+	registry := NewRegistry()
+	sessA, _ := newYamuxPair(t)
+	sessB, _ := newYamuxPair(t)
+
+	_, err := registry.ReservePorts([]int{20001, 20002})
+	require.NoError(t, err)
+	require.NoError(t, registry.BindSession(20001, sessA, &mockNetListener{},
+		ClientMeta{ClientName: "a", Labels: map[string]string{"region": "us"}, Platform: "linux/amd64"}, 10))
+	require.NoError(t, registry.BindSession(20002, sessB, &mockNetListener{},
+		ClientMeta{ClientName: "b", Labels: map[string]string{"region": "eu"}, PublicIP: "203.0.113.7"}, 10))
+
+	port, sess, ok := registry.SelectClient(ClientSelector{Labels: map[string]string{"region": "eu"}})
+	require.True(t, ok)
+	assert.Equal(t, 20002, port)
+	assert.Same(t, sessB, sess)
+
+	_, _, ok = registry.SelectClient(ClientSelector{Labels: map[string]string{"region": "ap"}})
+	assert.False(t, ok)
+
+	status := registry.Status()
+	assert.Equal(t, map[string]string{"region": "us"}, status[0].Labels)
+	assert.Equal(t, "linux/amd64", status[0].Platform)
+	assert.Equal(t, "203.0.113.7", status[1].PublicIP)
+}
@@ -2,9 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/hashicorp/yamux"
 	"github.com/tbxark/rsk/pkg/rsk/common"
 	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
 )
 
 type Server struct {
@@ -20,10 +23,28 @@ type Server struct {
 	logger   *slog.Logger // Logger instance
 }
 
+// sessionFactory establishes the multiplexed session after a successful handshake on conn.
+type sessionFactory func(conn net.Conn) (transport.Session, error)
+
+// yamuxSession runs a yamux server session over the handshake connection.
+func yamuxSession(conn net.Conn) (transport.Session, error) {
+	yamuxConfig := yamux.DefaultConfig()
+	yamuxConfig.EnableKeepAlive = true
+	yamuxConfig.KeepAliveInterval = 30 * time.Second
+	yamuxConfig.ConnectionWriteTimeout = 10 * time.Second
+
+	session, err := yamux.Server(conn, yamuxConfig)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
 // handleClientConnection handles a single client connection through the complete lifecycle:
 // handshake, validation, port reservation, session establishment, and cleanup.
 func handleClientConnection(
 	conn net.Conn,
+	newSession sessionFactory,
 	connLimiter *ConnectionLimiter,
 	rateLimiter *IPRateLimiter,
 	token []byte,
@@ -62,7 +83,7 @@ func handleClientConnection(
 	hello, err := proto.ReadHello(conn)
 	if err != nil {
 		logger.Warn("Failed to read HELLO message", "error", err)
-		sendErrorResponse(conn, proto.StatusBadRequest, "Invalid HELLO message", logger)
+		sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Invalid HELLO message", logger)
 		return
 	}
 
@@ -71,15 +92,17 @@ func handleClientConnection(
 		"port_count", len(hello.Ports),
 		"ports", hello.Ports)
 
-	if string(hello.Magic[:]) != proto.MagicValue {
+	switch string(hello.Magic[:]) {
+	case proto.MagicValue, proto.MagicStriped:
+	default:
 		logger.Warn("Invalid MAGIC field")
-		sendErrorResponse(conn, proto.StatusBadRequest, "Invalid MAGIC field", logger)
+		sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Invalid MAGIC field", logger)
 		return
 	}
 
-	if hello.Version != proto.Version {
+	if hello.Version != proto.Version && hello.Version != proto.Version2 {
 		logger.Warn("Invalid VERSION field", "version", hello.Version)
-		sendErrorResponse(conn, proto.StatusBadRequest, "Invalid VERSION field", logger)
+		sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Invalid VERSION field", logger)
 		return
 	}
 
@@ -93,51 +116,124 @@ func handleClientConnection(
 				"remote_ip", remoteIP)
 		}
 
-		sendErrorResponse(conn, proto.StatusAuthFail, "Authentication failed", logger)
+		sendErrorResponse(conn, hello.Version, proto.StatusAuthFail, "Authentication failed", logger)
 		return
 	}
 
 	// Reset rate limiter on successful authentication
 	rateLimiter.Reset(remoteIP)
 
+	// A best-effort client gets the ports that can be bound and learns why
+	// the others were not. Striped sessions always bind all ports or none.
+	bestEffort := hello.BestEffort && hello.Stripe == nil
+	var rejected []proto.PortRejection
+
+	ports := make([]int, 0, len(hello.Ports))
 	for _, port := range hello.Ports {
 		if int(port) < portMin || int(port) > portMax {
 			logger.Warn("Port outside allowed range",
 				"port", port,
 				"min", portMin,
 				"max", portMax)
-			sendErrorResponse(conn, proto.StatusPortForbidden,
+			if bestEffort {
+				rejected = append(rejected, proto.PortRejection{Port: port, Status: proto.StatusPortForbidden})
+				continue
+			}
+			sendErrorResponse(conn, hello.Version, proto.StatusPortForbidden,
 				fmt.Sprintf("Port %d outside allowed range %d-%d", port, portMin, portMax), logger)
 			return
 		}
+		ports = append(ports, int(port))
+	}
+	if len(ports) == 0 {
+		sendErrorResponse(conn, hello.Version, proto.StatusPortForbidden,
+			fmt.Sprintf("No port inside allowed range %d-%d", portMin, portMax), logger)
+		return
 	}
 
 	logger.Info("HELLO validation successful", "client", hello.Name)
 
-	ports := make([]int, len(hello.Ports))
-	for i, p := range hello.Ports {
-		ports[i] = int(p)
+	// Version 2 clients may only use the features both sides support
+	if hello.Version == proto.Version2 {
+		caps := hello.Capabilities & proto.SupportedCapabilities
+		logger.Info("Negotiated capabilities",
+			"client", hello.Name,
+			"offered", hello.Capabilities,
+			"negotiated", caps)
+		if hello.Stripe != nil && !caps.Has(proto.CapStriping) {
+			logger.Warn("Striped HELLO without the striping capability")
+			sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Striped sessions require the striping capability", logger)
+			return
+		}
+		if !caps.Has(proto.CapClientInfo) {
+			hello.Info = nil
+		}
+		hello.Capabilities = caps
 	}
 
-	_, err = registry.ReservePorts(ports)
-	if err != nil {
-		logger.Warn("Port reservation failed", "error", err)
-		sendErrorResponse(conn, proto.StatusPortInUse, "One or more ports are already in use", logger)
-		return
+	clientID := uuid.New().String()
+
+	var group *sessionGroup
+	if hello.Stripe != nil {
+		clientID = uuid.UUID(hello.Stripe.SessionID).String()
+
+		var created bool
+		group, created, err = registry.JoinOrReserveGroup(clientID, ports, int(hello.Stripe.Members))
+		if err != nil {
+			logger.Warn("Port reservation failed", "error", err)
+			sendErrorResponse(conn, hello.Version, proto.StatusPortInUse, "One or more ports are already in use", logger)
+			return
+		}
+		if !created {
+			joinSessionGroup(conn, newSession, group, hello, logger)
+			return
+		}
+		// Unregister only after the deferred cleanup below has released the ports
+		defer registry.RemoveGroup(clientID)
+	} else if bestEffort {
+		ports, rejected = reserveBestEffort(registry, ports, rejected, logger)
+		if len(ports) == 0 {
+			sendErrorResponse(conn, hello.Version, proto.StatusPortInUse, "All ports are already in use", logger)
+			return
+		}
+	} else {
+		_, err = registry.ReservePorts(ports)
+		if err != nil {
+			logger.Warn("Port reservation failed", "error", err)
+			sendErrorResponse(conn, hello.Version, proto.StatusPortInUse, "One or more ports are already in use", logger)
+			return
+		}
+	}
+
+	// Ports on standby hand their address over to the client
+	for _, port := range ports {
+		socksManager.StopStandby(port)
+	}
+
+	held := &sessionPorts{
+		bindIP:       bindIP,
+		portMin:      portMin,
+		portMax:      portMax,
+		maxConns:     int32(maxConnsPerClient),
+		registry:     registry,
+		socksManager: socksManager,
+		logger:       logger,
+		ports:        slices.Clone(ports),
+		listeners:    make(map[int]net.Listener),
+		group:        group,
 	}
 
 	var cleanupOnce sync.Once
 	tcpListeners := make(map[int]net.Listener)
-	socksListeners := make(map[int]net.Listener)
 	cleanup := func() {
 		cleanupOnce.Do(func() {
-			for _, listener := range socksListeners {
-				_ = listener.Close()
-			}
 			for _, listener := range tcpListeners {
 				_ = listener.Close()
 			}
-			registry.ReleasePorts(ports)
+			held.close()
+			if group != nil {
+				_ = group.Close()
+			}
 		})
 	}
 	defer cleanup()
@@ -147,21 +243,36 @@ func handleClientConnection(
 		listener, err := net.Listen("tcp", addr)
 		if err != nil {
 			logger.Warn("Failed to bind port", "port", port, "error", err)
+			if bestEffort && len(held.held()) > 1 {
+				held.drop(port)
+				rejected = append(rejected, proto.PortRejection{Port: uint16(port), Status: proto.StatusPortInUse})
+				continue
+			}
 			cleanup()
-			sendErrorResponse(conn, proto.StatusPortInUse,
+			sendErrorResponse(conn, hello.Version, proto.StatusPortInUse,
 				fmt.Sprintf("Failed to bind port %d", port), logger)
 			return
 		}
 		tcpListeners[port] = listener
 	}
 
+	ports = held.held()
 	logger.Info("Ports bound successfully", "ports", ports)
 
-	resp := proto.HelloResp{
-		Version:       proto.Version,
-		Status:        proto.StatusOK,
-		AcceptedPorts: hello.Ports,
-		Message:       "Connection accepted",
+	resp := okResponse(hello, "Connection accepted")
+	if bestEffort {
+		resp.AcceptedPorts = make([]uint16, len(ports))
+		for i, port := range ports {
+			resp.AcceptedPorts[i] = uint16(port)
+		}
+		resp.Rejected = rejected
+		if len(rejected) > 0 {
+			resp.Message = fmt.Sprintf("Accepted %d of %d ports", len(ports), len(hello.Ports))
+			logger.Warn("Some ports were not accepted",
+				"client", hello.Name,
+				"accepted", ports,
+				"rejected", rejected)
+		}
 	}
 
 	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
@@ -184,63 +295,217 @@ func handleClientConnection(
 		return
 	}
 
-	yamuxConfig := yamux.DefaultConfig()
-	yamuxConfig.EnableKeepAlive = true
-	yamuxConfig.KeepAliveInterval = 30 * time.Second
-	yamuxConfig.ConnectionWriteTimeout = 10 * time.Second
-
-	session, err := yamux.Server(conn, yamuxConfig)
+	session, err := newSession(conn)
 	if err != nil {
-		logger.Error("Failed to create yamux session", "error", err)
+		logger.Error("Failed to create session", "error", err)
 		cleanup()
 		return
 	}
 
-	logger.Info("Yamux session created")
+	logger.Info("Session created")
 
-	clientID := uuid.New().String()
-	clientMeta := ClientMeta{
-		ClientName: hello.Name,
-		ClientID:   clientID,
+	// Striped clients share one logical session across their member connections
+	var portSession transport.Session = session
+	if group != nil {
+		if err := group.Add(session); err != nil {
+			logger.Error("Failed to add session to group", "error", err)
+			_ = session.Close()
+			cleanup()
+			return
+		}
+		portSession = group
 	}
 
+	clientMeta := newClientMeta(hello, clientID, conn.RemoteAddr())
+
 	for _, port := range ports {
 		if tcpListener, ok := tcpListeners[port]; ok {
 			_ = tcpListener.Close()
 			delete(tcpListeners, port)
 		}
 
-		socksListener, err := socksManager.StartListener(port, bindIP, session)
+		socksListener, err := socksManager.StartListener(port, bindIP, portSession)
 		if err != nil {
 			logger.Error("Failed to start SOCKS5 listener", "port", port, "error", err)
 			_ = session.Close()
 			cleanup()
 			return
 		}
-		socksListeners[port] = socksListener
+		held.bound(port, socksListener)
 
-		if err := registry.BindSession(port, session, socksListener, clientMeta, int32(maxConnsPerClient)); err != nil {
+		if err := registry.BindSession(port, portSession, socksListener, clientMeta, int32(maxConnsPerClient)); err != nil {
 			logger.Error("Failed to bind session to port", "port", port, "error", err)
 			_ = session.Close()
-			_ = socksListener.Close()
-			delete(socksListeners, port)
 			cleanup()
 			return
 		}
 	}
 
+	// Clients with a control stream may change their ports within the session
+	if hello.Capabilities.Has(proto.CapControl) {
+		held.session = portSession
+		held.meta = clientMeta
+		held.streams = &controlStreams{}
+		registry.SetControl(portSession, held.streams)
+		go serveControlStreams(portSession, held.streams, held.handleControl, clientMeta, logger)
+	}
+
 	logger.Info("Client session established",
 		"client_id", clientID,
 		"client_name", hello.Name,
-		"ports", ports)
+		"ports", ports,
+		"striped", group != nil,
+		"labels", clientMeta.Labels,
+		"version", clientMeta.Version,
+		"platform", clientMeta.Platform,
+		"hostname", clientMeta.Hostname,
+		"public_ip", clientMeta.PublicIP)
 
 	// Ensure cleanup happens even if session closes immediately
-	<-session.CloseChan()
+	<-portSession.CloseChan()
+}
+
+// joinSessionGroup adds a further member connection to an existing striped
+// session. The ports stay bound by the connection that created the group.
+func joinSessionGroup(conn net.Conn, newSession sessionFactory, group *sessionGroup, hello proto.Hello, logger *slog.Logger) {
+	if groupPorts := group.Ports(); !samePorts(groupPorts, hello.Ports) {
+		logger.Warn("Striped session member requested different ports",
+			"client_id", group.id,
+			"ports", hello.Ports,
+			"group_ports", groupPorts)
+		sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Ports do not match striped session", logger)
+		return
+	}
+
+	if group.IsClosed() {
+		sendErrorResponse(conn, hello.Version, proto.StatusServerInternal, "Striped session is closing, retry", logger)
+		return
+	}
+
+	if group.Members() >= group.maxMembers {
+		sendErrorResponse(conn, hello.Version, proto.StatusBadRequest, "Striped session is full", logger)
+		return
+	}
+
+	resp := okResponse(hello, "Joined striped session")
+
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		logger.Error("Failed to set write deadline", "error", err)
+		return
+	}
+
+	if err := proto.WriteHelloResp(conn, resp); err != nil {
+		logger.Error("Failed to write HELLO_RESP", "error", err)
+		return
+	}
+
+	if err := common.ClearDeadline(conn); err != nil {
+		logger.Error("Failed to clear deadline", "error", err)
+		return
+	}
+
+	session, err := newSession(conn)
+	if err != nil {
+		logger.Error("Failed to create session", "error", err)
+		return
+	}
+
+	if err := group.Add(session); err != nil {
+		logger.Warn("Failed to join striped session", "client_id", group.id, "error", err)
+		_ = session.Close()
+		return
+	}
+
+	logger.Info("Striped session member joined",
+		"client_id", group.id,
+		"client_name", hello.Name,
+		"members", group.Members())
+
+	select {
+	case <-session.CloseChan():
+	case <-group.CloseChan():
+	}
+
+	logger.Info("Striped session member left",
+		"client_id", group.id,
+		"members", group.Members())
 }
 
-func sendErrorResponse(conn net.Conn, status uint8, message string, logger *slog.Logger) {
+// reserveBestEffort reserves each of ports that is free, adding the others
+// to rejected. It returns the reserved ports.
+func reserveBestEffort(registry *Registry, ports []int, rejected []proto.PortRejection, logger *slog.Logger) ([]int, []proto.PortRejection) {
+	reserved := make([]int, 0, len(ports))
+	for _, port := range ports {
+		if _, err := registry.ReservePorts([]int{port}); err != nil {
+			logger.Warn("Port reservation failed", "port", port, "error", err)
+			rejected = append(rejected, proto.PortRejection{Port: uint16(port), Status: proto.StatusPortInUse})
+			continue
+		}
+		reserved = append(reserved, port)
+	}
+	return reserved, rejected
+}
+
+// samePorts reports whether a and b contain the same ports in the same order.
+func samePorts(a []int, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != int(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// okResponse accepts every port of hello, answering in the HELLO's version.
+// For version 2, hello.Capabilities must hold the negotiated capabilities.
+func okResponse(hello proto.Hello, message string) proto.HelloResp {
 	resp := proto.HelloResp{
-		Version:       proto.Version,
+		Version:       hello.Version,
+		Status:        proto.StatusOK,
+		AcceptedPorts: hello.Ports,
+		Message:       message,
+	}
+	if hello.Version == proto.Version2 {
+		resp.Capabilities = hello.Capabilities
+	}
+	return resp
+}
+
+// newClientMeta collects what the server knows about a client from its HELLO
+// and the address it connected from.
+func newClientMeta(hello proto.Hello, clientID string, remoteAddr net.Addr) ClientMeta {
+	meta := ClientMeta{
+		ClientName:   hello.Name,
+		ClientID:     clientID,
+		Capabilities: hello.Capabilities,
+	}
+	if remoteAddr != nil {
+		meta.RemoteAddr = remoteAddr.String()
+	}
+	if info := hello.Info; info != nil {
+		meta.Labels = info.Labels
+		meta.Version = info.Version
+		meta.Hostname = info.Hostname
+		meta.PublicIP = info.PublicIP
+		if info.OS != "" || info.Arch != "" {
+			meta.Platform = info.OS + "/" + info.Arch
+		}
+	}
+	return meta
+}
+
+// sendErrorResponse rejects a client. Errors are answered in the version of
+// the client's HELLO, or version 1 if it could not be told, so that clients
+// sending version 2 can tell this server from one predating it.
+func sendErrorResponse(conn net.Conn, version uint8, status uint8, message string, logger *slog.Logger) {
+	if version != proto.Version2 {
+		version = proto.Version
+	}
+	resp := proto.HelloResp{
+		Version:       version,
 		Status:        status,
 		AcceptedPorts: nil,
 		Message:       message,
@@ -290,6 +555,148 @@ func (s *Server) Start(ctx context.Context) error {
 
 	socksManager := NewSOCKSManager(s.registry, s.logger)
 
+	if s.config.AccessLogFile != "" {
+		accessFile, err := common.OpenRotatingFile(s.config.AccessLogFile,
+			s.config.AccessLogMaxSize, s.config.AccessLogMaxAge, s.config.AccessLogMaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		defer func() {
+			_ = accessFile.Close()
+		}()
+		socksManager.SetAccessLog(NewAccessLog(accessFile))
+		s.logger.Info("Access log enabled", "path", s.config.AccessLogFile)
+	}
+
+	if s.config.HasBandwidthLimits() {
+		socksManager.SetBandwidthLimiter(NewBandwidthLimiter(
+			s.config.PortBandwidth, s.config.ClientBandwidth, s.config.ClientBandwidthOverrides))
+		s.logger.Info("Bandwidth limits enabled",
+			"port_up", s.config.PortBandwidth.Up,
+			"port_down", s.config.PortBandwidth.Down,
+			"client_up", s.config.ClientBandwidth.Up,
+			"client_down", s.config.ClientBandwidth.Down,
+			"client_overrides", len(s.config.ClientBandwidthOverrides))
+	}
+
+	var accounting *Accounting
+	if s.config.AccountingFile != "" {
+		accounting, err = NewAccounting(s.config.AccountingFile, s.config.AccountingRetention)
+		if err != nil {
+			return err
+		}
+		interval := s.config.AccountingInterval
+		if interval <= 0 {
+			interval = defaultAccountingInterval
+		}
+		// Runs on its own context so the final save completes before Start returns
+		accountingCtx, stopAccounting := context.WithCancel(context.Background())
+		accountingDone := make(chan struct{})
+		go func() {
+			accounting.Run(accountingCtx, interval, s.logger)
+			close(accountingDone)
+		}()
+		defer func() {
+			stopAccounting()
+			<-accountingDone
+		}()
+		socksManager.SetAccounting(accounting)
+		s.logger.Info("Traffic accounting enabled",
+			"path", s.config.AccountingFile,
+			"interval", interval,
+			"retention", s.config.AccountingRetention)
+	}
+
+	if s.config.BreakerThreshold > 0 {
+		coolDown := s.config.BreakerCoolDown
+		if coolDown <= 0 {
+			coolDown = defaultBreakerCoolDown
+		}
+		s.registry.EnableBreakers(s.config.BreakerThreshold, coolDown)
+		s.logger.Info("Circuit breakers enabled",
+			"threshold", s.config.BreakerThreshold,
+			"cool_down", coolDown)
+	}
+
+	if s.config.HealthCheckInterval > 0 {
+		timeout := s.config.HealthCheckTimeout
+		if timeout <= 0 {
+			timeout = defaultHealthCheckTimeout
+		}
+		threshold := s.config.HealthFailureThreshold
+		if threshold <= 0 {
+			threshold = defaultHealthFailureThreshold
+		}
+		checker := NewHealthChecker(s.registry, HealthCheckConfig{
+			Interval:     s.config.HealthCheckInterval,
+			Timeout:      timeout,
+			Target:       s.config.HealthCheckTarget,
+			DegradedRTT:  s.config.HealthDegradedRTT,
+			FailureLimit: threshold,
+		}, s.logger)
+		go checker.Run(ctx)
+		s.logger.Info("Health checks enabled",
+			"interval", s.config.HealthCheckInterval,
+			"timeout", timeout,
+			"target", s.config.HealthCheckTarget,
+			"failure_threshold", threshold)
+	}
+
+	if s.config.StatusListenAddr != "" {
+		if err := s.startStatus(ctx, accounting); err != nil {
+			return err
+		}
+	}
+
+	if len(s.config.PortFallbacks) > 0 {
+		direct, err := NewDirectDialer(s.config.DirectAllowPrivateNetworks, s.config.DirectBlockedNetworks)
+		if err != nil {
+			return err
+		}
+		socksManager.SetFallbacks(s.config.PortFallbacks, direct)
+		defer socksManager.StopAllStandby()
+		for port := range s.config.PortFallbacks {
+			if err := socksManager.StartStandby(port, s.config.BindIP); err != nil {
+				return fmt.Errorf("failed to start fallback listener: %w", err)
+			}
+		}
+		s.logger.Info("Port fallbacks enabled", "ports", len(s.config.PortFallbacks))
+	}
+
+	if s.config.RouteListenAddr != "" {
+		rules, err := LoadRouteRules(s.config.RouteRulesFile)
+		if err != nil {
+			return err
+		}
+		routeListener, err := socksManager.StartRouteListener(s.config.RouteListenAddr, NewRouter(rules, s.config.RouteFallback))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = routeListener.Close()
+		}()
+		s.logger.Info("Routing enabled",
+			"listen", s.config.RouteListenAddr,
+			"rules", len(rules),
+			"fallback", s.config.RouteFallback)
+	}
+
+	if s.config.QUICListenAddr != "" {
+		if err := s.startQUIC(ctx, connLimiter, rateLimiter, socksManager); err != nil {
+			return err
+		}
+	}
+
+	if s.config.WebSocketListenAddr != "" {
+		wsListener, err := s.startWebSocket(ctx)
+		if err != nil {
+			return err
+		}
+		go func() {
+			_ = s.acceptLoop(ctx, wsListener, connLimiter, rateLimiter, socksManager)
+		}()
+	}
+
 	done := make(chan struct{})
 	defer close(done)
 
@@ -302,6 +709,17 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}()
 
+	return s.acceptLoop(ctx, listener, connLimiter, rateLimiter, socksManager)
+}
+
+// acceptLoop accepts client connections from listener until ctx is canceled.
+func (s *Server) acceptLoop(
+	ctx context.Context,
+	listener net.Listener,
+	connLimiter *ConnectionLimiter,
+	rateLimiter *IPRateLimiter,
+	socksManager *SOCKSManager,
+) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -309,6 +727,9 @@ func (s *Server) Start(ctx context.Context) error {
 			case <-ctx.Done():
 				return ctx.Err()
 			default:
+				if errors.Is(err, transport.ErrListenerClosed) {
+					return err
+				}
 				s.logger.Error("Failed to accept connection", "error", err)
 				continue
 			}
@@ -328,6 +749,7 @@ func (s *Server) Start(ctx context.Context) error {
 
 		go handleClientConnection(
 			conn,
+			yamuxSession,
 			connLimiter,
 			rateLimiter,
 			s.config.Token,
@@ -2,13 +2,29 @@ package server
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
 	"log/slog"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/yamux"
 	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
 )
 
 func TestServerRateLimiterIntegration(t *testing.T) {
@@ -179,3 +195,716 @@ func TestServerRateLimiterIntegration(t *testing.T) {
 	cancel()
 	time.Sleep(50 * time.Millisecond)
 }
+
+func TestServerWebSocketTransport(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	wsAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:          "127.0.0.1:0",
+		BindIP:              "127.0.0.1",
+		Token:               token,
+		PortMin:             20000,
+		PortMax:             20010,
+		MaxClients:          10,
+		MaxAuthFailures:     5,
+		AuthBlockDuration:   time.Minute,
+		MaxConnsPerClient:   100,
+		WebSocketListenAddr: wsAddr,
+		WebSocketPath:       "/rsk",
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dialCancel()
+
+	conn, err := transport.DialWebSocket(dialCtx, "ws://"+wsAddr+"/rsk", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial WebSocket: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	hello := proto.Hello{
+		Magic:   [4]byte{'R', 'S', 'K', '1'},
+		Version: proto.Version,
+		Token:   token,
+		Name:    "ws-client",
+		Ports:   []uint16{20002},
+	}
+	if err := proto.WriteHello(conn, hello); err != nil {
+		t.Fatalf("Failed to write HELLO: %v", err)
+	}
+
+	resp, err := proto.ReadHelloResp(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d (%s)", resp.Status, resp.Message)
+	}
+
+	// Session binding happens right after the response is written
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := srv.registry.GetSession(20002); !ok {
+		t.Error("Expected session to be bound for port 20002")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and returns the file paths.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerQUICTransport(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	certFile, keyFile := writeTestCertificate(t)
+
+	cfg := &Config{
+		ListenAddr:        "127.0.0.1:0",
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+		QUICListenAddr:    "127.0.0.1:19529",
+		TLSCertFile:       certFile,
+		TLSKeyFile:        keyFile,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Config validation failed: %v", err)
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dialCancel()
+
+	sess, err := transport.DialQUIC(dialCtx, "127.0.0.1:19529", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial QUIC: %v", err)
+	}
+	defer func() { _ = sess.Close() }()
+
+	stream, err := sess.Open()
+	if err != nil {
+		t.Fatalf("Failed to open handshake stream: %v", err)
+	}
+
+	hello := proto.Hello{
+		Magic:   [4]byte{'R', 'S', 'K', '1'},
+		Version: proto.Version,
+		Token:   token,
+		Name:    "quic-client",
+		Ports:   []uint16{20003},
+	}
+	if err := proto.WriteHello(stream, hello); err != nil {
+		t.Fatalf("Failed to write HELLO: %v", err)
+	}
+
+	resp, err := proto.ReadHelloResp(stream)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d (%s)", resp.Status, resp.Message)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	serverSess, ok := srv.registry.GetSession(20003)
+	if !ok {
+		t.Fatal("Expected session to be bound for port 20003")
+	}
+
+	// A stream opened by the server arrives at the client as a native QUIC stream
+	out, err := serverSess.Open()
+	if err != nil {
+		t.Fatalf("Failed to open server stream: %v", err)
+	}
+	defer func() { _ = out.Close() }()
+	if err := proto.WriteConnectReq(out, "example.com:80"); err != nil {
+		t.Fatalf("Failed to write CONNECT_REQ: %v", err)
+	}
+
+	in, err := sess.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	addr, err := proto.ReadConnectReq(in)
+	if err != nil {
+		t.Fatalf("Failed to read CONNECT_REQ: %v", err)
+	}
+	if addr != "example.com:80" {
+		t.Errorf("Expected example.com:80, got %s", addr)
+	}
+}
+
+func TestServerClientInfo(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:        listenAddr,
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	hello := proto.Hello{
+		Magic:        proto.HelloMagic(false),
+		Version:      proto.Version2,
+		Token:        token,
+		Name:         "exit-fra-1",
+		Ports:        []uint16{20003},
+		Capabilities: proto.CapClientInfo,
+		Info: &proto.ClientInfo{
+			Version:  "v1.4.0",
+			OS:       "linux",
+			Arch:     "arm64",
+			Hostname: "fra-1",
+			PublicIP: "203.0.113.7",
+			Labels:   map[string]string{"region": "eu-central"},
+		},
+	}
+	if err := proto.WriteHello(conn, hello); err != nil {
+		t.Fatalf("Failed to write HELLO: %v", err)
+	}
+	resp, err := proto.ReadHelloResp(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d (%s)", resp.Status, resp.Message)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	meta, ok := srv.registry.GetClientMeta(20003)
+	if !ok {
+		t.Fatal("Expected session to be bound for port 20003")
+	}
+	if meta.Labels["region"] != "eu-central" || meta.Platform != "linux/arm64" || meta.Version != "v1.4.0" ||
+		meta.Hostname != "fra-1" || meta.PublicIP != "203.0.113.7" {
+		t.Errorf("Unexpected client metadata: %+v", meta)
+	}
+	if meta.RemoteAddr != conn.LocalAddr().String() {
+		t.Errorf("RemoteAddr = %q, want %q", meta.RemoteAddr, conn.LocalAddr().String())
+	}
+
+	if _, _, ok := srv.registry.SelectClient(ClientSelector{Labels: map[string]string{"region": "eu-central"}}); !ok {
+		t.Error("Expected the client to match its labels")
+	}
+}
+
+func TestServerHelloV2(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:        listenAddr,
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	handshake := func(hello proto.Hello) (net.Conn, proto.HelloResp) {
+		conn, err := net.Dial("tcp", listenAddr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		if err := proto.WriteHello(conn, hello); err != nil {
+			t.Fatalf("Failed to write HELLO: %v", err)
+		}
+		resp, err := proto.ReadHelloResp(conn)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return conn, resp
+	}
+
+	// Capabilities the server does not know are left out of the common set
+	conn, resp := handshake(proto.Hello{
+		Magic:        proto.HelloMagic(false),
+		Version:      proto.Version2,
+		Token:        token,
+		Name:         "v2-client",
+		Ports:        []uint16{20004},
+		Info:         &proto.ClientInfo{Labels: map[string]string{"region": "eu"}},
+		Capabilities: proto.CapClientInfo | 1<<31,
+		Extensions:   []proto.Extension{{Type: 0x7f, Data: []byte("ignored")}},
+	})
+	defer func() { _ = conn.Close() }()
+	if resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d (%s)", resp.Status, resp.Message)
+	}
+	if resp.Version != proto.Version2 || resp.Capabilities != proto.CapClientInfo {
+		t.Errorf("Response version %d with capabilities %v, want version 2 with %v",
+			resp.Version, resp.Capabilities, proto.CapClientInfo)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if meta, ok := srv.registry.GetClientMeta(20004); !ok || meta.Labels["region"] != "eu" {
+		t.Errorf("Expected labels for port 20004, got %+v", meta)
+	}
+
+	// Striping must be negotiated
+	conn2, resp := handshake(proto.Hello{
+		Magic:        proto.HelloMagic(false),
+		Version:      proto.Version2,
+		Token:        token,
+		Name:         "v2-striped",
+		Ports:        []uint16{20005},
+		Stripe:       &proto.StripeInfo{Members: 2},
+		Capabilities: proto.CapClientInfo,
+	})
+	defer func() { _ = conn2.Close() }()
+	if resp.Status != proto.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest, got %d (%s)", resp.Status, resp.Message)
+	}
+	// Errors are answered in the HELLO's version, so the client does not
+	// take this server for one predating version 2
+	if resp.Version != proto.Version2 {
+		t.Errorf("Error response version %d, want %d", resp.Version, proto.Version2)
+	}
+}
+
+func TestServerControlStream(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:        listenAddr,
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	connect := func(name string, port uint16, caps proto.Capabilities) *yamux.Session {
+		conn, err := net.Dial("tcp", listenAddr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		hello := proto.Hello{
+			Magic:        proto.HelloMagic(false),
+			Version:      proto.Version2,
+			Token:        token,
+			Name:         name,
+			Ports:        []uint16{port},
+			Capabilities: caps,
+		}
+		if err := proto.WriteHello(conn, hello); err != nil {
+			t.Fatalf("Failed to write HELLO: %v", err)
+		}
+		resp, err := proto.ReadHelloResp(conn)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if resp.Status != proto.StatusOK || resp.Capabilities != caps {
+			t.Fatalf("Unexpected response %+v", resp)
+		}
+		session, err := yamux.Client(conn, nil)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		t.Cleanup(func() { _ = session.Close() })
+		return session
+	}
+
+	session := connect("controlled", 20006, proto.CapControl)
+	stream, err := session.Open()
+	if err != nil {
+		t.Fatalf("Failed to open control stream: %v", err)
+	}
+	control := proto.NewControlConn(stream, func(method string, _ []byte) (any, error) {
+		if method == proto.MethodStats {
+			return proto.ClientStats{ActiveStreams: 4, TotalStreams: 9}, nil
+		}
+		return nil, proto.ErrUnknownControlMethod
+	})
+	go func() { _ = control.Serve() }()
+
+	// The server answers pings from the client
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	if err := control.Call(callCtx, proto.MethodPing, nil, nil); err != nil {
+		t.Fatalf("Ping over control stream failed: %v", err)
+	}
+
+	// A second control stream on the same connection is closed by the server
+	extra, err := session.Open()
+	if err != nil {
+		t.Fatalf("Failed to open second stream: %v", err)
+	}
+	_ = extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the second control stream to be closed, got %v", err)
+	}
+
+	connect("legacy", 20007, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	handler := NewControlHandler(srv.registry, proto.MethodStats, http.MethodGet)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ClientStatsPath, nil))
+	var results []ControlResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Failed to decode results: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", results)
+	}
+	var stats proto.ClientStats
+	if err := json.Unmarshal(results[0].Result, &stats); err != nil || results[0].ClientName != "controlled" || stats.ActiveStreams != 4 {
+		t.Errorf("Unexpected result for controlled client: %+v (%v)", results[0], err)
+	}
+	if results[1].ClientName != "legacy" || results[1].Error == "" {
+		t.Errorf("Expected an error for the client without control stream, got %+v", results[1])
+	}
+
+	// Failures reported by the client are passed on; clients can be picked by name
+	results = CallClients(callCtx, srv.registry, "controlled", proto.MethodReload)
+	if len(results) != 1 || results[0].Error == "" {
+		t.Errorf("Expected one failed reload, got %+v", results)
+	}
+
+	rec = httptest.NewRecorder()
+	NewControlHandler(srv.registry, proto.MethodReload, http.MethodPost).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ClientReloadPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d for GET reload, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+
+	// Reloading requires the server token
+	reload := RequireToken(token, NewControlHandler(srv.registry, proto.MethodReload, http.MethodPost))
+	for _, auth := range []string{"", "Bearer wrong-token", string(token)} {
+		rec = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, ClientReloadPath, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		reload.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for Authorization %q, got %d", http.StatusUnauthorized, auth, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, ClientReloadPath+"?client=controlled", nil)
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	reload.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d with the server token, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestServerPortChanges(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:        listenAddr,
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	hello := proto.Hello{
+		Magic:        proto.HelloMagic(false),
+		Version:      proto.Version2,
+		Token:        token,
+		Name:         "mover",
+		Ports:        []uint16{20008},
+		Capabilities: proto.CapControl,
+	}
+	if err := proto.WriteHello(conn, hello); err != nil {
+		t.Fatalf("Failed to write HELLO: %v", err)
+	}
+	if resp, err := proto.ReadHelloResp(conn); err != nil || resp.Status != proto.StatusOK {
+		t.Fatalf("Handshake failed: %+v, %v", resp, err)
+	}
+	session, err := yamux.Client(conn, nil)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer func() { _ = session.Close() }()
+	stream, err := session.Open()
+	if err != nil {
+		t.Fatalf("Failed to open control stream: %v", err)
+	}
+	control := proto.NewControlConn(stream, nil)
+	go func() { _ = control.Serve() }()
+
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	change := func(method string, ports ...int) ([]int, error) {
+		var result proto.PortsResult
+		err := control.Call(callCtx, method, proto.PortsRequest{Ports: ports}, &result)
+		return result.Ports, err
+	}
+
+	ports, err := change(proto.MethodAddPorts, 20009)
+	if err != nil {
+		t.Fatalf("add_ports failed: %v", err)
+	}
+	if fmt.Sprint(ports) != "[20008 20009]" {
+		t.Errorf("Expected ports [20008 20009], got %v", ports)
+	}
+	if meta, ok := srv.registry.GetClientMeta(20009); !ok || meta.ClientName != "mover" {
+		t.Errorf("Expected port 20009 bound to the client, got %+v", meta)
+	}
+	if socksConn, err := net.Dial("tcp", "127.0.0.1:20009"); err != nil {
+		t.Errorf("SOCKS5 listener on added port not reachable: %v", err)
+	} else {
+		_ = socksConn.Close()
+	}
+
+	// Ports outside the range or already held are refused as a whole
+	if _, err := change(proto.MethodAddPorts, 20010, 30000); err == nil {
+		t.Error("Expected add_ports outside the range to fail")
+	}
+	if srv.registry.IsReserved(20010) {
+		t.Error("Port 20010 reserved by a refused request")
+	}
+	if _, err := change(proto.MethodAddPorts, 20009); err == nil {
+		t.Error("Expected add_ports of a held port to fail")
+	}
+
+	ports, err = change(proto.MethodRemovePorts, 20008)
+	if err != nil {
+		t.Fatalf("remove_ports failed: %v", err)
+	}
+	if fmt.Sprint(ports) != "[20009]" {
+		t.Errorf("Expected ports [20009], got %v", ports)
+	}
+	if srv.registry.IsReserved(20008) {
+		t.Error("Port 20008 still reserved after removal")
+	}
+	if _, err := change(proto.MethodRemovePorts, 20009); err == nil {
+		t.Error("Expected removing the last port to fail")
+	}
+
+	// The session still works, and its ports are released when it ends
+	if err := control.Call(callCtx, proto.MethodPing, nil, nil); err != nil {
+		t.Errorf("Ping after port changes failed: %v", err)
+	}
+	_ = session.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.registry.IsReserved(20009) {
+		if time.Now().After(deadline) {
+			t.Fatal("Added port not released after the session closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerBestEffortPorts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	token := []byte("test-token-12345")
+	listenAddr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+
+	cfg := &Config{
+		ListenAddr:        listenAddr,
+		BindIP:            "127.0.0.1",
+		Token:             token,
+		PortMin:           20000,
+		PortMax:           20010,
+		MaxClients:        10,
+		MaxAuthFailures:   5,
+		AuthBlockDuration: time.Minute,
+		MaxConnsPerClient: 100,
+	}
+	srv := NewServer(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = srv.Start(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	handshake := func(hello proto.Hello) (net.Conn, proto.HelloResp) {
+		conn, err := net.Dial("tcp", listenAddr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		hello.Magic = proto.HelloMagic(false)
+		hello.Token = token
+		if err := proto.WriteHello(conn, hello); err != nil {
+			t.Fatalf("Failed to write HELLO: %v", err)
+		}
+		resp, err := proto.ReadHelloResp(conn)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return conn, resp
+	}
+
+	// One port is held by another client, one by another process
+	if _, resp := handshake(proto.Hello{Version: proto.Version, Name: "first", Ports: []uint16{20001}}); resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK for first client, got %d (%s)", resp.Status, resp.Message)
+	}
+	blocker, err := net.Listen("tcp", "127.0.0.1:20003")
+	if err != nil {
+		t.Fatalf("Failed to occupy port 20003: %v", err)
+	}
+	defer func() { _ = blocker.Close() }()
+	time.Sleep(50 * time.Millisecond)
+
+	ports := []uint16{20001, 20002, 20003, 30000}
+
+	// Without the flag, the whole client is rejected
+	_, resp := handshake(proto.Hello{Version: proto.Version2, Name: "strict", Ports: ports})
+	if resp.Status != proto.StatusPortForbidden {
+		t.Errorf("Expected StatusPortForbidden, got %d (%s)", resp.Status, resp.Message)
+	}
+
+	_, resp = handshake(proto.Hello{Version: proto.Version2, Name: "partial", Ports: ports, BestEffort: true})
+	if resp.Status != proto.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d (%s)", resp.Status, resp.Message)
+	}
+	if fmt.Sprint(resp.AcceptedPorts) != "[20002]" {
+		t.Errorf("Expected accepted ports [20002], got %v", resp.AcceptedPorts)
+	}
+	want := []proto.PortRejection{
+		{Port: 30000, Status: proto.StatusPortForbidden},
+		{Port: 20001, Status: proto.StatusPortInUse},
+		{Port: 20003, Status: proto.StatusPortInUse},
+	}
+	if fmt.Sprint(resp.Rejected) != fmt.Sprint(want) {
+		t.Errorf("Expected rejections %v, got %v", want, resp.Rejected)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if meta, ok := srv.registry.GetClientMeta(20002); !ok || meta.ClientName != "partial" {
+		t.Errorf("Expected port 20002 bound to the partial client, got %+v", meta)
+	}
+	if srv.registry.IsReserved(20003) {
+		t.Error("Port 20003 still reserved after failing to bind")
+	}
+
+	// With no port left, best effort fails like a strict HELLO
+	_, resp = handshake(proto.Hello{Version: proto.Version2, Name: "late", Ports: []uint16{20001, 20002}, BestEffort: true})
+	if resp.Status != proto.StatusPortInUse {
+		t.Errorf("Expected StatusPortInUse, got %d (%s)", resp.Status, resp.Message)
+	}
+}
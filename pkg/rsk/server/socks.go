@@ -2,40 +2,174 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/armon/go-socks5"
-	"github.com/hashicorp/yamux"
 	"github.com/tbxark/rsk/pkg/rsk/common"
 	"github.com/tbxark/rsk/pkg/rsk/proto"
+	"github.com/tbxark/rsk/pkg/rsk/transport"
 )
 
+// connectStatusTimeout bounds the wait for a client's CONNECT_RESP. Clients
+// resolve and then dial the target, each within their dial timeout.
+const connectStatusTimeout = 35 * time.Second
+
 type SOCKSManager struct {
-	registry *Registry    // Port registry
-	logger   *slog.Logger // Logger instance
+	registry   *Registry         // Port registry
+	logger     *slog.Logger      // Logger instance
+	accessLog  *AccessLog        // Optional per-connection access log
+	bandwidth  *BandwidthLimiter // Optional throughput limits
+	accounting *Accounting       // Optional per-client traffic accounting
+
+	fallbacks map[int]PortFallback // Optional fallbacks for ports whose client is offline
+	direct    *DirectDialer        // Dials targets for FallbackDirect
+
+	standbyMu sync.Mutex
+	standby   map[int]net.Listener // Fallback listeners of ports without a client
 }
 
-// connCountingStream wraps a net.Conn to decrement connection count on close
+// connCountingStream wraps a net.Conn to decrement connection count on close.
+// It also counts the bytes relayed and the reason the connection ended for the access log.
 type connCountingStream struct {
 	net.Conn
 	port      int
 	registry  *Registry
 	logger    *slog.Logger
 	closeOnce sync.Once
+
+	session   transport.Session
+	entry     AccessEntry
+	accessLog *AccessLog
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+
+	usage      *liveUsage // Bytes charged to the accounting, if enabled
+	accounting *Accounting
+	clientName string
+
+	breaker     *CircuitBreaker // Optional breaker told whether the target answered
+	outcomeOnce sync.Once
+
+	reasonOnce sync.Once
+	reason     string
+	reasonErr  error
+}
+
+func (c *connCountingStream) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesDown.Add(int64(n))
+	if n > 0 {
+		c.reportOutcome(true)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			c.setReason(CloseReasonTarget, nil)
+		} else {
+			c.setReason(CloseReasonError, err)
+		}
+	}
+	return n, err
+}
+
+func (c *connCountingStream) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesUp.Add(int64(n))
+	if err != nil {
+		c.setReason(CloseReasonError, err)
+	}
+	return n, err
+}
+
+// LocalAddr returns the stream's local address as a *net.TCPAddr, which
+// go-socks5 requires for the CONNECT reply. Streams over QUIC carry UDP addresses.
+func (c *connCountingStream) LocalAddr() net.Addr {
+	switch addr := c.Conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		return addr
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+	default:
+		return &net.TCPAddr{}
+	}
+}
+
+// CloseWrite is called by the SOCKS server once the consumer has finished
+// sending. The stream stays open for the response; only the close reason is noted.
+func (c *connCountingStream) CloseWrite() error {
+	c.setReason(CloseReasonConsumer, nil)
+	return nil
+}
+
+// setReason records why the connection ended. The first reason wins; errors
+// caused by the client session going away are reported as such.
+func (c *connCountingStream) setReason(reason string, err error) {
+	c.reasonOnce.Do(func() {
+		if reason == CloseReasonError && c.session != nil && c.session.IsClosed() {
+			reason = CloseReasonSession
+		}
+		c.reason = reason
+		c.reasonErr = err
+	})
+}
+
+// reportOutcome tells the breaker, once, whether the target answered. It is
+// only used for clients without connect status: a stream such a client
+// closes before any data arrives may have been refused by its policy or by
+// the target, so it releases a trial but never counts as a failure.
+func (c *connCountingStream) reportOutcome(answered bool) {
+	if c.breaker == nil {
+		return
+	}
+	c.outcomeOnce.Do(func() {
+		if !answered {
+			c.breaker.Cancel()
+			return
+		}
+		if c.breaker.Success() {
+			c.logger.Info("Circuit breaker closed", "port", c.port, "client_name", c.clientName)
+		}
+	})
 }
 
 func (c *connCountingStream) Close() error {
 	var err error
 	c.closeOnce.Do(func() {
+		c.setReason(CloseReasonConsumer, nil)
+		c.reportOutcome(false)
 		err = c.Conn.Close()
-		c.registry.DecrementConnections(c.port)
-		c.logger.Debug("Connection closed, decremented count",
-			"port", c.port,
-			"remaining", c.registry.GetConnectionCount(c.port))
+		// Direct fallback connections belong to no client slot
+		if c.registry != nil {
+			c.registry.DecrementConnections(c.port)
+			c.logger.Debug("Connection closed, decremented count",
+				"port", c.port,
+				"remaining", c.registry.GetConnectionCount(c.port))
+		}
+
+		if c.usage != nil {
+			c.accounting.untrack(c.usage)
+		}
+
+		if c.accessLog != nil {
+			entry := c.entry
+			entry.End = time.Now()
+			entry.BytesUp = c.bytesUp.Load()
+			entry.BytesDown = c.bytesDown.Load()
+			entry.CloseReason = c.reason
+			if c.reasonErr != nil {
+				entry.ErrorMessage = c.reasonErr.Error()
+			}
+			if logErr := c.accessLog.Record(entry); logErr != nil {
+				c.logger.Error("Failed to write access log", "error", logErr)
+			}
+		}
 	})
 	return err
 }
@@ -45,64 +179,421 @@ func NewSOCKSManager(registry *Registry, logger *slog.Logger) *SOCKSManager {
 	return &SOCKSManager{
 		registry: registry,
 		logger:   logger,
+		standby:  make(map[int]net.Listener),
 	}
 }
 
-func (m *SOCKSManager) createDialer(port int, sess *yamux.Session) func(ctx context.Context, network, addr string) (net.Conn, error) {
+// SetFallbacks configures what ports do while their client is offline.
+// direct is required if any fallback is FallbackDirect. It must be called
+// before any listener is started.
+func (m *SOCKSManager) SetFallbacks(fallbacks map[int]PortFallback, direct *DirectDialer) {
+	m.fallbacks = fallbacks
+	m.direct = direct
+}
+
+// SetBandwidthLimiter throttles every SOCKS connection with limiter.
+// It must be called before any listener is started.
+func (m *SOCKSManager) SetBandwidthLimiter(limiter *BandwidthLimiter) {
+	m.bandwidth = limiter
+}
+
+// SetAccounting enables counting the traffic of every SOCKS connection in
+// accounting. It must be called before any listener is started.
+func (m *SOCKSManager) SetAccounting(accounting *Accounting) {
+	m.accounting = accounting
+}
+
+// SetAccessLog enables recording every SOCKS connection to log.
+// It must be called before any listener is started.
+func (m *SOCKSManager) SetAccessLog(log *AccessLog) {
+	m.accessLog = log
+}
+
+// remoteResolver is a socks5.NameResolver that leaves host names unresolved,
+// so they are forwarded in the CONNECT_REQ and resolved by the client at the
+// exit, where its domain rules and address filter apply.
+type remoteResolver struct{}
+
+func (remoteResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return ctx, nil, nil
+}
+
+type socksRequestKey struct{}
+
+// requestRecorder is a socks5.AddressRewriter that leaves the destination
+// unchanged but makes the request available to the dialer through the context.
+type requestRecorder struct{}
+
+func (requestRecorder) Rewrite(ctx context.Context, req *socks5.Request) (context.Context, *socks5.AddrSpec) {
+	return context.WithValue(ctx, socksRequestKey{}, req), req.DestAddr
+}
+
+// newAccessEntry fills in the connection details known when dialing.
+func (m *SOCKSManager) newAccessEntry(ctx context.Context, port int, addr string) AccessEntry {
+	entry := AccessEntry{
+		Start:  time.Now(),
+		Port:   port,
+		Target: addr,
+	}
+	if meta, ok := m.registry.GetClientMeta(port); ok {
+		entry.ClientName = meta.ClientName
+		entry.ClientID = meta.ClientID
+	}
+	// Connections relayed by a fallback are logged under the port the consumer used
+	if fb, ok := ctx.Value(fallbackKey{}).(fallbackInfo); ok {
+		entry.Port = fb.port
+		entry.Fallback = fb.fallback
+	}
+	if req, ok := ctx.Value(socksRequestKey{}).(*socks5.Request); ok {
+		if req.RemoteAddr != nil {
+			entry.Consumer = req.RemoteAddr.Address()
+		}
+		// Prefer the hostname the consumer asked for over the resolved address
+		if req.DestAddr != nil && req.DestAddr.FQDN != "" {
+			entry.Target = net.JoinHostPort(req.DestAddr.FQDN, strconv.Itoa(req.DestAddr.Port))
+		}
+	}
+	return entry
+}
+
+// recordFailure logs a connection that never reached the client.
+func (m *SOCKSManager) recordFailure(entry AccessEntry, reason string, err error) {
+	if m.accessLog == nil {
+		return
+	}
+	entry.End = time.Now()
+	entry.CloseReason = reason
+	if err != nil {
+		entry.ErrorMessage = err.Error()
+	}
+	if logErr := m.accessLog.Record(entry); logErr != nil {
+		m.logger.Error("Failed to write access log", "error", logErr)
+	}
+}
+
+// createDialer relays connections through sess, or through the port's
+// fallback once sess is closed, if it is nil, or while the port's breaker is open.
+func (m *SOCKSManager) createDialer(port int, sess transport.Session) func(ctx context.Context, network, addr string) (net.Conn, error) {
 	return func(ctx context.Context, network, addr string) (net.Conn, error) {
-		// Try to increment connection count before opening stream
-		if !m.registry.IncrementConnections(port) {
-			m.logger.Warn("Per-client connection limit reached",
-				"port", port,
-				"current", m.registry.GetConnectionCount(port))
-			return nil, fmt.Errorf("connection limit reached for client")
-		}
-
-		// Ensure decrement happens when connection closes
-		decremented := false
-		defer func() {
-			if !decremented {
-				m.registry.DecrementConnections(port)
+		if sess == nil || sess.IsClosed() || !m.breakerReady(port) {
+			if fallback, ok := m.fallbacks[port]; ok {
+				return m.dialFallback(ctx, port, fallback, addr)
 			}
-		}()
+		}
+		return m.dial(ctx, port, sess, addr)
+	}
+}
+
+// breakerReady reports whether the breaker of port, if any, lets connections through.
+func (m *SOCKSManager) breakerReady(port int) bool {
+	breaker := m.registry.Breaker(port)
+	return breaker == nil || breaker.Ready()
+}
+
+type fallbackKey struct{}
+
+// fallbackInfo marks a connection handled by the fallback of port.
+type fallbackInfo struct {
+	port     int
+	fallback string
+}
+
+// dialFallback handles a connection to a port whose client is offline.
+func (m *SOCKSManager) dialFallback(ctx context.Context, port int, fallback PortFallback, addr string) (net.Conn, error) {
+	ctx = context.WithValue(ctx, fallbackKey{}, fallbackInfo{port: port, fallback: fallback.String()})
 
-		stream, err := sess.OpenStream()
+	switch fallback.Mode {
+	case FallbackPort:
+		if sess, ok := m.registry.GetSession(fallback.Port); ok && !sess.IsClosed() {
+			m.logger.Debug("Relaying through fallback port", "port", port, "fallback_port", fallback.Port, "addr", addr)
+			return m.dial(ctx, fallback.Port, sess, addr)
+		}
+		err := fmt.Errorf("clients of port %d and fallback port %d are offline", port, fallback.Port)
+		m.recordFailure(m.newAccessEntry(ctx, port, addr), CloseReasonClientOffline, err)
+		return nil, err
+	case FallbackDirect:
+		return m.dialDirect(ctx, port, addr)
+	default:
+		err := fmt.Errorf("client of port %d is offline", port)
+		m.recordFailure(m.newAccessEntry(ctx, port, addr), CloseReasonClientOffline, err)
+		return nil, err
+	}
+}
+
+// dialDirect connects to addr from the server itself.
+func (m *SOCKSManager) dialDirect(ctx context.Context, port int, addr string) (net.Conn, error) {
+	var entry AccessEntry
+	if m.accessLog != nil {
+		entry = m.newAccessEntry(ctx, port, addr)
+	}
+
+	conn, err := m.direct.Dial(ctx, addr)
+	if err != nil {
+		m.logger.Warn("Direct fallback dial failed", "port", port, "addr", addr, "error", err)
+		m.recordFailure(entry, CloseReasonDialFailed, err)
+		return nil, err
+	}
+	m.logger.Debug("Dialed target directly", "port", port, "addr", addr)
+	counted := &connCountingStream{
+		Conn:       conn,
+		port:       port,
+		logger:     m.logger,
+		entry:      entry,
+		accessLog:  m.accessLog,
+		accounting: m.accounting,
+		clientName: DirectAccountName,
+	}
+	conn = counted
+	if m.accounting != nil {
+		m.accounting.AddConnection(DirectAccountName, port)
+		counted.usage = m.accounting.track(DirectAccountName, port, &counted.bytesUp, &counted.bytesDown)
+	}
+	if m.bandwidth != nil {
+		conn = m.bandwidth.WrapDirect(conn, port)
+	}
+	return conn, nil
+}
+
+// StartStandby serves port's fallback on a listener of its own while no
+// client holds the port. It does nothing for ports without a fallback, ports
+// reserved by a client, or ports already on standby.
+func (m *SOCKSManager) StartStandby(port int, bindIP string) error {
+	fallback, ok := m.fallbacks[port]
+	if !ok {
+		return nil
+	}
+
+	m.standbyMu.Lock()
+	defer m.standbyMu.Unlock()
+
+	if _, exists := m.standby[port]; exists || m.registry.IsReserved(port) {
+		return nil
+	}
+	listener, err := m.startSOCKS(fmt.Sprintf("%s:%d", bindIP, port), m.createDialer(port, nil),
+		"port", port, "fallback", fallback.String())
+	if err != nil {
+		return err
+	}
+	m.standby[port] = listener
+	return nil
+}
+
+// StopStandby closes port's fallback listener, if any, so a client can bind
+// the port. The port must already be reserved for the client.
+func (m *SOCKSManager) StopStandby(port int) {
+	m.standbyMu.Lock()
+	defer m.standbyMu.Unlock()
+
+	if listener, ok := m.standby[port]; ok {
+		_ = listener.Close()
+		delete(m.standby, port)
+	}
+}
+
+// StopAllStandby closes every fallback listener.
+func (m *SOCKSManager) StopAllStandby() {
+	m.standbyMu.Lock()
+	defer m.standbyMu.Unlock()
+
+	for port, listener := range m.standby {
+		_ = listener.Close()
+		delete(m.standby, port)
+	}
+}
+
+// createRouteDialer relays each connection through the client router picks
+// for its target, counting it against that client's lowest port.
+func (m *SOCKSManager) createRouteDialer(router *Router) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, err := router.Route(addr)
 		if err != nil {
-			m.logger.Error("Failed to open yamux stream", "error", err)
+			m.logger.Debug("Routed connection rejected", "addr", addr, "error", err)
+			m.recordFailure(m.newAccessEntry(ctx, 0, addr), CloseReasonNoRoute, err)
 			return nil, err
 		}
-
-		if err := common.SetReadDeadline(stream, 5*time.Second); err != nil {
-			_ = stream.Close()
+		sel, err := ParseClientSelector(client)
+		if err != nil {
+			m.recordFailure(m.newAccessEntry(ctx, 0, addr), CloseReasonNoRoute, err)
+			return nil, err
+		}
+		port, sess, ok := m.registry.SelectClient(sel)
+		if !ok {
+			err := fmt.Errorf("client %q for target %s is not connected", client, addr)
+			m.logger.Warn("Routed client not connected", "addr", addr, "client", client)
+			m.recordFailure(m.newAccessEntry(ctx, 0, addr), CloseReasonNoRoute, err)
 			return nil, err
 		}
+		return m.dial(ctx, port, sess, addr)
+	}
+}
+
+// dial opens a stream to the client on sess and asks it to connect to addr.
+func (m *SOCKSManager) dial(ctx context.Context, port int, sess transport.Session, addr string) (net.Conn, error) {
+	var entry AccessEntry
+	if m.accessLog != nil {
+		entry = m.newAccessEntry(ctx, port, addr)
+	}
 
-		if err := proto.WriteConnectReq(stream, addr); err != nil {
+	breaker := m.registry.Breaker(port)
+	if breaker != nil && !breaker.Allow() {
+		err := fmt.Errorf("circuit breaker of port %d is open", port)
+		m.recordFailure(entry, CloseReasonBreakerOpen, err)
+		return nil, err
+	}
+
+	// Try to increment connection count before opening stream
+	if !m.registry.IncrementConnections(port) {
+		if breaker != nil {
+			breaker.Cancel()
+		}
+		m.logger.Warn("Per-client connection limit reached",
+			"port", port,
+			"current", m.registry.GetConnectionCount(port))
+		err := fmt.Errorf("connection limit reached for client")
+		m.recordFailure(entry, CloseReasonLimitReached, err)
+		return nil, err
+	}
+
+	// Ensure decrement happens when connection closes, and count failures
+	// to open the stream, or the client's network failing, against the breaker
+	meta, _ := m.registry.GetClientMeta(port)
+	decremented := false
+	settled := false
+	defer func() {
+		if decremented {
+			return
+		}
+		m.registry.DecrementConnections(port)
+		if breaker != nil && !settled && breaker.Failure() {
+			m.logger.Warn("Circuit breaker opened", "port", port, "client_name", meta.ClientName)
+		}
+	}()
+
+	stream, err := sess.Open()
+	if err != nil {
+		m.logger.Error("Failed to open session stream", "error", err)
+		m.recordFailure(entry, CloseReasonDialFailed, err)
+		return nil, err
+	}
+
+	if err := common.SetReadDeadline(stream, 5*time.Second); err != nil {
+		_ = stream.Close()
+		m.recordFailure(entry, CloseReasonDialFailed, err)
+		return nil, err
+	}
+
+	if meta.Capabilities.Has(proto.CapConnectPort) {
+		err = proto.WriteConnectReqPort(stream, port, addr)
+	} else {
+		err = proto.WriteConnectReq(stream, addr)
+	}
+	if err != nil {
+		_ = stream.Close()
+		m.logger.Error("Failed to write CONNECT_REQ", "addr", addr, "error", err)
+		m.recordFailure(entry, CloseReasonDialFailed, err)
+		return nil, err
+	}
+
+	// Clients reporting connect status answer once they have dialed the
+	// target, which settles the breaker; for others only data from the
+	// target tells that it was reached
+	connectStatus := meta.Capabilities.Has(proto.CapConnectStatus)
+	if connectStatus {
+		if err := common.SetReadDeadline(stream, connectStatusTimeout); err != nil {
 			_ = stream.Close()
-			m.logger.Error("Failed to write CONNECT_REQ", "addr", addr, "error", err)
+			m.recordFailure(entry, CloseReasonDialFailed, err)
 			return nil, err
 		}
-
-		if err := common.ClearDeadline(stream); err != nil {
+		err := proto.ReadConnectResp(stream)
+		var connErr *proto.ConnectError
+		if err == nil || errors.As(err, &connErr) && !connErr.ExitFailure() {
+			settled = true
+			m.settleBreaker(breaker, port, meta.ClientName, connErr)
+		}
+		if err != nil {
 			_ = stream.Close()
+			reason := CloseReasonDialFailed
+			if connErr != nil && connErr.Status == proto.ConnectQuota {
+				reason = CloseReasonQuota
+				m.logger.Warn("Client refused stream, data quota exhausted", "port", port, "client_name", meta.ClientName)
+			} else {
+				m.logger.Debug("Client could not connect to target", "port", port, "addr", addr, "error", err)
+			}
+			m.recordFailure(entry, reason, err)
 			return nil, err
 		}
+	}
+
+	if err := common.ClearDeadline(stream); err != nil {
+		_ = stream.Close()
+		m.recordFailure(entry, CloseReasonDialFailed, err)
+		return nil, err
+	}
 
-		// Wrap the stream to decrement on close
-		decremented = true
-		return &connCountingStream{
-			Conn:     stream,
-			port:     port,
-			registry: m.registry,
-			logger:   m.logger,
-		}, nil
+	// Wrap the stream to decrement on close
+	decremented = true
+	counted := &connCountingStream{
+		Conn:       stream,
+		port:       port,
+		registry:   m.registry,
+		logger:     m.logger,
+		session:    sess,
+		entry:      entry,
+		accessLog:  m.accessLog,
+		accounting: m.accounting,
+		clientName: meta.ClientName,
+	}
+	if !connectStatus {
+		counted.breaker = breaker
+	}
+	var conn net.Conn = counted
+	if m.accounting != nil {
+		m.accounting.AddConnection(meta.ClientName, port)
+		counted.usage = m.accounting.track(meta.ClientName, port, &counted.bytesUp, &counted.bytesDown)
+	}
+	if m.bandwidth != nil {
+		conn = m.bandwidth.Wrap(conn, port, meta, sess)
+	}
+	return conn, nil
+}
+
+// settleBreaker tells breaker the outcome of a connection the client
+// answered with connErr (nil for ConnectOK). Reaching the target, or having
+// it refuse, shows the client's network works; a refusal by the client's own
+// policy or quota says nothing about it.
+func (m *SOCKSManager) settleBreaker(breaker *CircuitBreaker, port int, clientName string, connErr *proto.ConnectError) {
+	if breaker == nil {
+		return
+	}
+	if connErr != nil && (connErr.Status == proto.ConnectDenied || connErr.Status == proto.ConnectQuota) {
+		breaker.Cancel()
+		return
+	}
+	if breaker.Success() {
+		m.logger.Info("Circuit breaker closed", "port", port, "client_name", clientName)
 	}
 }
 
 // StartListener creates and starts a SOCKS5 server on the specified port.
-func (m *SOCKSManager) StartListener(port int, bindIP string, sess *yamux.Session) (net.Listener, error) {
+func (m *SOCKSManager) StartListener(port int, bindIP string, sess transport.Session) (net.Listener, error) {
+	return m.startSOCKS(fmt.Sprintf("%s:%d", bindIP, port), m.createDialer(port, sess), "port", port)
+}
+
+// StartRouteListener starts a SOCKS5 server on addr that relays every
+// connection through the client router picks for its target.
+func (m *SOCKSManager) StartRouteListener(addr string, router *Router) (net.Listener, error) {
+	return m.startSOCKS(addr, m.createRouteDialer(router), "route_listen", addr)
+}
+
+// startSOCKS serves SOCKS5 on addr, dialing targets with dial. logAttrs
+// identify the listener in log messages.
+func (m *SOCKSManager) startSOCKS(addr string, dial func(ctx context.Context, network, addr string) (net.Conn, error), logAttrs ...any) (net.Listener, error) {
 	conf := &socks5.Config{
-		Dial: m.createDialer(port, sess),
+		Dial:     dial,
+		Resolver: remoteResolver{},
+	}
+	if m.accessLog != nil {
+		conf.Rewriter = requestRecorder{}
 	}
 
 	server, err := socks5.New(conf)
@@ -110,20 +601,19 @@ func (m *SOCKSManager) StartListener(port int, bindIP string, sess *yamux.Sessio
 		return nil, fmt.Errorf("failed to create SOCKS5 server: %w", err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", bindIP, port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to bind SOCKS5 listener on %s: %w", addr, err)
 	}
 
 	go func() {
-		m.logger.Info("SOCKS5 listener started", "port", port)
+		m.logger.Info("SOCKS5 listener started", logAttrs...)
 		if err := server.Serve(listener); err != nil {
 			if opErr, ok := err.(*net.OpError); !ok || opErr.Err.Error() != "use of closed network connection" {
-				m.logger.Error("SOCKS5 server error", "port", port, "error", err)
+				m.logger.Error("SOCKS5 server error", append(logAttrs, "error", err)...)
 			}
 		}
-		m.logger.Info("SOCKS5 listener stopped", "port", port)
+		m.logger.Info("SOCKS5 listener stopped", logAttrs...)
 	}()
 
 	return listener, nil
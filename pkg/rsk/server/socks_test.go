@@ -596,3 +596,23 @@ func TestSOCKSManager_StartListener(t *testing.T) {
 	expectedAddr := fmt.Sprintf("127.0.0.1:%d", port)
 	assert.Equal(t, expectedAddr, socksListener.Addr().String())
 }
+
+func TestConnCountingStream_LocalAddr(t *testing.T) {
+	udpConn := &addrConn{local: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9528}}
+	stream := &connCountingStream{Conn: udpConn}
+	assert.Equal(t, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9528}, stream.LocalAddr())
+
+	pipe, other := net.Pipe()
+	defer func() { _ = pipe.Close() }()
+	defer func() { _ = other.Close() }()
+	stream = &connCountingStream{Conn: pipe}
+	assert.IsType(t, &net.TCPAddr{}, stream.LocalAddr())
+}
+
+// addrConn is a net.Conn that only reports a local address.
+type addrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr { return c.local }
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/common"
+	"github.com/tbxark/rsk/pkg/rsk/proto"
+)
+
+// HTTP paths of the status listener.
+const (
+	StatusPath       = "/status"
+	ClientStatsPath  = "/clients/stats"  // GET: traffic counters reported by each client
+	ClientReloadPath = "/clients/reload" // POST: make clients reload their domain lists, requires the server token
+	UsagePath        = "/usage"          // GET: traffic accounting report, including open connections
+)
+
+// controlCallTimeout bounds how long a control endpoint waits for clients.
+const controlCallTimeout = 10 * time.Second
+
+// ServerStatus is the JSON document served by the status endpoint.
+type ServerStatus struct {
+	Time  time.Time    `json:"time"`
+	Ports []SlotStatus `json:"ports"`
+}
+
+// NewStatusHandler returns an HTTP handler reporting the ports bound by
+// clients, their connections and health as JSON.
+func NewStatusHandler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(ServerStatus{Time: time.Now(), Ports: registry.Status()})
+	})
+}
+
+// NewControlHandler returns an HTTP handler that sends a control request for
+// method to every client, or to those named by the "client" query parameter,
+// and reports the answers as JSON.
+func NewControlHandler(registry *Registry, method, httpMethod string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != httpMethod {
+			w.Header().Set("Allow", httpMethod)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), controlCallTimeout)
+		defer cancel()
+		results := CallClients(ctx, registry, r.URL.Query().Get("client"), method)
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+	})
+}
+
+// RequireToken returns an HTTP handler that passes requests to next only
+// when they carry token as "Authorization: Bearer <token>".
+func RequireToken(token []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !common.TokenEqual([]byte(given), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewUsageHandler returns an HTTP handler reporting the live usage of
+// accounting. The "from" and "to" query parameters bound the window like
+// ParseUsageTime, and "format" is json (default) or csv.
+func NewUsageHandler(accounting *Accounting) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		from, err := ParseUsageTime(query.Get("from"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := ParseUsageTime(query.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := query.Get("format")
+		switch format {
+		case "", UsageFormatJSON:
+			format = UsageFormatJSON
+			w.Header().Set("Content-Type", "application/json")
+		case UsageFormatCSV:
+			w.Header().Set("Content-Type", "text/csv")
+		default:
+			http.Error(w, fmt.Sprintf("invalid usage format %q: expected %s or %s", format, UsageFormatJSON, UsageFormatCSV), http.StatusBadRequest)
+			return
+		}
+		_ = WriteUsageReport(w, accounting.Report(from, to), format)
+	})
+}
+
+// startStatus serves the status endpoint until ctx is canceled. The usage
+// endpoint is served only with accounting enabled.
+func (s *Server) startStatus(ctx context.Context, accounting *Accounting) error {
+	listener, err := net.Listen("tcp", s.config.StatusListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.StatusListenAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(StatusPath, NewStatusHandler(s.registry))
+	mux.Handle(ClientStatsPath, NewControlHandler(s.registry, proto.MethodStats, http.MethodGet))
+	mux.Handle(ClientReloadPath, RequireToken(s.config.Token, NewControlHandler(s.registry, proto.MethodReload, http.MethodPost)))
+	if accounting != nil {
+		mux.Handle(UsagePath, NewUsageHandler(accounting))
+	}
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Status server error", "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	s.logger.Info("Status endpoint started", "address", listener.Addr().String(), "path", StatusPath)
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tbxark/rsk/pkg/rsk/transport"
+)
+
+// startWebSocket starts the HTTP(S) listener that upgrades requests on the
+// configured path to WebSocket connections. The returned listener yields those
+// connections and is closed, together with the HTTP server, when ctx is canceled.
+func (s *Server) startWebSocket(ctx context.Context) (net.Listener, error) {
+	tcpListener, err := net.Listen("tcp", s.config.WebSocketListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", s.config.WebSocketListenAddr, err)
+	}
+
+	scheme := transport.SchemeWS
+	if s.config.TLSEnabled() {
+		tlsConfig, err := s.config.LoadTLSConfig()
+		if err != nil {
+			_ = tcpListener.Close()
+			return nil, err
+		}
+		tcpListener = tls.NewListener(tcpListener, tlsConfig)
+		scheme = transport.SchemeWSS
+	}
+
+	path := s.config.WebSocketPath
+	if path == "" {
+		path = transport.DefaultWebSocketPath
+	}
+
+	wsListener := transport.NewWebSocketListener(tcpListener.Addr())
+	mux := http.NewServeMux()
+	mux.Handle(path, wsListener)
+
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := httpServer.Serve(tcpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("WebSocket server error", "error", err)
+		}
+		_ = wsListener.Close()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		_ = wsListener.Close()
+		_ = httpServer.Close()
+	}()
+
+	s.logger.Info("WebSocket listener started",
+		"address", tcpListener.Addr().String(),
+		"scheme", scheme,
+		"path", path)
+
+	return wsListener, nil
+}
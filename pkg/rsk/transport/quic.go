@@ -0,0 +1,323 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+const (
+	SchemeQUIC = "quic"
+
+	// QUICALPN is the ALPN protocol identifier negotiated by RSK QUIC endpoints.
+	QUICALPN = "rsk"
+)
+
+const (
+	quicKeepAlivePeriod = 30 * time.Second
+	quicMaxIdleTimeout  = 90 * time.Second
+	quicMaxStreams      = 1024
+)
+
+// IsQUICURL reports whether addr is a quic:// URL.
+func IsQUICURL(addr string) bool {
+	return strings.HasPrefix(strings.ToLower(addr), SchemeQUIC+"://")
+}
+
+// ParseQUICURL parses a quic://host:port URL and returns the host:port to dial.
+func ParseQUICURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid QUIC URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != SchemeQUIC {
+		return "", fmt.Errorf("invalid QUIC URL %q: scheme must be quic", rawURL)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return "", fmt.Errorf("invalid QUIC URL %q: expected quic://host:port", rawURL)
+	}
+	return u.Host, nil
+}
+
+func quicConfig(tlsConfig *tls.Config) *quic.Config {
+	cfg := tlsConfig.Clone()
+	cfg.MinVersion = tls.VersionTLS13
+	cfg.NextProtos = []string{QUICALPN}
+	return &quic.Config{
+		TLSConfig:            cfg,
+		MaxBidiRemoteStreams: quicMaxStreams,
+		MaxIdleTimeout:       quicMaxIdleTimeout,
+		KeepAlivePeriod:      quicKeepAlivePeriod,
+	}
+}
+
+// QUICListener accepts QUIC connections on a UDP address.
+type QUICListener struct {
+	endpoint *quic.Endpoint
+}
+
+// ListenQUIC listens for QUIC connections on the UDP address addr.
+// tlsConfig must contain the server certificate.
+func ListenQUIC(addr string, tlsConfig *tls.Config) (*QUICListener, error) {
+	endpoint, err := quic.Listen("udp", addr, quicConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	return &QUICListener{endpoint: endpoint}, nil
+}
+
+// Accept waits for the next QUIC connection and returns it as a Session.
+func (l *QUICListener) Accept(ctx context.Context) (*QUICSession, error) {
+	conn, err := l.endpoint.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newQUICSession(conn, nil), nil
+}
+
+// Addr returns the local UDP address of the listener.
+func (l *QUICListener) Addr() net.Addr {
+	return net.UDPAddrFromAddrPort(l.endpoint.LocalAddr())
+}
+
+// Close closes the listener and all connections accepted from it.
+func (l *QUICListener) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return l.endpoint.Close(ctx)
+}
+
+// DialQUIC connects to the QUIC server at addr (host:port).
+func DialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config) (*QUICSession, error) {
+	endpoint, err := quic.Listen("udp", ":0", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := quicConfig(tlsConfig)
+	if cfg.TLSConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			_ = endpoint.Close(context.Background())
+			return nil, err
+		}
+		cfg.TLSConfig.ServerName = host
+	}
+
+	conn, err := endpoint.Dial(ctx, "udp", addr, cfg)
+	if err != nil {
+		_ = endpoint.Close(context.Background())
+		return nil, err
+	}
+	return newQUICSession(conn, endpoint), nil
+}
+
+// QUICSession implements Session on top of a QUIC connection. Each stream is
+// a native QUIC stream, so loss on one stream does not stall the others.
+type QUICSession struct {
+	conn     *quic.Conn
+	endpoint *quic.Endpoint // Owned endpoint for dialed sessions, nil for accepted ones
+
+	numStreams int32
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newQUICSession(conn *quic.Conn, endpoint *quic.Endpoint) *QUICSession {
+	s := &QUICSession{
+		conn:     conn,
+		endpoint: endpoint,
+		closed:   make(chan struct{}),
+	}
+	go func() {
+		_ = conn.Wait(context.Background())
+		s.markClosed()
+	}()
+	return s
+}
+
+func (s *QUICSession) markClosed() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.endpoint != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = s.endpoint.Close(ctx)
+		}
+	})
+}
+
+// Open opens a new bidirectional stream.
+func (s *QUICSession) Open() (net.Conn, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+	stream, err := s.conn.NewStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return s.wrapStream(stream), nil
+}
+
+// Accept waits for the next stream opened by the peer.
+func (s *QUICSession) Accept() (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := s.conn.AcceptStream(ctx)
+	if err != nil {
+		if s.IsClosed() {
+			return nil, ErrSessionClosed
+		}
+		return nil, err
+	}
+	return s.wrapStream(stream), nil
+}
+
+// Close closes the connection and all of its streams.
+func (s *QUICSession) Close() error {
+	s.conn.Abort(nil)
+	s.markClosed()
+	return nil
+}
+
+// CloseChan returns a channel that is closed when the connection ends.
+func (s *QUICSession) CloseChan() <-chan struct{} {
+	return s.closed
+}
+
+// IsClosed reports whether the connection has ended.
+func (s *QUICSession) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *QUICSession) NumStreams() int {
+	return int(atomic.LoadInt32(&s.numStreams))
+}
+
+// RemoteAddr returns the peer's UDP address.
+func (s *QUICSession) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(s.conn.RemoteAddr())
+}
+
+func (s *QUICSession) wrapStream(stream *quic.Stream) net.Conn {
+	atomic.AddInt32(&s.numStreams, 1)
+	return &quicStream{
+		Stream:  stream,
+		session: s,
+		local:   udpAddr(s.conn.LocalAddr()),
+		remote:  udpAddr(s.conn.RemoteAddr()),
+	}
+}
+
+// quicStream adapts a QUIC stream to net.Conn. Writes are flushed immediately
+// and deadlines are implemented with stream contexts. As with the underlying
+// stream, deadlines must not be changed concurrently with Read or Write.
+type quicStream struct {
+	*quic.Stream
+	session *QUICSession
+	local   net.Addr
+	remote  net.Addr
+
+	mu          sync.Mutex
+	readCancel  context.CancelFunc
+	writeCancel context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+func (c *quicStream) Write(b []byte) (int, error) {
+	n, err := c.Stream.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.Stream.Flush()
+}
+
+// Close aborts reading and finishes the write side without waiting for the
+// peer to acknowledge the remaining data.
+func (c *quicStream) Close() error {
+	c.closeOnce.Do(func() {
+		c.Stream.CloseRead()
+		c.Stream.CloseWrite()
+		atomic.AddInt32(&c.session.numStreams, -1)
+
+		c.mu.Lock()
+		if c.readCancel != nil {
+			c.readCancel()
+		}
+		if c.writeCancel != nil {
+			c.writeCancel()
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *quicStream) LocalAddr() net.Addr  { return c.local }
+func (c *quicStream) RemoteAddr() net.Addr { return c.remote }
+
+func (c *quicStream) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *quicStream) SetReadDeadline(t time.Time) error {
+	ctx, cancel := deadlineContext(t)
+	c.mu.Lock()
+	if c.readCancel != nil {
+		c.readCancel()
+	}
+	c.readCancel = cancel
+	c.mu.Unlock()
+	c.Stream.SetReadContext(ctx)
+	return nil
+}
+
+func (c *quicStream) SetWriteDeadline(t time.Time) error {
+	ctx, cancel := deadlineContext(t)
+	c.mu.Lock()
+	if c.writeCancel != nil {
+		c.writeCancel()
+	}
+	c.writeCancel = cancel
+	c.mu.Unlock()
+	c.Stream.SetWriteContext(ctx)
+	return nil
+}
+
+func deadlineContext(t time.Time) (context.Context, context.CancelFunc) {
+	if t.IsZero() {
+		return context.Background(), nil
+	}
+	return context.WithDeadline(context.Background(), t)
+}
+
+func udpAddr(ap netip.AddrPort) net.Addr {
+	return net.UDPAddrFromAddrPort(ap)
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Both QUIC and yamux sessions must satisfy Session.
+var (
+	_ Session = (*QUICSession)(nil)
+	_ Session = (*yamux.Session)(nil)
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestParseQUICURL(t *testing.T) {
+	addr, err := ParseQUICURL("quic://example.com:9528")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:9528", addr)
+
+	_, err = ParseQUICURL("quic://example.com")
+	assert.Error(t, err)
+
+	_, err = ParseQUICURL("ws://example.com:9528")
+	assert.Error(t, err)
+
+	assert.True(t, IsQUICURL("QUIC://example.com:9528"))
+	assert.False(t, IsQUICURL("example.com:9528"))
+}
+
+func TestQUICSessionStreams(t *testing.T) {
+	listener, err := ListenQUIC("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+	})
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	accepted := make(chan *QUICSession, 1)
+	go func() {
+		sess, err := listener.Accept(ctx)
+		if err == nil {
+			accepted <- sess
+		}
+	}()
+
+	clientSess, err := DialQUIC(ctx, listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer func() { _ = clientSess.Close() }()
+
+	var serverSess *QUICSession
+	select {
+	case serverSess = <-accepted:
+	case <-ctx.Done():
+		t.Fatal("server did not accept QUIC connection")
+	}
+
+	// Client-opened stream becomes visible to the server once data is sent
+	stream, err := clientSess.Open()
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	serverStream, err := serverSess.Accept()
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	require.NoError(t, serverStream.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = io.ReadFull(serverStream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	_, err = serverStream.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+
+	assert.Equal(t, 1, clientSess.NumStreams())
+	require.NoError(t, stream.Close())
+	assert.Equal(t, 0, clientSess.NumStreams())
+
+	require.NoError(t, clientSess.Close())
+	assert.True(t, clientSess.IsClosed())
+
+	select {
+	case <-serverSess.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("server session did not observe close")
+	}
+}
+
+func TestQUICStreamReadDeadline(t *testing.T) {
+	listener, err := ListenQUIC("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+	})
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		_, _ = listener.Accept(ctx)
+	}()
+
+	sess, err := DialQUIC(ctx, listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer func() { _ = sess.Close() }()
+
+	stream, err := sess.Open()
+	require.NoError(t, err)
+	defer func() { _ = stream.Close() }()
+
+	require.NoError(t, stream.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = stream.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,26 @@
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrSessionClosed is returned when operating on a session that has ended.
+var ErrSessionClosed = errors.New("session closed")
+
+// Session is a multiplexed connection between server and client. Every proxied
+// connection runs as its own stream. *yamux.Session implements Session.
+type Session interface {
+	// Open opens a new outgoing stream.
+	Open() (net.Conn, error)
+	// Accept waits for the next stream opened by the peer.
+	Accept() (net.Conn, error)
+	// Close closes the session and all of its streams.
+	Close() error
+	// CloseChan returns a channel that is closed when the session ends.
+	CloseChan() <-chan struct{}
+	// IsClosed reports whether the session has ended.
+	IsClosed() bool
+	// NumStreams returns the number of currently open streams.
+	NumStreams() int
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	SchemeWS  = "ws"
+	SchemeWSS = "wss"
+
+	// DefaultWebSocketPath is the HTTP path the server serves the RSK handshake on.
+	DefaultWebSocketPath = "/rsk"
+)
+
+// ErrListenerClosed is returned by Accept after the listener has been closed.
+var ErrListenerClosed = errors.New("listener closed")
+
+// DialFunc dials a network address, typically (*net.Dialer).DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// IsWebSocketURL reports whether addr is a ws:// or wss:// URL.
+func IsWebSocketURL(addr string) bool {
+	lower := strings.ToLower(addr)
+	return strings.HasPrefix(lower, SchemeWS+"://") || strings.HasPrefix(lower, SchemeWSS+"://")
+}
+
+// ParseWebSocketURL parses and validates a ws:// or wss:// URL.
+func ParseWebSocketURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != SchemeWS && u.Scheme != SchemeWSS {
+		return nil, fmt.Errorf("invalid WebSocket URL %q: scheme must be ws or wss", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid WebSocket URL %q: missing host", rawURL)
+	}
+	return u, nil
+}
+
+// WebSocketHostPort returns the host:port to dial for a WebSocket URL,
+// filling in the default port for the scheme.
+func WebSocketHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == SchemeWSS {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// DialWebSocket opens a WebSocket connection to rawURL and returns it as a
+// binary-framed net.Conn. The underlying TCP connection is created with dial,
+// and wrapped in TLS for wss:// URLs.
+func DialWebSocket(ctx context.Context, rawURL string, tlsConfig *tls.Config, dial DialFunc) (net.Conn, error) {
+	u, err := ParseWebSocketURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := "http://" + u.Host
+	if u.Scheme == SchemeWSS {
+		origin = "https://" + u.Host
+	}
+
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket config: %w", err)
+	}
+
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", WebSocketHostPort(u))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == SchemeWSS {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	// websocket.NewClient has no context, so bound the upgrade with a deadline.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake failed: %w", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = ws.Close()
+		return nil, err
+	}
+
+	return &wsConn{Conn: ws, local: conn.LocalAddr(), remote: conn.RemoteAddr()}, nil
+}
+
+// wsConn adapts a websocket.Conn so that LocalAddr and RemoteAddr report
+// the transport addresses instead of the WebSocket origin and location.
+type wsConn struct {
+	*websocket.Conn
+	local  net.Addr
+	remote net.Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.local }
+func (c *wsConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	if c.closed != nil {
+		c.closeOnce.Do(func() { close(c.closed) })
+	}
+	return err
+}
+
+// WebSocketListener is a net.Listener that receives connections from
+// WebSocket upgrades. Mount it as an http.Handler on the desired path.
+type WebSocketListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewWebSocketListener creates a listener reporting addr as its address.
+func NewWebSocketListener(addr net.Addr) *WebSocketListener {
+	return &WebSocketListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and hands it to Accept.
+// It blocks until the connection is closed, as required by the websocket package.
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+
+			conn := &wsConn{
+				Conn:   ws,
+				local:  l.addr,
+				remote: parseRemoteAddr(req.RemoteAddr),
+				closed: make(chan struct{}),
+			}
+
+			select {
+			case l.conns <- conn:
+			case <-l.done:
+				return
+			}
+
+			<-conn.closed
+		},
+	}
+	server.ServeHTTP(w, req)
+}
+
+// Accept waits for and returns the next upgraded connection.
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops Accept. Connections already accepted are unaffected.
+func (l *WebSocketListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the listener's network address.
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+func parseRemoteAddr(remoteAddr string) net.Addr {
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
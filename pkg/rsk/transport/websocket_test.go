@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWebSocketURL(t *testing.T) {
+	assert.True(t, IsWebSocketURL("ws://example.com/rsk"))
+	assert.True(t, IsWebSocketURL("WSS://example.com/rsk"))
+	assert.False(t, IsWebSocketURL("example.com:9527"))
+	assert.False(t, IsWebSocketURL("http://example.com"))
+}
+
+func TestParseWebSocketURL(t *testing.T) {
+	u, err := ParseWebSocketURL("wss://example.com/rsk")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", WebSocketHostPort(u))
+
+	u, err = ParseWebSocketURL("ws://example.com:8080/rsk")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", WebSocketHostPort(u))
+
+	_, err = ParseWebSocketURL("http://example.com")
+	assert.Error(t, err)
+
+	_, err = ParseWebSocketURL("ws:///rsk")
+	assert.Error(t, err)
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	listener := NewWebSocketListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	srv := httptest.NewServer(listener)
+	defer srv.Close()
+	defer func() { _ = listener.Close() }()
+
+	// Echo every accepted connection back to the sender
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/rsk"
+	conn, err := DialWebSocket(ctx, wsURL, nil, nil)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	assert.Equal(t, srv.Listener.Addr().String(), conn.RemoteAddr().String())
+
+	payload := []byte("hello over websocket")
+	_, err = conn.Write(payload)
+	require.NoError(t, err)
+
+	// Read in small chunks to verify partial frame reads
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	got := make([]byte, 0, len(payload))
+	buf := make([]byte, 4)
+	for len(got) < len(payload) {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		got = append(got, buf[:n]...)
+	}
+	assert.Equal(t, payload, got)
+}
+
+func TestWebSocketListenerRemoteAddr(t *testing.T) {
+	listener := NewWebSocketListener(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	srv := httptest.NewServer(listener)
+	defer srv.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws://" + strings.TrimPrefix(srv.URL, "http://")
+	conn, err := DialWebSocket(ctx, wsURL, nil, nil)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	select {
+	case serverConn := <-accepted:
+		defer func() { _ = serverConn.Close() }()
+		host, _, err := net.SplitHostPort(serverConn.RemoteAddr().String())
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", host)
+	case <-time.After(2 * time.Second):
+		t.Fatal("listener did not accept connection")
+	}
+}
+
+func TestWebSocketListenerClose(t *testing.T) {
+	listener := NewWebSocketListener(&net.TCPAddr{})
+	require.NoError(t, listener.Close())
+	require.NoError(t, listener.Close())
+
+	_, err := listener.Accept()
+	assert.ErrorIs(t, err, ErrListenerClosed)
+}